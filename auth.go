@@ -0,0 +1,145 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ErrNoCredentials is returned by authenticators when a request carries no credentials
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	ID          string            `json:"id"`                    // Caller identity, e.g. the user public nkey
+	Name        string            `json:"name,omitempty"`        // Display name
+	Account     string            `json:"account,omitempty"`     // Account the caller belongs to
+	Roles       []string          `json:"roles,omitempty"`       // Roles granted to the caller
+	Permissions []string          `json:"permissions,omitempty"` // Permissions granted to the caller
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// HasRole reports whether the principal has the given role
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// HasPermission reports whether the principal has the given permission
+func (p *Principal) HasPermission(permission string) bool {
+	return slices.Contains(p.Permissions, permission)
+}
+
+// Authenticator verifies the credentials carried by a request and returns the caller principal.
+// It returns ErrNoCredentials if the request carries no credentials.
+// Returning a *ServiceError sends it to the client, any other error is sent as "401 unauthorized".
+type Authenticator interface {
+	Authenticate(ctx context.Context, request micro.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc is a function implementing Authenticator
+type AuthenticatorFunc func(ctx context.Context, request micro.Request) (*Principal, error)
+
+// Authenticate calls f
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, request micro.Request) (*Principal, error) {
+	return f(ctx, request)
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, or nil
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// RequestPrincipal returns the authenticated caller of a request, or nil
+func RequestPrincipal(request micro.Request) *Principal {
+	return PrincipalFromContext(RequestContext(request))
+}
+
+// authMiddleware authenticates requests with authenticator and checks the roles and permissions
+// required by the endpoint. Unauthenticated requests are rejected with "401 unauthorized",
+// unauthorized ones with "403 forbidden", before the endpoint handler runs.
+func authMiddleware(authenticator Authenticator, config *EndpointConfig) Middleware {
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(request micro.Request) {
+			ctx := RequestContext(request)
+			log := LoggerFromContext(ctx)
+
+			principal, err := authenticator.Authenticate(ctx, request)
+			if err != nil {
+				if config.AllowAnonymous && errors.Is(err, ErrNoCredentials) {
+					next.Handle(request)
+					return
+				}
+				log.Warn("authentication failed", "error", err)
+				var serviceErr *ServiceError
+				if !errors.As(err, &serviceErr) {
+					serviceErr = NewServiceError(CodeUnauthorized, "unauthorized")
+				}
+				RespondError(request, serviceErr)
+				return
+			}
+
+			if !authorized(principal, config) {
+				log.Warn("authorization failed", "principal", principal.ID)
+				RespondError(request, NewServiceError(CodeForbidden, "forbidden"))
+				return
+			}
+
+			ctx = ContextWithPrincipal(ctx, principal)
+			ctx = ContextWithLogger(ctx, log.With("principal", principal.ID))
+			next.Handle(WithRequestContext(request, ctx))
+		})
+	}
+}
+
+// authorized reports whether principal has all the roles and permissions required by the endpoint
+func authorized(principal *Principal, config *EndpointConfig) bool {
+	for _, role := range config.RequiredRoles {
+		if !principal.HasRole(role) {
+			return false
+		}
+	}
+	for _, permission := range config.RequiredPermissions {
+		if !principal.HasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestOption customizes a request message before it is sent, e.g. to attach credentials
+type RequestOption func(msg *nats.Msg) error
+
+// WithHeader sets a header on the request message
+func WithHeader(key, value string) RequestOption {
+	return func(msg *nats.Msg) error {
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(key, value)
+		return nil
+	}
+}
+
+// applyRequestOptions applies opts to msg
+func applyRequestOptions(msg *nats.Msg, opts []RequestOption) error {
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if err := opt(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package natsservice
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/nats-io/nkeys"
+)
+
+// Authentication headers
+const (
+	AuthNkeyHeader          = "Nats-Auth-Nkey"           // Caller public user nkey
+	AuthJWTHeader           = "Nats-Auth-Jwt"            // Caller user JWT
+	AuthTimestampHeader     = "Nats-Auth-Timestamp"      // Signature time (RFC 3339)
+	AuthNonceHeader         = "Nats-Auth-Nonce"          // Random value making each signature unique
+	AuthSignedHeadersHeader = "Nats-Auth-Signed-Headers" // Comma separated names of the headers covered by the signature
+	AuthSignatureHeader     = "Nats-Auth-Signature"      // Signature of the challenge (base64 url encoding)
+)
+
+// DefaultMaxClockSkew is the default accepted difference between the signature time and the service clock
+const DefaultMaxClockSkew = time.Minute
+
+// signatureChallenge returns the signed challenge : timestamp, nonce, subject, reply subject,
+// and sha256 of the signed headers and of the payload
+func signatureChallenge(timestamp, nonce, subject, reply string, signedHeaders []string, header func(name string) []string, data []byte) []byte {
+	headers := sha256.New()
+	for _, name := range signedHeaders {
+		fmt.Fprintf(headers, "%s:%s\n", name, strings.Join(header(name), ","))
+	}
+	digest := sha256.Sum256(data)
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%s\n%x\n%x", timestamp, nonce, subject, reply, headers.Sum(nil), digest))
+}
+
+// signRequest sets the timestamp, nonce and signature headers of msg, signed by kp.
+// The signature covers the headers already set on msg and its reply subject: a request without
+// reply subject gets its own inbox, as the shared response subscription sets it after signing.
+func signRequest(msg *nats.Msg, kp nkeys.KeyPair) error {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	if msg.Reply == "" {
+		msg.Reply = nats.NewInbox()
+	}
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(random[:])
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var signedHeaders []string
+	for name := range msg.Header {
+		switch name {
+		case AuthTimestampHeader, AuthNonceHeader, AuthSignedHeadersHeader, AuthSignatureHeader:
+		default:
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	slices.Sort(signedHeaders)
+
+	challenge := signatureChallenge(timestamp, nonce, msg.Subject, msg.Reply, signedHeaders, func(name string) []string {
+		return msg.Header[name]
+	}, msg.Data)
+	signature, err := kp.Sign(challenge)
+	if err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	msg.Header.Set(AuthTimestampHeader, timestamp)
+	msg.Header.Set(AuthNonceHeader, nonce)
+	msg.Header.Set(AuthSignedHeadersHeader, strings.Join(signedHeaders, ","))
+	msg.Header.Set(AuthSignatureHeader, base64.RawURLEncoding.EncodeToString(signature))
+	return nil
+}
+
+// verifySignature checks the request signature was made by publicKey within maxClockSkew,
+// and that its nonce was not used by a previous request
+func verifySignature(request micro.Request, publicKey string, maxClockSkew time.Duration, nonces *nonceCache) error {
+	headers := request.Headers()
+	timestamp := headers.Get(AuthTimestampHeader)
+	signedAt, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if maxClockSkew <= 0 {
+		maxClockSkew = DefaultMaxClockSkew
+	}
+	if skew := time.Since(signedAt); skew > maxClockSkew || skew < -maxClockSkew {
+		return errors.New("signature expired")
+	}
+	nonce := headers.Get(AuthNonceHeader)
+	if nonce == "" {
+		return errors.New("missing signature nonce")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(headers.Get(AuthSignatureHeader))
+	if err != nil {
+		return errors.New("invalid signature encoding")
+	}
+	kp, err := nkeys.FromPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	var signedHeaders []string
+	if value := headers.Get(AuthSignedHeadersHeader); value != "" {
+		signedHeaders = strings.Split(value, ",")
+	}
+	challenge := signatureChallenge(timestamp, nonce, request.Subject(), request.Reply(), signedHeaders, func(name string) []string {
+		return headers[name]
+	}, request.Data())
+	if err := kp.Verify(challenge, signature); err != nil {
+		return errors.New("invalid signature")
+	}
+
+	// A nonce is remembered as long as its signature is not expired, to reject the replays
+	if !nonces.add(publicKey+"."+nonce, signedAt.Add(maxClockSkew)) {
+		return errors.New("signature already used")
+	}
+	return nil
+}
+
+// nonceCache remembers the nonces of the verified signatures until they expire.
+// The zero value is ready to use.
+type nonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time // expiry by nonce
+	nextPrune time.Time
+}
+
+// add records nonce until expires, it returns false if nonce is already recorded
+func (c *nonceCache) add(nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.nonces == nil {
+		c.nonces = make(map[string]time.Time)
+	}
+	if now.After(c.nextPrune) {
+		for key, expiry := range c.nonces {
+			if now.After(expiry) {
+				delete(c.nonces, key)
+			}
+		}
+		c.nextPrune = now.Add(time.Second)
+	}
+	if expiry, ok := c.nonces[nonce]; ok && !now.After(expiry) {
+		return false
+	}
+	c.nonces[nonce] = expires
+	return true
+}
+
+// WithNkey signs the request with a user nkey, for services using an NkeyAuthenticator.
+// The signature covers the headers set before it: pass it after the options setting headers.
+func WithNkey(kp nkeys.KeyPair) RequestOption {
+	return func(msg *nats.Msg) error {
+		publicKey, err := kp.PublicKey()
+		if err != nil {
+			return fmt.Errorf("failed to get public key: %w", err)
+		}
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(AuthNkeyHeader, publicKey)
+		return signRequest(msg, kp)
+	}
+}
+
+// WithUserJWT attaches a user JWT to the request and signs it with the user nkey (the JWT subject),
+// for services using a JWTAuthenticator. The signature covers the headers set before it, as for WithNkey.
+func WithUserJWT(userJWT string, kp nkeys.KeyPair) RequestOption {
+	return func(msg *nats.Msg) error {
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(AuthJWTHeader, userJWT)
+		return signRequest(msg, kp)
+	}
+}
+
+// NkeyAuthenticator authenticates requests signed with a user nkey (see WithNkey).
+// The caller signs a challenge made of a timestamp, a nonce, the subject, the reply subject
+// and the digests of the headers and payload. Signatures older than MaxClockSkew are rejected,
+// as well as the signatures replayed within MaxClockSkew, whose nonce was already seen by the authenticator.
+type NkeyAuthenticator struct {
+	lookup       func(ctx context.Context, publicKey string) (*Principal, error)
+	MaxClockSkew time.Duration
+	nonces       nonceCache
+}
+
+var _ Authenticator = (*NkeyAuthenticator)(nil)
+
+// NewNkeyAuthenticator creates an authenticator resolving the principal of a verified public key with lookup.
+// lookup returns an error for unknown keys.
+func NewNkeyAuthenticator(lookup func(ctx context.Context, publicKey string) (*Principal, error)) *NkeyAuthenticator {
+	return &NkeyAuthenticator{
+		lookup:       lookup,
+		MaxClockSkew: DefaultMaxClockSkew,
+	}
+}
+
+// Authenticate verifies the nkey signature of the request and resolves its principal
+func (a *NkeyAuthenticator) Authenticate(ctx context.Context, request micro.Request) (*Principal, error) {
+	publicKey := request.Headers().Get(AuthNkeyHeader)
+	if publicKey == "" {
+		return nil, ErrNoCredentials
+	}
+	if !nkeys.IsValidPublicUserKey(publicKey) {
+		return nil, errors.New("invalid user public key")
+	}
+	if err := verifySignature(request, publicKey, a.MaxClockSkew, &a.nonces); err != nil {
+		return nil, err
+	}
+	principal, err := a.lookup(ctx, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unknown key %s: %w", publicKey, err)
+	}
+	if principal == nil {
+		return nil, fmt.Errorf("unknown key %s", publicKey)
+	}
+	return principal, nil
+}
+
+// JWTAuthenticator authenticates requests carrying a NATS user JWT (see WithUserJWT).
+// The JWT must be issued by a trusted account key or by one of its registered signing keys (see AddSigningKeys),
+// valid, and the request signed by the JWT subject as for NkeyAuthenticator, replayed signatures being rejected.
+//
+// The principal roles and permissions are read from the JWT tags : "role:<name>" and "perm:<name>".
+// Note that NATS lower cases JWT tags.
+type JWTAuthenticator struct {
+	trustedIssuers []string
+	signingKeys    map[string][]string // signing keys by trusted account
+	MaxClockSkew   time.Duration
+	nonces         nonceCache
+}
+
+var _ Authenticator = (*JWTAuthenticator)(nil)
+
+// NewJWTAuthenticator creates an authenticator accepting user JWTs issued by the given account public keys
+func NewJWTAuthenticator(trustedIssuers ...string) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		trustedIssuers: trustedIssuers,
+		signingKeys:    make(map[string][]string),
+		MaxClockSkew:   DefaultMaxClockSkew,
+	}
+}
+
+// AddSigningKeys accepts the user JWTs issued on behalf of the trusted account by the given signing keys.
+// A JWT naming an account in issuer_account is only accepted if its issuer is one of the account signing keys,
+// as listed in the account JWT. AddSigningKeys must be called before the authenticator is used.
+func (a *JWTAuthenticator) AddSigningKeys(account string, signingKeys ...string) *JWTAuthenticator {
+	a.signingKeys[account] = append(a.signingKeys[account], signingKeys...)
+	return a
+}
+
+// jwtAccount returns the trusted account having issued claims
+func (a *JWTAuthenticator) jwtAccount(claims *jwt.UserClaims) (string, error) {
+	if claims.IssuerAccount == "" || claims.IssuerAccount == claims.Issuer {
+		if !slices.Contains(a.trustedIssuers, claims.Issuer) {
+			return "", fmt.Errorf("untrusted jwt issuer %s", claims.Issuer)
+		}
+		return claims.Issuer, nil
+	}
+	if !slices.Contains(a.trustedIssuers, claims.IssuerAccount) {
+		return "", fmt.Errorf("untrusted jwt issuer account %s", claims.IssuerAccount)
+	}
+	if !slices.Contains(a.signingKeys[claims.IssuerAccount], claims.Issuer) {
+		return "", fmt.Errorf("jwt issuer %s is not a signing key of account %s", claims.Issuer, claims.IssuerAccount)
+	}
+	return claims.IssuerAccount, nil
+}
+
+// Authenticate verifies the user JWT and request signature, and builds the principal from the JWT claims
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, request micro.Request) (*Principal, error) {
+	token := request.Headers().Get(AuthJWTHeader)
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user jwt: %w", err)
+	}
+	validation := jwt.CreateValidationResults()
+	claims.Validate(validation)
+	if validation.IsBlocking(true) {
+		return nil, fmt.Errorf("invalid user jwt: %v", validation.Errors())
+	}
+
+	account, err := a.jwtAccount(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(request, claims.Subject, a.MaxClockSkew, &a.nonces); err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{
+		ID:      claims.Subject,
+		Name:    claims.Name,
+		Account: account,
+	}
+	for _, tag := range claims.Tags {
+		if role, ok := strings.CutPrefix(tag, "role:"); ok {
+			principal.Roles = append(principal.Roles, role)
+		}
+		if permission, ok := strings.CutPrefix(tag, "perm:"); ok {
+			principal.Permissions = append(principal.Permissions, permission)
+		}
+	}
+	return principal, nil
+}
@@ -0,0 +1,275 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addWhoAmIEndpoint adds an endpoint returning the principal ID, configured with configure
+func addWhoAmIEndpoint(t *testing.T, svc *Service, configure func(config *EndpointConfig)) {
+	t.Helper()
+	err := svc.AddEndpoint(TypedEndpoint("whoami", func(ctx context.Context, req *struct{}) (*string, error) {
+		id := "anonymous"
+		if principal := PrincipalFromContext(ctx); principal != nil {
+			id = principal.ID
+		}
+		return &id, nil
+	}).WithConfig(func(svc *Service, config *EndpointConfig) {
+		if configure != nil {
+			configure(config)
+		}
+	}))
+	require.NoError(t, err)
+}
+
+func TestAuth_Nkey(t *testing.T) {
+	assert := assert.New(t)
+
+	admin, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	adminKey, err := admin.PublicKey()
+	require.NoError(t, err)
+	stranger, err := nkeys.CreateUser()
+	require.NoError(t, err)
+
+	authenticator := NewNkeyAuthenticator(func(ctx context.Context, publicKey string) (*Principal, error) {
+		if publicKey != adminKey {
+			return nil, errors.New("not found")
+		}
+		return &Principal{ID: publicKey, Roles: []string{"admin"}}, nil
+	})
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Authenticator = authenticator
+	})
+	addWhoAmIEndpoint(t, svc, func(config *EndpointConfig) {
+		config.RequiredRoles = []string{"admin"}
+	})
+	ctx := context.Background()
+
+	// Valid signature from a known key
+	id, err := Request[struct{}, string](ctx, nc, "test.whoami", struct{}{}, WithNkey(admin))
+	require.NoError(t, err)
+	assert.Equal(adminKey, *id)
+
+	// No credentials
+	_, err = Request[struct{}, string](ctx, nc, "test.whoami", struct{}{})
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+
+	// Unknown key
+	_, err = Request[struct{}, string](ctx, nc, "test.whoami", struct{}{}, WithNkey(stranger))
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+
+	// Signature made by another key
+	forged := func(msg *nats.Msg) error {
+		if err := WithNkey(stranger)(msg); err != nil {
+			return err
+		}
+		msg.Header.Set(AuthNkeyHeader, adminKey)
+		return nil
+	}
+	_, err = Request[struct{}, string](ctx, nc, "test.whoami", struct{}{}, forged)
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+
+	// Replayed, redirected or altered signed requests
+	signed := func(configure func(msg *nats.Msg)) *nats.Msg {
+		msg := &nats.Msg{Subject: "test.whoami", Data: []byte("{}"), Header: nats.Header{"X-Tenant": []string{"acme"}}}
+		require.NoError(t, WithNkey(admin)(msg))
+		if configure != nil {
+			configure(msg)
+		}
+		return msg
+	}
+	send := func(msg *nats.Msg) error {
+		reply, err := requestMsg(ctx, nc, msg)
+		require.NoError(t, err)
+		return ResponseError(reply)
+	}
+	msg := signed(nil)
+	assert.NotEmpty(msg.Reply)
+	assert.Equal("Nats-Auth-Nkey,X-Tenant", msg.Header.Get(AuthSignedHeadersHeader))
+	require.NoError(t, send(msg))
+	assert.ErrorIs(send(msg), NewServiceError(CodeUnauthorized, ""))
+	assert.ErrorIs(send(signed(func(msg *nats.Msg) { msg.Reply = nats.NewInbox() })), NewServiceError(CodeUnauthorized, ""))
+	assert.ErrorIs(send(signed(func(msg *nats.Msg) { msg.Header.Set("X-Tenant", "other") })), NewServiceError(CodeUnauthorized, ""))
+	assert.ErrorIs(send(signed(func(msg *nats.Msg) { msg.Header.Del(AuthNonceHeader) })), NewServiceError(CodeUnauthorized, ""))
+	require.NoError(t, send(signed(func(msg *nats.Msg) { msg.Header.Set("X-Unsigned", "ignored") })))
+
+	// Expired signature
+	authenticator.MaxClockSkew = time.Nanosecond
+	_, err = Request[struct{}, string](ctx, nc, "test.whoami", struct{}{}, WithNkey(admin))
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+}
+
+func TestNonceCache(t *testing.T) {
+	assert := assert.New(t)
+	var cache nonceCache
+	assert.True(cache.add("a", time.Now().Add(time.Minute)))
+	assert.False(cache.add("a", time.Now().Add(time.Minute)))
+	assert.True(cache.add("b", time.Now().Add(-time.Second)))
+	assert.True(cache.add("b", time.Now().Add(time.Minute)), "expired nonces are forgotten")
+}
+
+func TestAuth_JWT(t *testing.T) {
+	assert := assert.New(t)
+
+	account, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	accountKey, err := account.PublicKey()
+	require.NoError(t, err)
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userKey, err := user.PublicKey()
+	require.NoError(t, err)
+
+	issue := func(signer nkeys.KeyPair, tags ...string) string {
+		claims := jwt.NewUserClaims(userKey)
+		claims.Name = "alice"
+		claims.Tags.Add(tags...)
+		token, err := claims.Encode(signer)
+		require.NoError(t, err)
+		return token
+	}
+
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Authenticator = NewJWTAuthenticator(accountKey)
+	})
+	err = svc.AddEndpoint(TypedEndpoint("principal", func(ctx context.Context, req *struct{}) (*Principal, error) {
+		return PrincipalFromContext(ctx), nil
+	}).WithConfig(func(svc *Service, config *EndpointConfig) {
+		config.RequiredRoles = []string{"editor"}
+		config.RequiredPermissions = []string{"users.write"}
+	}))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Valid JWT with the required roles and permissions
+	token := issue(account, "role:editor", "perm:users.write", "team:core")
+	principal, err := Request[struct{}, Principal](ctx, nc, "test.principal", struct{}{}, WithUserJWT(token, user))
+	require.NoError(t, err)
+	assert.Equal(userKey, principal.ID)
+	assert.Equal("alice", principal.Name)
+	assert.Equal(accountKey, principal.Account)
+	assert.Equal([]string{"editor"}, principal.Roles)
+	assert.Equal([]string{"users.write"}, principal.Permissions)
+
+	// Missing permission
+	token = issue(account, "role:editor")
+	_, err = Request[struct{}, Principal](ctx, nc, "test.principal", struct{}{}, WithUserJWT(token, user))
+	assert.ErrorIs(err, NewServiceError(CodeForbidden, ""))
+
+	// JWT issued by an untrusted account
+	other, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	token = issue(other, "role:editor", "perm:users.write")
+	_, err = Request[struct{}, Principal](ctx, nc, "test.principal", struct{}{}, WithUserJWT(token, user))
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+
+	// Request not signed by the JWT subject
+	intruder, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	token = issue(account, "role:editor", "perm:users.write")
+	_, err = Request[struct{}, Principal](ctx, nc, "test.principal", struct{}{}, WithUserJWT(token, intruder))
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+}
+
+func TestAuth_JWTSigningKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	account, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	accountKey, err := account.PublicKey()
+	require.NoError(t, err)
+	signingKey, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	signingKeyPublic, err := signingKey.PublicKey()
+	require.NoError(t, err)
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userKey, err := user.PublicKey()
+	require.NoError(t, err)
+
+	// issue returns a user JWT signed by signer on behalf of issuerAccount
+	issue := func(signer nkeys.KeyPair, issuerAccount string) string {
+		claims := jwt.NewUserClaims(userKey)
+		claims.IssuerAccount = issuerAccount
+		token, err := claims.Encode(signer)
+		require.NoError(t, err)
+		return token
+	}
+
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Authenticator = NewJWTAuthenticator(accountKey).AddSigningKeys(accountKey, signingKeyPublic)
+	})
+	err = svc.AddEndpoint(TypedEndpoint("principal", func(ctx context.Context, req *struct{}) (*Principal, error) {
+		return PrincipalFromContext(ctx), nil
+	}))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// JWT issued by a signing key of the trusted account
+	principal, err := Request[struct{}, Principal](ctx, nc, "test.principal", struct{}{}, WithUserJWT(issue(signingKey, accountKey), user))
+	require.NoError(t, err)
+	assert.Equal(accountKey, principal.Account)
+
+	// JWT claiming the trusted account, signed by a key which is not one of its signing keys
+	forger, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	_, err = Request[struct{}, Principal](ctx, nc, "test.principal", struct{}{}, WithUserJWT(issue(forger, accountKey), user))
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+
+	// Signing key used without issuer account is not a trusted issuer
+	_, err = Request[struct{}, Principal](ctx, nc, "test.principal", struct{}{}, WithUserJWT(issue(signingKey, ""), user))
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+}
+
+func TestAuth_AllowAnonymous(t *testing.T) {
+	assert := assert.New(t)
+
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	userKey, err := user.PublicKey()
+	require.NoError(t, err)
+
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Authenticator = NewNkeyAuthenticator(func(ctx context.Context, publicKey string) (*Principal, error) {
+			return &Principal{ID: publicKey}, nil
+		})
+	})
+	addWhoAmIEndpoint(t, svc, func(config *EndpointConfig) {
+		config.AllowAnonymous = true
+	})
+	err = svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "handled"},
+		handle: func(request micro.Request) {
+			t.Error("handler called for an unauthenticated request")
+		},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	id, err := Request[struct{}, string](ctx, nc, "test.whoami", struct{}{})
+	require.NoError(t, err)
+	assert.Equal("anonymous", *id)
+
+	id, err = Request[struct{}, string](ctx, nc, "test.whoami", struct{}{}, WithNkey(user))
+	require.NoError(t, err)
+	assert.Equal(userKey, *id)
+
+	// Invalid credentials are rejected even on anonymous endpoints
+	_, err = Request[struct{}, string](ctx, nc, "test.whoami", struct{}{}, WithHeader(AuthNkeyHeader, userKey))
+	assert.ErrorIs(err, NewServiceError(CodeUnauthorized, ""))
+
+	// Handle is not called for rejected requests
+	msg, err := nc.Request("test.handled", nil, time.Second)
+	require.NoError(t, err)
+	assert.Equal(CodeUnauthorized, msg.Header.Get(micro.ErrorCodeHeader))
+}
@@ -0,0 +1,256 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker defaults
+const (
+	DefaultCircuitFailureRatio = 0.5
+	DefaultCircuitMinRequests  = 10
+	DefaultCircuitCoolDown     = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned by the calls rejected without being sent because the circuit of their subject is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of the circuit of a subject
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // Calls are sent, their failures are counted
+	CircuitOpen                         // Calls fail fast with ErrCircuitOpen until the cool-down ends
+	CircuitHalfOpen                     // A few probe calls are sent to decide whether to close the circuit
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// MarshalText encodes the state by its name
+func (s CircuitState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CircuitBreakerConfig configures a CircuitBreaker, the zero values select the defaults
+type CircuitBreakerConfig struct {
+	FailureRatio     float64              // Ratio of failed calls opening the circuit (DefaultCircuitFailureRatio)
+	MinRequests      int                  // Calls counted before the failure ratio is evaluated (DefaultCircuitMinRequests)
+	Interval         time.Duration        // Period after which the counts of a closed circuit are reset, 0 to never reset them
+	CoolDown         time.Duration        // Time a circuit stays open before the probe calls (DefaultCircuitCoolDown)
+	HalfOpenRequests int                  // Probe calls of a half-open circuit, closing it if they all succeed (1)
+	IsFailure        func(err error) bool // Errors counted as failures, isCircuitFailure if nil
+	Logger           *slog.Logger         // Logger of the state changes, slog.Default() if nil
+}
+
+// CircuitStats reports the state of the circuit of a subject
+type CircuitStats struct {
+	State    CircuitState `json:"state"`    // Current state
+	Since    time.Time    `json:"since"`    // Time of the last state change or reset of the counts
+	Requests int          `json:"requests"` // Calls counted since the last state change or reset of the counts
+	Failures int          `json:"failures"` // Failed calls among Requests
+	Rejected uint64       `json:"rejected"` // Calls rejected with ErrCircuitOpen since the circuit was created
+}
+
+// CircuitBreaker stops sending calls to the subjects failing too often, with a circuit per subject.
+// A closed circuit opens when the ratio of failed calls reaches FailureRatio after at least MinRequests calls.
+// An open circuit rejects the calls with ErrCircuitOpen during CoolDown, then becomes half-open
+// and lets HalfOpenRequests probe calls through: the circuit closes if they all succeed, and opens again otherwise.
+// It is safe for concurrent use, and is usually set on a Client with WithCircuitBreaker.
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// circuit is the state of a subject, protected by the mutex of its breaker
+type circuit struct {
+	CircuitStats
+	generation uint64 // incremented on each state change and reset, to ignore the results of older calls
+	probes     int    // probe calls sent in the half-open state
+}
+
+// NewCircuitBreaker creates a circuit breaker, see CircuitBreakerConfig for the defaults
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureRatio <= 0 {
+		config.FailureRatio = DefaultCircuitFailureRatio
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = DefaultCircuitMinRequests
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = DefaultCircuitCoolDown
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = isCircuitFailure
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	return &CircuitBreaker{
+		config:   config,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// State returns the state of the circuit of subject
+func (b *CircuitBreaker) State(subject string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.circuits[subject]; !ok {
+		return CircuitClosed
+	}
+	return b.circuit(subject, time.Now()).State
+}
+
+// Stats returns the state of the circuits by subject, e.g. to be sent by a service endpoint
+func (b *CircuitBreaker) Stats() map[string]CircuitStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	stats := make(map[string]CircuitStats, len(b.circuits))
+	for subject := range b.circuits {
+		stats[subject] = b.circuit(subject, now).CircuitStats
+	}
+	return stats
+}
+
+// Execute calls call if the circuit of subject lets it through, and records its result
+func (b *CircuitBreaker) Execute(subject string, call func() error) error {
+	done, err := b.allow(subject)
+	if err != nil {
+		return err
+	}
+	err = call()
+	done(err)
+	return err
+}
+
+// allow returns ErrCircuitOpen if the circuit of subject rejects the call,
+// otherwise done must be called with the result of the call
+func (b *CircuitBreaker) allow(subject string) (done func(err error), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(subject, time.Now())
+	switch {
+	case c.State == CircuitOpen:
+		c.Rejected++
+		return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, subject)
+	case c.State == CircuitHalfOpen && c.probes >= b.config.HalfOpenRequests:
+		c.Rejected++
+		return nil, fmt.Errorf("%w: %s (half-open)", ErrCircuitOpen, subject)
+	case c.State == CircuitHalfOpen:
+		c.probes++
+	}
+
+	generation := c.generation
+	return func(err error) {
+		b.record(subject, generation, err)
+	}, nil
+}
+
+// record counts the result of a call allowed in generation of the circuit of subject.
+// Calls cancelled by the caller are neither successes nor failures.
+func (b *CircuitBreaker) record(subject string, generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	c := b.circuit(subject, now)
+	if c.generation != generation {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		// A call cancelled by the caller tells nothing about the service, the probe slot is freed for another call
+		if c.State == CircuitHalfOpen {
+			c.probes--
+		}
+		return
+	}
+	failed := err != nil && b.config.IsFailure(err)
+	c.Requests++
+	if failed {
+		c.Failures++
+	}
+
+	switch c.State {
+	case CircuitClosed:
+		if c.Requests >= b.config.MinRequests && float64(c.Failures) >= b.config.FailureRatio*float64(c.Requests) {
+			b.setState(subject, c, CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		if failed {
+			b.setState(subject, c, CircuitOpen, now)
+		} else if c.Requests >= b.config.HalfOpenRequests {
+			b.setState(subject, c, CircuitClosed, now)
+		}
+	}
+}
+
+// circuit returns the circuit of subject, updated for the elapsed cool-down and interval
+func (b *CircuitBreaker) circuit(subject string, now time.Time) *circuit {
+	c, ok := b.circuits[subject]
+	if !ok {
+		c = &circuit{CircuitStats: CircuitStats{State: CircuitClosed, Since: now}}
+		b.circuits[subject] = c
+	}
+
+	switch c.State {
+	case CircuitOpen:
+		if now.Sub(c.Since) >= b.config.CoolDown {
+			b.setState(subject, c, CircuitHalfOpen, now)
+		}
+	case CircuitClosed:
+		if b.config.Interval > 0 && now.Sub(c.Since) >= b.config.Interval {
+			c.Since = now
+			c.Requests, c.Failures = 0, 0
+			c.generation++
+		}
+	}
+	return c
+}
+
+// setState changes the state of the circuit c of subject and resets its counts
+func (b *CircuitBreaker) setState(subject string, c *circuit, state CircuitState, now time.Time) {
+	b.config.Logger.Info("circuit breaker state changed",
+		"subject", subject,
+		"from", c.State.String(),
+		"to", state.String(),
+		"requests", c.Requests,
+		"failures", c.Failures,
+	)
+	c.State = state
+	c.Since = now
+	c.Requests, c.Failures, c.probes = 0, 0, 0
+	c.generation++
+}
+
+// isCircuitFailure reports whether a call failing with err shows that the called service is failing:
+// no responders, timeouts, and the service errors with a 5xx or 408 code.
+// Requests rejected by the service (4xx) and cancelled by the caller are not failures.
+func isCircuitFailure(err error) bool {
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		return strings.HasPrefix(serviceErr.Code, "5") || serviceErr.Code == CodeTimeout
+	}
+	return !errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,144 @@
+package natsservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errCircuitTest = errors.New("failure")
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	var logs bytes.Buffer
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{
+		FailureRatio:     0.5,
+		MinRequests:      4,
+		CoolDown:         50 * time.Millisecond,
+		HalfOpenRequests: 2,
+		Logger:           slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+	succeed := func() error { return nil }
+	fail := func() error { return errCircuitTest }
+
+	// The ratio is only evaluated after MinRequests calls
+	assert.ErrorIs(breaker.Execute("a", fail), errCircuitTest)
+	assert.ErrorIs(breaker.Execute("a", fail), errCircuitTest)
+	assert.NoError(breaker.Execute("a", succeed))
+	assert.Equal(CircuitClosed, breaker.State("a"))
+	assert.NoError(breaker.Execute("a", succeed))
+	assert.Equal(CircuitOpen, breaker.State("a"))
+	assert.Contains(logs.String(), "subject=a from=closed to=open")
+
+	// An open circuit fails fast, other subjects are not affected
+	calls := 0
+	err := breaker.Execute("a", func() error { calls++; return nil })
+	assert.ErrorIs(err, ErrCircuitOpen)
+	assert.Zero(calls)
+	assert.NoError(breaker.Execute("b", succeed))
+
+	// After the cool-down, the probes are limited to HalfOpenRequests
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(CircuitHalfOpen, breaker.State("a"))
+	done1, err := breaker.allow("a")
+	require.NoError(t, err)
+	done2, err := breaker.allow("a")
+	require.NoError(t, err)
+	_, err = breaker.allow("a")
+	assert.ErrorIs(err, ErrCircuitOpen)
+
+	// A failed probe opens the circuit again
+	done1(nil)
+	done2(errCircuitTest)
+	assert.Equal(CircuitOpen, breaker.State("a"))
+
+	// Successful probes close it
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(breaker.Execute("a", succeed))
+	assert.NoError(breaker.Execute("a", succeed))
+	assert.Equal(CircuitClosed, breaker.State("a"))
+	assert.Contains(logs.String(), "subject=a from=half-open to=closed")
+
+	stats := breaker.Stats()
+	assert.Len(stats, 2)
+	assert.Equal(CircuitClosed, stats["a"].State)
+	assert.EqualValues(2, stats["a"].Rejected)
+	assert.Equal(1, stats["b"].Requests)
+	assert.Equal(CircuitClosed, breaker.State("unknown"))
+	assert.Len(breaker.Stats(), 2)
+}
+
+func TestCircuitBreaker_CancelledProbe(t *testing.T) {
+	assert := assert.New(t)
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, CoolDown: 20 * time.Millisecond})
+	breaker.Execute("a", func() error { return errCircuitTest })
+	assert.Equal(CircuitOpen, breaker.State("a"))
+	time.Sleep(30 * time.Millisecond)
+
+	// A probe cancelled by the caller neither closes nor opens the circuit, another probe is let through
+	err := breaker.Execute("a", func() error { return context.Canceled })
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(CircuitHalfOpen, breaker.State("a"))
+	assert.NoError(breaker.Execute("a", func() error { return nil }))
+	assert.Equal(CircuitClosed, breaker.State("a"))
+}
+
+func TestCircuitBreaker_Interval(t *testing.T) {
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 2, Interval: 30 * time.Millisecond})
+	breaker.Execute("a", func() error { return errCircuitTest })
+	time.Sleep(40 * time.Millisecond)
+
+	// The failure of the previous interval is not counted
+	breaker.Execute("a", func() error { return errCircuitTest })
+	assert.Equal(t, CircuitClosed, breaker.State("a"))
+	assert.Equal(t, 1, breaker.Stats()["a"].Failures)
+}
+
+func TestIsCircuitFailure(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(isCircuitFailure(nats.ErrNoResponders))
+	assert.True(isCircuitFailure(context.DeadlineExceeded))
+	assert.True(isCircuitFailure(NewServiceError(CodeInternalError, "crash")))
+	assert.True(isCircuitFailure(NewServiceError(CodeTimeout, "slow")))
+	assert.False(isCircuitFailure(NewServiceError(CodeBadRequest, "invalid")))
+	assert.False(isCircuitFailure(context.Canceled))
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	svc, nc := startTestService(t, nil)
+
+	var calls atomic.Int32
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "failing"},
+		handle: func(request micro.Request) {
+			calls.Add(1)
+			RespondError(request, NewServiceError(CodeUnavailable, "down").WithRetryable(true))
+		},
+	}))
+
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 3, CoolDown: time.Minute})
+	client, err := NewClient(nc, WithSubjectPrefix("test"), WithRetry(fastRetry), WithCircuitBreaker(breaker))
+	require.NoError(t, err)
+
+	// The retries are counted by the breaker, which opens on the third failure
+	_, err = Call[struct{}, string](context.Background(), client, "failing", struct{}{})
+	assert.ErrorIs(err, NewServiceError(CodeUnavailable, ""))
+	assert.EqualValues(3, calls.Load())
+	assert.Equal(CircuitOpen, breaker.State("test.failing"))
+
+	// The calls then fail fast, without retries
+	_, err = Call[struct{}, string](context.Background(), client, "failing", struct{}{})
+	assert.ErrorIs(err, ErrCircuitOpen)
+	assert.EqualValues(3, calls.Load())
+	assert.EqualValues(1, breaker.Stats()["test.failing"].Rejected)
+}
@@ -0,0 +1,314 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/telemac/natsservice/pkg/codec"
+	"github.com/telemac/natsservice/pkg/typeregistry"
+)
+
+// DefaultRequestTimeout bounds each attempt of the Client calls, unless changed by WithDefaultTimeout
+const DefaultRequestTimeout = 5 * time.Second
+
+// DefaultRetryPolicy is a policy making up to 3 attempts, waiting 100ms then 200ms (± 20%) between them
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// RetryPolicy retries the calls failing with no responders, a timeout of the attempt,
+// or a retryable *ServiceError. The calls are never retried once their context is done.
+type RetryPolicy struct {
+	MaxAttempts    int           // Attempts including the first one, 0 or 1 disables the retries
+	InitialBackoff time.Duration // Delay before the first retry
+	MaxBackoff     time.Duration // Maximum delay between two attempts
+	Multiplier     float64       // Growth of the delay after each retry, 2 if not set
+	Jitter         float64       // Random variation of the delays, as a fraction of the delay (0.2 for ± 20%)
+}
+
+// backoff returns the delay before the attempt following attempt (1 for the first attempt)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+	}
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Client calls the endpoints of a service, with defaults shared by all its calls.
+// It is safe for concurrent use.
+type Client struct {
+	nc       *nats.Conn
+	timeout  time.Duration
+	prefix   string
+	codec    codec.Codec
+	header   nats.Header
+	retry    RetryPolicy
+	registry *typeregistry.Registry
+	breaker  *CircuitBreaker
+}
+
+// ClientOption configures a Client
+type ClientOption func(*Client)
+
+// WithDefaultTimeout bounds each attempt of the calls, 0 relies on the context deadline only
+func WithDefaultTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithSubjectPrefix prefixes the subjects of the calls, e.g. with the group of the service
+// ("demo" calls "demo.add" for "add")
+func WithSubjectPrefix(prefix string) ClientOption {
+	return func(c *Client) {
+		c.prefix = prefix
+	}
+}
+
+// WithCodec sets the codec of the requests and responses of Call and Publish, codec.JSON by default
+func WithCodec(c codec.Codec) ClientOption {
+	return func(client *Client) {
+		client.codec = c
+	}
+}
+
+// WithDefaultHeader sets a header on all the messages sent by the client
+func WithDefaultHeader(key, value string) ClientOption {
+	return func(c *Client) {
+		c.header.Set(key, value)
+	}
+}
+
+// WithRetry retries the calls with policy, e.g. DefaultRetryPolicy
+func WithRetry(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithTypeRegistry sets the type registry used by TypedCall
+func WithTypeRegistry(registry *typeregistry.Registry) ClientOption {
+	return func(c *Client) {
+		c.registry = registry
+	}
+}
+
+// WithCircuitBreaker makes the calls and their retries through breaker, failing fast with ErrCircuitOpen
+// while the circuit of their subject is open. A breaker can be shared by several clients.
+func WithCircuitBreaker(breaker *CircuitBreaker) ClientOption {
+	return func(c *Client) {
+		c.breaker = breaker
+	}
+}
+
+// NewClient creates a client sending its requests on nc
+func NewClient(nc *nats.Conn, opts ...ClientOption) (*Client, error) {
+	if nc == nil {
+		return nil, fmt.Errorf("NATS connection is nil")
+	}
+	c := &Client{
+		nc:      nc,
+		timeout: DefaultRequestTimeout,
+		codec:   codec.JSON{},
+		header:  nats.Header{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Conn returns the connection of the client
+func (c *Client) Conn() *nats.Conn {
+	return c.nc
+}
+
+// Subject returns the subject called for subject, with the prefix of the client
+func (c *Client) Subject(subject string) string {
+	if c.prefix == "" {
+		return subject
+	}
+	return c.prefix + "." + subject
+}
+
+// Call sends request to the endpoint subject with the client c, and decodes its response.
+// It returns a *ServiceError if the endpoint replied with an error.
+//
+// Usage:
+//
+//	client, err := natsservice.NewClient(nc, natsservice.WithSubjectPrefix("demo"))
+//	response, err := natsservice.Call[add.AddRequest, add.AddResponse](ctx, client, "add", request)
+func Call[Req, Resp any](ctx context.Context, c *Client, subject string, request Req, opts ...RequestOption) (*Resp, error) {
+	data, err := c.codec.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	msg, err := c.request(ctx, subject, data, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var response Resp
+	if err := c.codec.Unmarshal(msg.Data, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return &response, nil
+}
+
+// TypedCall sends request, a value of a type of the registry set by WithTypeRegistry, to the endpoint subject.
+// The response is decoded to the type named by its X-Type header. Typed calls are always JSON encoded.
+func (c *Client) TypedCall(ctx context.Context, subject string, request any, opts ...RequestOption) (any, error) {
+	if c.registry == nil {
+		return nil, fmt.Errorf("type registry is nil")
+	}
+	requestTypeName, err := c.registry.NameOf(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request type name: %w", err)
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	msg, err := c.request(ctx, subject, data, nats.Header{"X-Type": []string{requestTypeName}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	responseTypeName := msg.Header.Get("X-Type")
+	if responseTypeName == "" {
+		return nil, fmt.Errorf("response missing X-Type header")
+	}
+	response, err := c.registry.UnmarshalType(responseTypeName, msg.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal typed response: %w", err)
+	}
+	return response, nil
+}
+
+// Publish publishes payload to subject without expecting a response, it is not retried
+func (c *Client) Publish(ctx context.Context, subject string, payload any, opts ...RequestOption) error {
+	data, err := c.codec.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	msg := c.newMsg(ctx, subject, data, nil)
+	if err := applyRequestOptions(msg, opts); err != nil {
+		return err
+	}
+	if err := c.nc.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+	return nil
+}
+
+// request sends data to subject and waits for the response, retrying as configured by the retry policy.
+// It returns a *ServiceError if the endpoint replied with an error.
+func (c *Client) request(ctx context.Context, subject string, data []byte, header nats.Header, opts []RequestOption) (*nats.Msg, error) {
+	for attempt := 1; ; attempt++ {
+		msg, err := c.attempt(ctx, subject, data, header, opts)
+		if err == nil {
+			return msg, nil
+		}
+		if attempt >= c.retry.MaxAttempts || !isRetryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(c.retry.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
+// attempt sends a request, bounded by the default timeout of the client
+func (c *Client) attempt(ctx context.Context, subject string, data []byte, header nats.Header, opts []RequestOption) (*nats.Msg, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	// The message is built for each attempt : its deadline and signature change
+	msg := c.newMsg(ctx, subject, data, header)
+	setDeadlineHeader(ctx, msg)
+	if err := applyRequestOptions(msg, opts); err != nil {
+		return nil, err
+	}
+
+	if c.breaker == nil {
+		return c.send(ctx, msg)
+	}
+	done, err := c.breaker.allow(msg.Subject)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.send(ctx, msg)
+	done(err)
+	return reply, err
+}
+
+// send sends the request msg and waits for its response
+func (c *Client) send(ctx context.Context, msg *nats.Msg) (*nats.Msg, error) {
+	reply, err := requestMsg(ctx, c.nc, msg)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	if err := ResponseError(reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// newMsg builds a message to subject with the default headers of the client, header and the caller context
+func (c *Client) newMsg(ctx context.Context, subject string, data []byte, header nats.Header) *nats.Msg {
+	msg := &nats.Msg{
+		Subject: c.Subject(subject),
+		Data:    data,
+		Header:  nats.Header{},
+	}
+	for key, values := range c.header {
+		msg.Header[key] = append([]string(nil), values...)
+	}
+	for key, values := range header {
+		msg.Header[key] = append([]string(nil), values...)
+	}
+	propagateContext(ctx, msg.Header)
+	return msg
+}
+
+// isRetryable reports whether a call failing with err can be retried
+func isRetryable(err error) bool {
+	var serviceErr *ServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.Retryable
+	}
+	return errors.Is(err, nats.ErrNoResponders) ||
+		errors.Is(err, nats.ErrTimeout) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,226 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/codec"
+	"github.com/telemac/natsservice/pkg/typeregistry"
+)
+
+// fastRetry retries quickly, to keep the tests short
+var fastRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond}
+
+func TestClient_Call(t *testing.T) {
+	assert := assert.New(t)
+	svc, nc := startTestService(t, nil)
+	require.NoError(t, svc.AddEndpoint(TypedEndpoint("divide", func(ctx context.Context, req *divideRequest) (*divideResponse, error) {
+		return &divideResponse{Result: req.A / req.B}, nil
+	})))
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "header"},
+		handle: func(request micro.Request) {
+			request.Respond([]byte(`"` + request.Headers().Get("X-Tenant") + `"`))
+		},
+	}))
+
+	client, err := NewClient(nc, WithSubjectPrefix("test"), WithDefaultHeader("X-Tenant", "acme"))
+	require.NoError(t, err)
+	assert.Equal("test.divide", client.Subject("divide"))
+
+	response, err := Call[divideRequest, divideResponse](context.Background(), client, "divide", divideRequest{A: 6, B: 3})
+	require.NoError(t, err)
+	assert.Equal(2.0, response.Result)
+
+	_, err = Call[divideRequest, divideResponse](context.Background(), client, "divide", divideRequest{A: 6, B: 0})
+	assert.ErrorIs(err, NewServiceError(CodeBadRequest, ""))
+
+	// Default headers, overridden by the request options
+	tenant, err := Call[struct{}, string](context.Background(), client, "header", struct{}{})
+	require.NoError(t, err)
+	assert.Equal("acme", *tenant)
+	tenant, err = Call[struct{}, string](context.Background(), client, "header", struct{}{}, WithHeader("X-Tenant", "other"))
+	require.NoError(t, err)
+	assert.Equal("other", *tenant)
+
+	_, err = NewClient(nil)
+	assert.Error(err)
+}
+
+func TestClient_Retry(t *testing.T) {
+	assert := assert.New(t)
+	svc, nc := startTestService(t, nil)
+
+	// flaky fails twice with a retryable error, then succeeds
+	var flakyCalls, brokenCalls atomic.Int32
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "flaky"},
+		handle: func(request micro.Request) {
+			if flakyCalls.Add(1) <= 2 {
+				RespondError(request, NewServiceError(CodeUnavailable, "busy").WithRetryable(true))
+				return
+			}
+			request.Respond([]byte(`"ok"`))
+		},
+	}))
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "broken"},
+		handle: func(request micro.Request) {
+			brokenCalls.Add(1)
+			RespondError(request, NewServiceError(CodeBadRequest, "invalid"))
+		},
+	}))
+
+	client, err := NewClient(nc, WithSubjectPrefix("test"), WithRetry(fastRetry))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	response, err := Call[struct{}, string](ctx, client, "flaky", struct{}{})
+	require.NoError(t, err)
+	assert.Equal("ok", *response)
+	assert.EqualValues(3, flakyCalls.Load())
+
+	// Non retryable errors are returned at once
+	_, err = Call[struct{}, string](ctx, client, "broken", struct{}{})
+	assert.ErrorIs(err, NewServiceError(CodeBadRequest, ""))
+	assert.EqualValues(1, brokenCalls.Load())
+
+	// No responders are retried until the attempts are exhausted
+	start := time.Now()
+	_, err = Call[struct{}, string](ctx, client, "missing", struct{}{})
+	assert.ErrorIs(err, nats.ErrNoResponders)
+	assert.GreaterOrEqual(time.Since(start), 30*time.Millisecond)
+
+	// Without retry policy, a single attempt is made
+	flakyCalls.Store(0)
+	_, err = Call[struct{}, string](ctx, newTestClient(t, nc), "test.flaky", struct{}{})
+	assert.ErrorIs(err, NewServiceError(CodeUnavailable, ""))
+	assert.EqualValues(1, flakyCalls.Load())
+}
+
+// newTestClient returns a client without options
+func newTestClient(t *testing.T, nc *nats.Conn) *Client {
+	client, err := NewClient(nc)
+	require.NoError(t, err)
+	return client
+}
+
+func TestClient_RetryTimeout(t *testing.T) {
+	assert := assert.New(t)
+	svc, nc := startTestService(t, nil)
+
+	// slow does not respond to the first request
+	var calls atomic.Int32
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "slow"},
+		handle: func(request micro.Request) {
+			if calls.Add(1) == 1 {
+				return
+			}
+			request.Respond([]byte(`"ok"`))
+		},
+	}))
+
+	client, err := NewClient(nc, WithSubjectPrefix("test"), WithRetry(fastRetry), WithDefaultTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	response, err := Call[struct{}, string](context.Background(), client, "slow", struct{}{})
+	require.NoError(t, err)
+	assert.Equal("ok", *response)
+	assert.EqualValues(2, calls.Load())
+
+	// The caller context bounds all the attempts
+	calls.Store(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Call[struct{}, string](ctx, client, "slow", struct{}{})
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.EqualValues(1, calls.Load())
+}
+
+type rejectEmptyCodec struct {
+	codec.JSON
+}
+
+func (c rejectEmptyCodec) Marshal(v any) ([]byte, error) {
+	if s, ok := v.(string); ok && s == "" {
+		return nil, errors.New("empty string")
+	}
+	return c.JSON.Marshal(v)
+}
+
+func TestClient_Codec(t *testing.T) {
+	_, nc := startTestService(t, nil)
+	client, err := NewClient(nc, WithCodec(rejectEmptyCodec{}))
+	require.NoError(t, err)
+
+	_, err = Call[string, string](context.Background(), client, "test.any", "")
+	assert.ErrorContains(t, err, "empty string")
+	assert.ErrorContains(t, client.Publish(context.Background(), "events", ""), "empty string")
+}
+
+func TestClient_Publish(t *testing.T) {
+	assert := assert.New(t)
+	_, nc := startTestService(t, nil)
+
+	sub, err := nc.SubscribeSync("events.created")
+	require.NoError(t, err)
+	client, err := NewClient(nc, WithSubjectPrefix("events"), WithDefaultHeader("X-Source", "test"))
+	require.NoError(t, err)
+
+	require.NoError(t, client.Publish(context.Background(), "created", map[string]string{"id": "42"}))
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.JSONEq(`{"id":"42"}`, string(msg.Data))
+	assert.Equal("test", msg.Header.Get("X-Source"))
+}
+
+func TestClient_TypedCall(t *testing.T) {
+	assert := assert.New(t)
+	svc, nc := startTestService(t, nil)
+
+	registry := typeregistry.New()
+	require.NoError(t, typeregistry.Register[divideRequest](registry, "test.DivideRequest"))
+	require.NoError(t, typeregistry.Register[divideResponse](registry, "test.DivideResponse"))
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "typed"},
+		handle: func(request micro.Request) {
+			var req divideRequest
+			json.Unmarshal(request.Data(), &req)
+			data, _ := json.Marshal(divideResponse{Result: req.A / req.B})
+			request.Respond(data, micro.WithHeaders(micro.Headers{"X-Type": []string{"test.DivideResponse"}}))
+		},
+	}))
+
+	client, err := NewClient(nc, WithSubjectPrefix("test"), WithTypeRegistry(registry))
+	require.NoError(t, err)
+	response, err := client.TypedCall(context.Background(), "typed", &divideRequest{A: 9, B: 3})
+	require.NoError(t, err)
+	assert.Equal(&divideResponse{Result: 3}, response)
+
+	_, err = newTestClient(t, nc).TypedCall(context.Background(), "test.typed", &divideRequest{})
+	assert.ErrorContains(err, "type registry is nil")
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	assert := assert.New(t)
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	assert.Equal(100*time.Millisecond, policy.backoff(1))
+	assert.Equal(200*time.Millisecond, policy.backoff(2))
+	assert.Equal(400*time.Millisecond, policy.backoff(3))
+	assert.Equal(time.Second, policy.backoff(5))
+
+	policy.Jitter = 0.5
+	for range 100 {
+		delay := policy.backoff(1)
+		assert.GreaterOrEqual(delay, 50*time.Millisecond)
+		assert.LessOrEqual(delay, 150*time.Millisecond)
+	}
+}
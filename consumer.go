@@ -0,0 +1,253 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrPoisonMessage flags a message that can never be processed, e.g. an undecodable payload.
+// Consumer handlers returning an error wrapping ErrPoisonMessage have the message dead-lettered
+// and terminated immediately, without waiting for MaxDeliver attempts.
+var ErrPoisonMessage = errors.New("poison message")
+
+// DefaultConsumerMaxDeliver is the default number of delivery attempts of a consumer message
+const DefaultConsumerMaxDeliver = 5
+
+// DefaultConsumerNakDelay is the redelivery delay of failed messages when ConsumerConfig.BackOff is empty
+const DefaultConsumerNakDelay = time.Second
+
+// Headers added to the messages routed to a dead-letter subject
+const (
+	DeadLetterSubjectHeader  = "Nats-Service-Dlq-Subject"  // Original subject
+	DeadLetterStreamHeader   = "Nats-Service-Dlq-Stream"   // Stream the message was consumed from
+	DeadLetterSequenceHeader = "Nats-Service-Dlq-Sequence" // Stream sequence of the message
+	DeadLetterErrorHeader    = "Nats-Service-Dlq-Error"    // Last handler error
+	DeadLetterAttemptsHeader = "Nats-Service-Dlq-Attempts" // Number of delivery attempts
+	DeadLetterServiceHeader  = "Nats-Service-Dlq-Service"  // Name of the service
+	DeadLetterConsumerHeader = "Nats-Service-Dlq-Consumer" // Name of the consumer
+	DeadLetterTimeHeader     = "Nats-Service-Dlq-Time"     // Time the message was dead-lettered (RFC 3339)
+)
+
+// ConsumerConfig holds configuration for durable JetStream consumers
+type ConsumerConfig struct {
+	Name           string              `json:"name"`                      // Durable consumer name
+	Stream         string              `json:"stream"`                    // Stream to consume
+	FilterSubjects []string            `json:"filter_subjects,omitempty"` // Consumed subjects, all the stream if empty
+	AckPolicy      jetstream.AckPolicy `json:"ack_policy"`                // Explicit by default
+	AckWait        time.Duration       `json:"ack_wait,omitempty"`        // Redelivery delay of unacknowledged messages
+	// MaxDeliver is the number of attempts before a failing message is dead-lettered and terminated (default DefaultConsumerMaxDeliver)
+	MaxDeliver int `json:"max_deliver,omitempty"`
+	// BackOff holds the redelivery delays of failed messages by attempt, the last one is used for later attempts.
+	// It can't hold more delays than MaxDeliver.
+	BackOff []time.Duration `json:"backoff,omitempty"`
+	// DeadLetterSubject receives the messages terminated after MaxDeliver attempts.
+	// If empty, they are stored in ServiceConfig.DeadLetters, or dropped if the service has no dead-letter queue.
+	DeadLetterSubject string `json:"dead_letter_subject,omitempty"`
+	MaxAckPending     int    `json:"max_ack_pending,omitempty"` // Maximum number of messages handled at once
+}
+
+// ConsumerHandler handles a JetStream message.
+// Returning nil acknowledges the message, an error schedules its redelivery.
+type ConsumerHandler func(ctx context.Context, msg jetstream.Msg) error
+
+// AddConsumer creates or updates the durable pull consumer described by config and consumes it
+// with handler until the service is stopped.
+//
+// Messages are acknowledged when handler succeeds, and negatively acknowledged with the BackOff delay
+// when it fails. After MaxDeliver failed attempts, or if handler returns ErrPoisonMessage, the message
+// is published to DeadLetterSubject with the DeadLetter* headers and terminated.
+func (svc *Service) AddConsumer(config ConsumerConfig, handler ConsumerHandler) error {
+	if handler == nil {
+		return errors.New("nil consumer handler")
+	}
+	if svc.config.Js == nil {
+		return errors.New("jetstream not configured")
+	}
+	if config.Name == "" {
+		return errors.New("missing consumer name")
+	}
+	if config.Stream == "" {
+		return errors.New("missing consumer stream")
+	}
+	if config.MaxDeliver <= 0 {
+		config.MaxDeliver = DefaultConsumerMaxDeliver
+	}
+	if len(config.BackOff) > config.MaxDeliver {
+		return fmt.Errorf("consumer %s: %d backoff delays for %d deliveries", config.Name, len(config.BackOff), config.MaxDeliver)
+	}
+	if config.DeadLetterSubject == "" && svc.config.DeadLetters != nil {
+		if err := validDeadLetterName("service", svc.config.Name); err != nil {
+			return err
+		}
+		if err := validDeadLetterName("consumer", config.Name); err != nil {
+			return err
+		}
+		config.DeadLetterSubject = svc.config.DeadLetters.Subject(svc.config.Name, config.Name)
+	}
+
+	consumer, err := svc.config.Js.CreateOrUpdateConsumer(svc.Ctx(), config.Stream, jetstream.ConsumerConfig{
+		Durable:        config.Name,
+		FilterSubjects: config.FilterSubjects,
+		AckPolicy:      config.AckPolicy,
+		AckWait:        config.AckWait,
+		MaxDeliver:     config.MaxDeliver,
+		BackOff:        config.BackOff,
+		MaxAckPending:  config.MaxAckPending,
+	})
+	if err != nil {
+		return fmt.Errorf("create consumer %s: %w", config.Name, err)
+	}
+
+	log := svc.Logger().With(
+		"service", svc.config.Name,
+		"consumer", config.Name,
+	)
+	consumeCtx, err := consumer.Consume(func(msg jetstream.Msg) {
+		svc.handleConsumerMsg(log, &config, handler, msg)
+	}, jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		log.Warn("consumer error", "error", err)
+	}))
+	if err != nil {
+		return fmt.Errorf("consume %s: %w", config.Name, err)
+	}
+
+	svc.mu.Lock()
+	svc.consumers = append(svc.consumers, consumeCtx)
+	svc.mu.Unlock()
+	return nil
+}
+
+// handleConsumerMsg calls handler and acknowledges msg according to the result
+func (svc *Service) handleConsumerMsg(log *slog.Logger, config *ConsumerConfig, handler ConsumerHandler, msg jetstream.Msg) {
+	if !svc.beginRequest() {
+		_ = msg.Nak() // redelivered to another instance
+		return
+	}
+	defer svc.endRequest()
+
+	ctx, span := svc.messageContext(log, config.Name, &nats.Msg{Subject: msg.Subject(), Header: msg.Headers()})
+	if span != nil {
+		defer span.End()
+	}
+	log = LoggerFromContext(ctx)
+
+	err := handleConsumerMsg(ctx, handler, msg)
+	if err == nil {
+		if config.AckPolicy != jetstream.AckNonePolicy {
+			if err := msg.Ack(); err != nil {
+				log.Error("failed to ack message", "error", err)
+			}
+		}
+		return
+	}
+	if span != nil {
+		span.SetError(err.Error())
+	}
+	if config.AckPolicy == jetstream.AckNonePolicy {
+		log.Error("consumer failed", "error", err)
+		return
+	}
+
+	attempts := 1
+	if metadata, metadataErr := msg.Metadata(); metadataErr == nil {
+		attempts = int(metadata.NumDelivered)
+	}
+	if attempts < config.MaxDeliver && !errors.Is(err, ErrPoisonMessage) {
+		log.Warn("consumer failed, message will be redelivered", "error", err, "attempts", attempts)
+		if err := msg.NakWithDelay(consumerBackOff(config, attempts)); err != nil {
+			log.Error("failed to nak message", "error", err)
+		}
+		return
+	}
+
+	log.Error("consumer failed, message terminated", "error", err, "attempts", attempts)
+	if config.DeadLetterSubject != "" {
+		if attempts < config.MaxDeliver {
+			// poison message, redelivered if it can't be dead-lettered yet
+			if dlqErr := svc.deadLetter(ctx, config, msg, err, attempts); dlqErr != nil {
+				log.Error("failed to dead-letter message", "error", dlqErr)
+				_ = msg.NakWithDelay(consumerBackOff(config, attempts))
+				return
+			}
+		} else if dlqErr := svc.retryDeadLetter(ctx, log, config, msg, err, attempts); dlqErr != nil {
+			// the server won't redeliver the message anymore, leave it unacknowledged in the stream
+			log.Error("failed to dead-letter message, message left in the stream", "error", dlqErr)
+			return
+		}
+	}
+	if err := msg.TermWithReason(err.Error()); err != nil {
+		log.Error("failed to term message", "error", err)
+	}
+}
+
+// handleConsumerMsg calls handler, converting panics to errors
+func handleConsumerMsg(ctx context.Context, handler ConsumerHandler, msg jetstream.Msg) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("consumer panicked: %v", r)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+// consumerBackOff returns the redelivery delay of a message after attempts failed deliveries
+func consumerBackOff(config *ConsumerConfig, attempts int) time.Duration {
+	if len(config.BackOff) == 0 {
+		return DefaultConsumerNakDelay
+	}
+	return config.BackOff[min(attempts, len(config.BackOff))-1]
+}
+
+// deadLetter publishes msg to the consumer dead-letter subject, with its original headers
+// and the DeadLetter* headers describing the failure
+func (svc *Service) deadLetter(ctx context.Context, config *ConsumerConfig, msg jetstream.Msg, cause error, attempts int) error {
+	letter := &DeadLetter{
+		Subject:  msg.Subject(),
+		Header:   msg.Headers(),
+		Data:     msg.Data(),
+		Error:    cause.Error(),
+		Attempts: attempts,
+		Service:  svc.config.Name,
+		Consumer: config.Name,
+		Time:     time.Now(),
+	}
+	if metadata, err := msg.Metadata(); err == nil {
+		letter.Stream = metadata.Stream
+		letter.StreamSequence = metadata.Sequence.Stream
+	}
+	_, err := svc.config.Js.PublishMsg(ctx, letter.Msg(config.DeadLetterSubject))
+	return err
+}
+
+// retryDeadLetter dead-letters msg after its last delivery, retrying with the BackOff delays until it succeeds
+// or the service stops: the server never redelivers the message, so a nak would lose it
+func (svc *Service) retryDeadLetter(ctx context.Context, log *slog.Logger, config *ConsumerConfig, msg jetstream.Msg, cause error, attempts int) error {
+	for retry := 1; ; retry++ {
+		err := svc.deadLetter(ctx, config, msg, cause, attempts)
+		if err == nil {
+			return nil
+		}
+		log.Warn("failed to dead-letter message, retrying", "error", err, "retry", retry)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(consumerBackOff(config, retry)):
+		}
+		_ = msg.InProgress()
+	}
+}
+
+// stopConsumers stops consuming messages, in-flight messages are still handled
+func (svc *Service) stopConsumers() {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	for _, consumeCtx := range svc.consumers {
+		consumeCtx.Stop()
+	}
+}
@@ -0,0 +1,214 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestConsumerService starts a test service with JetStream and creates the ORDERS and DLQ streams
+func startTestConsumerService(t *testing.T) (*Service, *nats.Conn, jetstream.JetStream) {
+	t.Helper()
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		var err error
+		config.Js, err = jetstream.New(config.Nc)
+		require.NoError(t, err)
+	})
+	js := svc.Jetstream()
+	ctx := context.Background()
+	_, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "ORDERS", Subjects: []string{"orders.>"}, Storage: jetstream.MemoryStorage})
+	require.NoError(t, err)
+	_, err = js.CreateStream(ctx, jetstream.StreamConfig{Name: "DLQ", Subjects: []string{"dlq.>"}, Storage: jetstream.MemoryStorage})
+	require.NoError(t, err)
+	return svc, nc, js
+}
+
+func TestConsumer_AckAndRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, _, js := startTestConsumerService(t)
+	ctx := context.Background()
+
+	var attempts atomic.Int32
+	done := make(chan string, 1)
+	err := svc.AddConsumer(ConsumerConfig{
+		Name:           "orders-processor",
+		Stream:         "ORDERS",
+		FilterSubjects: []string{"orders.created"},
+		MaxDeliver:     3,
+		BackOff:        []time.Duration{10 * time.Millisecond},
+	}, func(ctx context.Context, msg jetstream.Msg) error {
+		if attempts.Add(1) < 2 {
+			return errors.New("temporary failure")
+		}
+		done <- string(msg.Data())
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = js.Publish(ctx, "orders.created", []byte("order-1"))
+	require.NoError(t, err)
+
+	select {
+	case data := <-done:
+		assert.Equal("order-1", data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not redelivered")
+	}
+	assert.Equal(int32(2), attempts.Load())
+
+	consumer, err := js.Consumer(ctx, "ORDERS", "orders-processor")
+	require.NoError(t, err)
+	assert.Eventually(func() bool {
+		info, err := consumer.Info(ctx)
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConsumer_DeadLetter(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, _, js := startTestConsumerService(t)
+	ctx := context.Background()
+
+	var attempts atomic.Int32
+	err := svc.AddConsumer(ConsumerConfig{
+		Name:              "orders-failing",
+		Stream:            "ORDERS",
+		MaxDeliver:        3,
+		BackOff:           []time.Duration{5 * time.Millisecond, 10 * time.Millisecond},
+		DeadLetterSubject: "dlq.orders",
+	}, func(ctx context.Context, msg jetstream.Msg) error {
+		if string(msg.Data()) == "poison" {
+			return fmt.Errorf("decode: %w", ErrPoisonMessage)
+		}
+		attempts.Add(1)
+		return errors.New("always failing")
+	})
+	require.NoError(t, err)
+
+	_, err = js.PublishMsg(ctx, &nats.Msg{
+		Subject: "orders.created",
+		Header:  nats.Header{"Tenant": []string{"acme"}},
+		Data:    []byte("order-2"),
+	})
+	require.NoError(t, err)
+	_, err = js.Publish(ctx, "orders.created", []byte("poison"))
+	require.NoError(t, err)
+
+	dlq, err := js.OrderedConsumer(ctx, "DLQ", jetstream.OrderedConsumerConfig{})
+	require.NoError(t, err)
+	batch, err := dlq.Fetch(2, jetstream.FetchMaxWait(3*time.Second))
+	require.NoError(t, err)
+	var dead []jetstream.Msg
+	for msg := range batch.Messages() {
+		dead = append(dead, msg)
+	}
+	require.Len(t, dead, 2)
+
+	// Poison messages are dead-lettered after the first attempt
+	poison := dead[0]
+	assert.Equal("poison", string(poison.Data()))
+	assert.Equal("1", poison.Headers().Get(DeadLetterAttemptsHeader))
+
+	// Failing messages are dead-lettered after MaxDeliver attempts
+	failed := dead[1]
+	assert.Equal("order-2", string(failed.Data()))
+	assert.Equal(int32(3), attempts.Load())
+	headers := failed.Headers()
+	assert.Equal("acme", headers.Get("Tenant"))
+	assert.Equal("orders.created", headers.Get(DeadLetterSubjectHeader))
+	assert.Equal("ORDERS", headers.Get(DeadLetterStreamHeader))
+	assert.Equal("1", headers.Get(DeadLetterSequenceHeader))
+	assert.Equal("always failing", headers.Get(DeadLetterErrorHeader))
+	assert.Equal("3", headers.Get(DeadLetterAttemptsHeader))
+	assert.Equal("test", headers.Get(DeadLetterServiceHeader))
+	assert.Equal("orders-failing", headers.Get(DeadLetterConsumerHeader))
+}
+
+func TestConsumer_Stop(t *testing.T) {
+	svc, _, js := startTestConsumerService(t)
+	ctx := context.Background()
+
+	received := make(chan struct{}, 2)
+	err := svc.AddConsumer(ConsumerConfig{Name: "orders-stop", Stream: "ORDERS"}, func(ctx context.Context, msg jetstream.Msg) error {
+		received <- struct{}{}
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = js.Publish(ctx, "orders.created", nil)
+	require.NoError(t, err)
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	require.NoError(t, svc.Stop())
+	_, err = js.Publish(ctx, "orders.created", nil)
+	require.NoError(t, err)
+	select {
+	case <-received:
+		t.Fatal("message received after Stop")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestConsumer_RequiresJetStream(t *testing.T) {
+	svc, _ := startTestService(t, nil)
+	err := svc.AddConsumer(ConsumerConfig{Name: "c", Stream: "S"}, func(ctx context.Context, msg jetstream.Msg) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestConsumer_DeadLetterRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, _, js := startTestConsumerService(t)
+	ctx := context.Background()
+
+	err := svc.AddConsumer(ConsumerConfig{
+		Name:              "orders-retry",
+		Stream:            "ORDERS",
+		MaxDeliver:        1,
+		BackOff:           []time.Duration{20 * time.Millisecond},
+		DeadLetterSubject: "retry.orders",
+	}, func(ctx context.Context, msg jetstream.Msg) error {
+		return errors.New("always failing")
+	})
+	require.NoError(t, err)
+
+	_, err = js.Publish(ctx, "orders.created", []byte("order-3"))
+	require.NoError(t, err)
+
+	// No stream stores the dead-letter subject yet, the publish is retried until there is one
+	time.Sleep(100 * time.Millisecond)
+	_, err = js.CreateStream(ctx, jetstream.StreamConfig{Name: "RETRY", Subjects: []string{"retry.>"}, Storage: jetstream.MemoryStorage})
+	require.NoError(t, err)
+
+	dlq, err := js.OrderedConsumer(ctx, "RETRY", jetstream.OrderedConsumerConfig{})
+	require.NoError(t, err)
+	msg, err := dlq.Next(jetstream.FetchMaxWait(3 * time.Second))
+	require.NoError(t, err)
+	assert.Equal("order-3", string(msg.Data()))
+	assert.Equal("1", msg.Headers().Get(DeadLetterAttemptsHeader))
+}
+
+func TestConsumer_BackOffLongerThanMaxDeliver(t *testing.T) {
+	svc, _, _ := startTestConsumerService(t)
+	err := svc.AddConsumer(ConsumerConfig{
+		Name:       "orders-backoff",
+		Stream:     "ORDERS",
+		MaxDeliver: 1,
+		BackOff:    []time.Duration{time.Millisecond, time.Millisecond},
+	}, func(ctx context.Context, msg jetstream.Msg) error { return nil })
+	assert.Error(t, err)
+}
@@ -0,0 +1,366 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Dead-letter queue defaults
+const (
+	DefaultDeadLetterStream        = "DLQ"
+	DefaultDeadLetterSubjectPrefix = "dlq"
+)
+
+// ErrDeadLetterNotFound is returned when a dead-letter queue entry does not exist
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message that could not be processed, stored in the dead-letter queue
+type DeadLetter struct {
+	Sequence       uint64      `json:"sequence"`                  // Sequence in the dead-letter stream
+	Subject        string      `json:"subject"`                   // Original subject
+	Header         nats.Header `json:"header,omitempty"`          // Original headers
+	Data           []byte      `json:"data,omitempty"`            // Original payload
+	Error          string      `json:"error"`                     // Last handler error
+	Attempts       int         `json:"attempts"`                  // Number of delivery attempts
+	Service        string      `json:"service"`                   // Name of the service that failed
+	Consumer       string      `json:"consumer"`                  // Name of the consumer or endpoint that failed
+	Stream         string      `json:"stream,omitempty"`          // Stream the message was consumed from
+	StreamSequence uint64      `json:"stream_sequence,omitempty"` // Sequence of the message in Stream
+	Time           time.Time   `json:"time"`                      // Time the message was dead-lettered
+}
+
+// Msg returns the dead-letter message published to the dead-letter subject :
+// the original payload and headers, plus the DeadLetter* headers
+func (d *DeadLetter) Msg(subject string) *nats.Msg {
+	header := nats.Header{}
+	for key, values := range d.Header {
+		header[key] = append([]string(nil), values...)
+	}
+	header.Del(nats.MsgIdHdr) // the original message ID would be deduplicated by the dead-letter stream
+	header.Set(DeadLetterSubjectHeader, d.Subject)
+	header.Set(DeadLetterErrorHeader, d.Error)
+	header.Set(DeadLetterAttemptsHeader, strconv.Itoa(d.Attempts))
+	header.Set(DeadLetterServiceHeader, d.Service)
+	header.Set(DeadLetterConsumerHeader, d.Consumer)
+	header.Set(DeadLetterTimeHeader, d.Time.UTC().Format(time.RFC3339Nano))
+	if d.Stream != "" {
+		header.Set(DeadLetterStreamHeader, d.Stream)
+		header.Set(DeadLetterSequenceHeader, strconv.FormatUint(d.StreamSequence, 10))
+	}
+	return &nats.Msg{
+		Subject: subject,
+		Header:  header,
+		Data:    d.Data,
+	}
+}
+
+// parseDeadLetter decodes a message stored in the dead-letter stream
+func parseDeadLetter(msg *jetstream.RawStreamMsg) *DeadLetter {
+	header := nats.Header{}
+	letter := &DeadLetter{
+		Sequence: msg.Sequence,
+		Data:     msg.Data,
+		Time:     msg.Time,
+	}
+	for key, values := range msg.Header {
+		switch key {
+		case DeadLetterSubjectHeader:
+			letter.Subject = values[0]
+		case DeadLetterErrorHeader:
+			letter.Error = values[0]
+		case DeadLetterAttemptsHeader:
+			letter.Attempts, _ = strconv.Atoi(values[0])
+		case DeadLetterServiceHeader:
+			letter.Service = values[0]
+		case DeadLetterConsumerHeader:
+			letter.Consumer = values[0]
+		case DeadLetterStreamHeader:
+			letter.Stream = values[0]
+		case DeadLetterSequenceHeader:
+			letter.StreamSequence, _ = strconv.ParseUint(values[0], 10, 64)
+		case DeadLetterTimeHeader:
+			if t, err := time.Parse(time.RFC3339Nano, values[0]); err == nil {
+				letter.Time = t
+			}
+		default:
+			header[key] = values
+		}
+	}
+	if len(header) > 0 {
+		letter.Header = header
+	}
+	return letter
+}
+
+// DeadLetterConfig configures the dead-letter queue stream
+type DeadLetterConfig struct {
+	Stream        string                `json:"stream,omitempty"`         // Stream name (default DefaultDeadLetterStream)
+	SubjectPrefix string                `json:"subject_prefix,omitempty"` // Subjects are <prefix>.<service>.<consumer> (default DefaultDeadLetterSubjectPrefix)
+	MaxAge        time.Duration         `json:"max_age,omitempty"`        // Retention of the entries, unlimited if zero
+	Storage       jetstream.StorageType `json:"storage"`                  // File storage by default
+	Replicas      int                   `json:"replicas,omitempty"`
+}
+
+// DeadLetterQueue stores the messages that could not be processed in a dedicated stream,
+// and lists, inspects, deletes or re-publishes them to their original subject.
+//
+// Setting ServiceConfig.DeadLetters routes the messages terminated by the service consumers to the queue.
+type DeadLetterQueue struct {
+	js     jetstream.JetStream
+	stream jetstream.Stream
+	prefix string
+}
+
+// NewDeadLetterQueue creates or updates the dead-letter stream described by config
+func NewDeadLetterQueue(ctx context.Context, js jetstream.JetStream, config DeadLetterConfig) (*DeadLetterQueue, error) {
+	if js == nil {
+		return nil, errors.New("jetstream not configured")
+	}
+	if config.Stream == "" {
+		config.Stream = DefaultDeadLetterStream
+	}
+	if config.SubjectPrefix == "" {
+		config.SubjectPrefix = DefaultDeadLetterSubjectPrefix
+	}
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        config.Stream,
+		Description: "dead-letter queue",
+		Subjects:    []string{config.SubjectPrefix + ".>"},
+		MaxAge:      config.MaxAge,
+		Storage:     config.Storage,
+		Replicas:    config.Replicas,
+		Retention:   jetstream.LimitsPolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create dead-letter stream %s: %w", config.Stream, err)
+	}
+	return &DeadLetterQueue{
+		js:     js,
+		stream: stream,
+		prefix: config.SubjectPrefix,
+	}, nil
+}
+
+// Subject returns the subject of the dead letters of a service consumer.
+// The service and consumer names must be valid subject tokens, see validDeadLetterName.
+func (q *DeadLetterQueue) Subject(service, consumer string) string {
+	return q.prefix + "." + service + "." + consumer
+}
+
+// validDeadLetterName checks that the service or consumer name is a single token of the dead-letter subjects,
+// without wildcards selecting the entries of other consumers
+func validDeadLetterName(kind, name string) error {
+	if name == "" {
+		return fmt.Errorf("missing dead letter %s name", kind)
+	}
+	if strings.ContainsAny(name, ".*>") {
+		return fmt.Errorf("invalid dead letter %s name %q: '.', '*' and '>' are not allowed", kind, name)
+	}
+	return nil
+}
+
+// Add stores a dead letter and returns its sequence
+func (q *DeadLetterQueue) Add(ctx context.Context, letter *DeadLetter) (uint64, error) {
+	if err := validDeadLetterName("service", letter.Service); err != nil {
+		return 0, err
+	}
+	if err := validDeadLetterName("consumer", letter.Consumer); err != nil {
+		return 0, err
+	}
+	if letter.Time.IsZero() {
+		letter.Time = time.Now()
+	}
+	ack, err := q.js.PublishMsg(ctx, letter.Msg(q.Subject(letter.Service, letter.Consumer)))
+	if err != nil {
+		return 0, fmt.Errorf("add dead letter: %w", err)
+	}
+	letter.Sequence = ack.Sequence
+	return ack.Sequence, nil
+}
+
+// DeadLetterFilter selects dead-letter queue entries
+type DeadLetterFilter struct {
+	Service       string `json:"service,omitempty"`        // All services if empty
+	Consumer      string `json:"consumer,omitempty"`       // All consumers if empty
+	StartSequence uint64 `json:"start_sequence,omitempty"` // First sequence to return
+	Limit         int    `json:"limit,omitempty"`          // Maximum number of entries, 100 if zero
+}
+
+// List returns the entries matching filter, in sequence order
+func (q *DeadLetterQueue) List(ctx context.Context, filter DeadLetterFilter) ([]*DeadLetter, error) {
+	service, consumer := filter.Service, filter.Consumer
+	if service == "" {
+		service = "*"
+	} else if err := validDeadLetterName("service", service); err != nil {
+		return nil, err
+	}
+	if consumer == "" {
+		consumer = "*"
+	} else if err := validDeadLetterName("consumer", consumer); err != nil {
+		return nil, err
+	}
+	subject := q.Subject(service, consumer)
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	letters := make([]*DeadLetter, 0)
+	seq := max(filter.StartSequence, 1)
+	for len(letters) < limit {
+		msg, err := q.stream.GetMsg(ctx, seq, jetstream.WithGetMsgSubject(subject))
+		if errors.Is(err, jetstream.ErrMsgNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list dead letters: %w", err)
+		}
+		letters = append(letters, parseDeadLetter(msg))
+		seq = msg.Sequence + 1
+	}
+	return letters, nil
+}
+
+// Get returns the entry with the given sequence, or ErrDeadLetterNotFound
+func (q *DeadLetterQueue) Get(ctx context.Context, seq uint64) (*DeadLetter, error) {
+	msg, err := q.stream.GetMsg(ctx, seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return nil, ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get dead letter %d: %w", seq, err)
+	}
+	if !strings.HasPrefix(msg.Subject, q.prefix+".") {
+		return nil, ErrDeadLetterNotFound
+	}
+	return parseDeadLetter(msg), nil
+}
+
+// Delete removes the entry with the given sequence, or returns ErrDeadLetterNotFound
+func (q *DeadLetterQueue) Delete(ctx context.Context, seq uint64) error {
+	if _, err := q.Get(ctx, seq); err != nil {
+		return err
+	}
+	return q.deleteMsg(ctx, seq)
+}
+
+// deleteMsg removes the message with the given sequence from the dead-letter stream
+func (q *DeadLetterQueue) deleteMsg(ctx context.Context, seq uint64) error {
+	err := q.stream.DeleteMsg(ctx, seq)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("delete dead letter %d: %w", seq, err)
+	}
+	return nil
+}
+
+// Replay re-publishes the entry with the given sequence to its original subject, with its original
+// headers, and removes it from the queue. The original subject must be captured by a stream.
+func (q *DeadLetterQueue) Replay(ctx context.Context, seq uint64) error {
+	letter, err := q.Get(ctx, seq)
+	if err != nil {
+		return err
+	}
+	if letter.Subject == "" {
+		return fmt.Errorf("dead letter %d has no original subject", seq)
+	}
+	_, err = q.js.PublishMsg(ctx, &nats.Msg{
+		Subject: letter.Subject,
+		Header:  letter.Header,
+		Data:    letter.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("replay dead letter %d: %w", seq, err)
+	}
+	return q.deleteMsg(ctx, seq)
+}
+
+// DeadLetterListResponse is the response of the dlq_list endpoint
+type DeadLetterListResponse struct {
+	Entries []*DeadLetter `json:"entries"`
+}
+
+// DeadLetterGetRequest is the request of the dlq_get endpoint
+type DeadLetterGetRequest struct {
+	Sequence uint64 `json:"sequence"`
+}
+
+// DeadLetterSequencesRequest is the request of the dlq_replay and dlq_delete endpoints
+type DeadLetterSequencesRequest struct {
+	Sequences []uint64 `json:"sequences"`
+}
+
+// Validate checks that sequences are provided
+func (r *DeadLetterSequencesRequest) Validate() error {
+	if len(r.Sequences) == 0 {
+		return errors.New("missing sequences")
+	}
+	return nil
+}
+
+// DeadLetterSequencesResponse reports the entries processed by the dlq_replay and dlq_delete endpoints
+type DeadLetterSequencesResponse struct {
+	Processed []uint64          `json:"processed"`        // Entries replayed or deleted
+	Errors    map[uint64]string `json:"errors,omitempty"` // Failures by sequence
+}
+
+// DeadLetterEndpoints returns the endpoints managing the dead-letter queue :
+//   - dlq_list (DeadLetterFilter) lists entries
+//   - dlq_get (DeadLetterGetRequest) returns an entry, or a 404 error
+//   - dlq_replay (DeadLetterSequencesRequest) re-publishes entries to their original subject
+//   - dlq_delete (DeadLetterSequencesRequest) deletes entries
+//
+// configure completes the endpoint configurations if not nil, e.g. to require a role.
+func DeadLetterEndpoints(q *DeadLetterQueue, configure func(svc *Service, config *EndpointConfig)) []Endpointer {
+	return []Endpointer{
+		TypedEndpoint("dlq_list", func(ctx context.Context, filter *DeadLetterFilter) (*DeadLetterListResponse, error) {
+			entries, err := q.List(ctx, *filter)
+			if err != nil {
+				return nil, err
+			}
+			return &DeadLetterListResponse{Entries: entries}, nil
+		}).WithConfig(configure),
+		TypedEndpoint("dlq_get", func(ctx context.Context, req *DeadLetterGetRequest) (*DeadLetter, error) {
+			letter, err := q.Get(ctx, req.Sequence)
+			if errors.Is(err, ErrDeadLetterNotFound) {
+				return nil, Errorf(CodeNotFound, "dead letter %d not found", req.Sequence)
+			}
+			return letter, err
+		}).WithConfig(configure),
+		TypedEndpoint("dlq_replay", func(ctx context.Context, req *DeadLetterSequencesRequest) (*DeadLetterSequencesResponse, error) {
+			return processDeadLetters(req.Sequences, func(seq uint64) error {
+				return q.Replay(ctx, seq)
+			}), nil
+		}).WithConfig(configure),
+		TypedEndpoint("dlq_delete", func(ctx context.Context, req *DeadLetterSequencesRequest) (*DeadLetterSequencesResponse, error) {
+			return processDeadLetters(req.Sequences, func(seq uint64) error {
+				return q.Delete(ctx, seq)
+			}), nil
+		}).WithConfig(configure),
+	}
+}
+
+// processDeadLetters calls process for each sequence and reports the results
+func processDeadLetters(sequences []uint64, process func(seq uint64) error) *DeadLetterSequencesResponse {
+	resp := &DeadLetterSequencesResponse{Processed: make([]uint64, 0, len(sequences))}
+	for _, seq := range sequences {
+		if err := process(seq); err != nil {
+			if resp.Errors == nil {
+				resp.Errors = make(map[uint64]string)
+			}
+			resp.Errors[seq] = err.Error()
+			continue
+		}
+		resp.Processed = append(resp.Processed, seq)
+	}
+	return resp
+}
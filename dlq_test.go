@@ -0,0 +1,131 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetterQueue_Replay(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc, js := startTestConsumerService(t)
+	ctx := context.Background()
+
+	dlq, err := NewDeadLetterQueue(ctx, js, DeadLetterConfig{Storage: jetstream.MemoryStorage})
+	require.NoError(t, err)
+	svc.config.DeadLetters = dlq
+	require.NoError(t, svc.AddEndpoints(DeadLetterEndpoints(dlq, nil)...))
+
+	var healthy atomic.Bool
+	processed := make(chan string, 1)
+	err = svc.AddConsumer(ConsumerConfig{Name: "billing", Stream: "ORDERS", MaxDeliver: 1}, func(ctx context.Context, msg jetstream.Msg) error {
+		if !healthy.Load() {
+			return errors.New("billing unavailable")
+		}
+		processed <- string(msg.Data())
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = js.PublishMsg(ctx, &nats.Msg{
+		Subject: "orders.created",
+		Header:  nats.Header{"Tenant": []string{"acme"}},
+		Data:    []byte("order-1"),
+	})
+	require.NoError(t, err)
+	_, err = js.Publish(ctx, "orders.created", []byte("order-2"))
+	require.NoError(t, err)
+
+	// Failed messages are stored in the dead-letter queue
+	var list *DeadLetterListResponse
+	assert.Eventually(func() bool {
+		list, err = Request[DeadLetterFilter, DeadLetterListResponse](ctx, nc, "test.dlq_list", DeadLetterFilter{Service: "test"})
+		return err == nil && len(list.Entries) == 2
+	}, 2*time.Second, 20*time.Millisecond)
+	require.Len(t, list.Entries, 2)
+	first, second := list.Entries[0], list.Entries[1].Sequence
+	assert.Equal("orders.created", first.Subject)
+	assert.Equal("order-1", string(first.Data))
+	assert.Equal("acme", first.Header.Get("Tenant"))
+	assert.Equal("billing unavailable", first.Error)
+	assert.Equal(1, first.Attempts)
+	assert.Equal("test", first.Service)
+	assert.Equal("billing", first.Consumer)
+	assert.Equal("ORDERS", first.Stream)
+	assert.Equal(uint64(1), first.StreamSequence)
+
+	// Filters select entries
+	list, err = Request[DeadLetterFilter, DeadLetterListResponse](ctx, nc, "test.dlq_list", DeadLetterFilter{Consumer: "other"})
+	require.NoError(t, err)
+	assert.Empty(list.Entries)
+	list, err = Request[DeadLetterFilter, DeadLetterListResponse](ctx, nc, "test.dlq_list", DeadLetterFilter{Limit: 1})
+	require.NoError(t, err)
+	assert.Len(list.Entries, 1)
+
+	// Entries are inspected by sequence
+	letter, err := Request[DeadLetterGetRequest, DeadLetter](ctx, nc, "test.dlq_get", DeadLetterGetRequest{Sequence: first.Sequence})
+	require.NoError(t, err)
+	assert.Equal("order-1", string(letter.Data))
+	_, err = Request[DeadLetterGetRequest, DeadLetter](ctx, nc, "test.dlq_get", DeadLetterGetRequest{Sequence: 1000})
+	assert.ErrorIs(err, NewServiceError(CodeNotFound, ""))
+
+	// Replayed entries are re-published to their original subject and removed
+	healthy.Store(true)
+	resp, err := Request[DeadLetterSequencesRequest, DeadLetterSequencesResponse](ctx, nc, "test.dlq_replay",
+		DeadLetterSequencesRequest{Sequences: []uint64{first.Sequence, 1000}})
+	require.NoError(t, err)
+	assert.Equal([]uint64{first.Sequence}, resp.Processed)
+	assert.Contains(resp.Errors, uint64(1000))
+	select {
+	case data := <-processed:
+		assert.Equal("order-1", data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("replayed message not processed")
+	}
+
+	// Deleted entries are removed
+	resp, err = Request[DeadLetterSequencesRequest, DeadLetterSequencesResponse](ctx, nc, "test.dlq_delete",
+		DeadLetterSequencesRequest{Sequences: []uint64{second}})
+	require.NoError(t, err)
+	assert.Equal([]uint64{second}, resp.Processed)
+
+	entries, err := dlq.List(ctx, DeadLetterFilter{})
+	require.NoError(t, err)
+	assert.Empty(entries)
+}
+
+func TestDeadLetterQueue_Checks(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, js := startTestConsumerService(t)
+	ctx := context.Background()
+	dlq, err := NewDeadLetterQueue(ctx, js, DeadLetterConfig{Storage: jetstream.MemoryStorage})
+	require.NoError(t, err)
+
+	// Names must be single subject tokens
+	_, err = dlq.Add(ctx, &DeadLetter{Service: "billing.eu", Consumer: "invoices"})
+	assert.ErrorContains(err, "invalid dead letter service name")
+	_, err = dlq.Add(ctx, &DeadLetter{Service: "billing", Consumer: ">"})
+	assert.ErrorContains(err, "invalid dead letter consumer name")
+	_, err = dlq.List(ctx, DeadLetterFilter{Consumer: "*"})
+	assert.ErrorContains(err, "invalid dead letter consumer name")
+
+	// Entries out of the subject prefix of the queue are not deleted
+	seq, err := dlq.Add(ctx, &DeadLetter{Service: "billing", Consumer: "invoices"})
+	require.NoError(t, err)
+	other, err := NewDeadLetterQueue(ctx, js, DeadLetterConfig{SubjectPrefix: "other", Storage: jetstream.MemoryStorage})
+	require.NoError(t, err)
+	assert.ErrorIs(other.Delete(ctx, seq), ErrDeadLetterNotFound)
+	_, err = dlq.Get(ctx, seq)
+	assert.NoError(err)
+	assert.NoError(dlq.Delete(ctx, seq))
+	assert.ErrorIs(dlq.Delete(ctx, seq), ErrDeadLetterNotFound)
+}
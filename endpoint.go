@@ -3,6 +3,7 @@ package natsservice
 import (
 	"encoding/json"
 	"log/slog"
+	"time"
 
 	"github.com/nats-io/nats.go/micro"
 )
@@ -14,6 +15,23 @@ type EndpointConfig struct {
 	QueueGroup string            `json:"queue_group,omitempty"` // Queue group group
 	Subject    string            `json:"subject,omitempty"`     // Custom subject
 	UserData   any               `json:"-"`
+	// MaxConcurrency enables a worker pool of MaxConcurrency goroutines handling the requests
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// QueueSize is the number of requests waiting for a worker before rejecting with "503 overloaded"
+	QueueSize int `json:"queue_size,omitempty"`
+	// Timeout bounds the request context, a "408 request timeout" error is sent if the handler did not respond in time
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Middlewares applied to this endpoint only, inside the service-wide middlewares
+	Middlewares []Middleware `json:"-"`
+	// RequiredRoles the authenticated caller must all have, a "403 forbidden" error is sent otherwise
+	RequiredRoles []string `json:"required_roles,omitempty"`
+	// RequiredPermissions the authenticated caller must all have, a "403 forbidden" error is sent otherwise
+	RequiredPermissions []string `json:"required_permissions,omitempty"`
+	// AllowAnonymous accepts requests without credentials when the service has an Authenticator
+	AllowAnonymous bool `json:"allow_anonymous,omitempty"`
+	// LeaderOnly rejects the requests with a retryable "503 not leader" error when the instance is not
+	// the leader (ServiceConfig.Leader), the error details are a NotLeaderDetails
+	LeaderOnly bool `json:"leader_only,omitempty"`
 }
 
 // Endpoint is a base struct that provides common functionality for endpoints.
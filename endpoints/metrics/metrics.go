@@ -78,8 +78,8 @@ func (e *Endpoint) Config() *natsservice.EndpointConfig {
 func (e *Endpoint) Handle(req micro.Request) {
 	defer natsservice.RecoverPanic(e, req)
 
-	// Collect all metrics with background context
-	metricsData, err := e.collector.CollectAllMetrics(context.Background())
+	// Collect all metrics with the request context
+	metricsData, err := e.collector.CollectAllMetrics(natsservice.RequestContext(req))
 
 	// Build response
 	resp := MetricsResponse{
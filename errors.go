@@ -0,0 +1,138 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Error codes used in service error responses
+const (
+	CodeBadRequest    = "400"
+	CodeUnauthorized  = "401"
+	CodeForbidden     = "403"
+	CodeNotFound      = "404"
+	CodeTimeout       = "408"
+	CodeInternalError = "500"
+	CodeUnavailable   = "503"
+)
+
+// ErrorRetryableHeader is the response header flagging a retryable service error
+const ErrorRetryableHeader = "Nats-Service-Error-Retryable"
+
+// ServiceError is an error returned by an endpoint handler and decoded by the client helpers.
+//
+// On the wire, the code and message are sent in the Nats-Service-Error-Code and Nats-Service-Error
+// headers (as micro does), the retryable flag in the Nats-Service-Error-Retryable header,
+// and the whole error is sent as JSON in the response body.
+type ServiceError struct {
+	Code      string          `json:"code"`              // Error code, e.g. "400"
+	Message   string          `json:"message"`           // Error description
+	Details   json.RawMessage `json:"details,omitempty"` // Optional JSON payload
+	Retryable bool            `json:"retryable,omitempty"`
+}
+
+// NewServiceError creates a service error with the given code and message
+func NewServiceError(code, message string) *ServiceError {
+	return &ServiceError{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// Errorf creates a service error with the given code and a formatted message
+func Errorf(code, format string, args ...any) *ServiceError {
+	return NewServiceError(code, fmt.Sprintf(format, args...))
+}
+
+// Error implements the error interface
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("service error %s: %s", e.Code, e.Message)
+}
+
+// Is reports whether target is a *ServiceError with the same code
+func (e *ServiceError) Is(target error) bool {
+	t, ok := target.(*ServiceError)
+	return ok && t.Code == e.Code
+}
+
+// WithDetails sets the JSON encoded details payload and returns the error
+func (e *ServiceError) WithDetails(details any) *ServiceError {
+	data, err := json.Marshal(details)
+	if err == nil {
+		e.Details = data
+	}
+	return e
+}
+
+// WithRetryable sets the retryable flag and returns the error
+func (e *ServiceError) WithRetryable(retryable bool) *ServiceError {
+	e.Retryable = retryable
+	return e
+}
+
+// DecodeDetails unmarshals the details payload into v
+func (e *ServiceError) DecodeDetails(v any) error {
+	if len(e.Details) == 0 {
+		return errors.New("service error has no details")
+	}
+	return json.Unmarshal(e.Details, v)
+}
+
+// RespondError sends an error response for err.
+// A *ServiceError found in the error chain is sent with its code, message, details and retryable flag,
+// context.DeadlineExceeded as "408 request timeout", context.Canceled as a retryable "503 request cancelled",
+// and any other error is logged and sent as "500 internal error".
+func RespondError(request micro.Request, err error) error {
+	var serviceErr *ServiceError
+	switch {
+	case errors.As(err, &serviceErr):
+	case errors.Is(err, context.DeadlineExceeded):
+		serviceErr = RequestTimeoutError()
+	case errors.Is(err, context.Canceled):
+		serviceErr = NewServiceError(CodeUnavailable, "request cancelled").WithRetryable(true)
+	default:
+		RequestLogger(request).Error("endpoint handler failed", "error", err)
+		serviceErr = NewServiceError(CodeInternalError, "internal error")
+	}
+
+	body, err := json.Marshal(serviceErr)
+	if err != nil {
+		body = nil
+	}
+
+	var opts []micro.RespondOpt
+	if serviceErr.Retryable {
+		opts = append(opts, micro.WithHeaders(micro.Headers{ErrorRetryableHeader: []string{"true"}}))
+	}
+	return request.Error(serviceErr.Code, serviceErr.Message, body, opts...)
+}
+
+// ResponseError returns the *ServiceError carried by a response message, or nil if msg is not an error response.
+// The code and message are read from the headers; details and retryable flag from the body when present.
+func ResponseError(msg *nats.Msg) error {
+	if msg == nil || msg.Header == nil {
+		return nil
+	}
+	code := msg.Header.Get(micro.ErrorCodeHeader)
+	if code == "" {
+		return nil
+	}
+
+	serviceErr := &ServiceError{
+		Code:      code,
+		Message:   msg.Header.Get(micro.ErrorHeader),
+		Retryable: msg.Header.Get(ErrorRetryableHeader) == "true",
+	}
+
+	var body ServiceError
+	if len(msg.Data) > 0 && json.Unmarshal(msg.Data, &body) == nil && body.Code == code {
+		serviceErr.Details = body.Details
+		serviceErr.Retryable = serviceErr.Retryable || body.Retryable
+	}
+	return serviceErr
+}
@@ -0,0 +1,63 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type quotaDetails struct {
+	Limit int `json:"limit"`
+}
+
+func TestServiceError_RoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	err := svc.AddEndpoint(TypedEndpoint("quota", func(ctx context.Context, req *struct{}) (*struct{}, error) {
+		return nil, Errorf("429", "quota exceeded").WithDetails(quotaDetails{Limit: 10}).WithRetryable(true)
+	}))
+	require.NoError(t, err)
+	err = svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "plain"},
+		handle: func(request micro.Request) {
+			request.Error("503", "unavailable", nil)
+		},
+	})
+	require.NoError(t, err)
+
+	// Error with details and retryable flag
+	_, err = Request[struct{}, struct{}](context.Background(), nc, "test.quota", struct{}{})
+	var serviceErr *ServiceError
+	require.True(t, errors.As(err, &serviceErr))
+	assert.Equal("429", serviceErr.Code)
+	assert.Equal("quota exceeded", serviceErr.Message)
+	assert.True(serviceErr.Retryable)
+	var details quotaDetails
+	assert.NoError(serviceErr.DecodeDetails(&details))
+	assert.Equal(10, details.Limit)
+	assert.ErrorIs(err, NewServiceError("429", ""))
+
+	// Plain micro error, decoded from headers only
+	_, err = Request[struct{}, struct{}](context.Background(), nc, "test.plain", struct{}{})
+	require.True(t, errors.As(err, &serviceErr))
+	assert.Equal("503", serviceErr.Code)
+	assert.Equal("unavailable", serviceErr.Message)
+	assert.False(serviceErr.Retryable)
+	assert.Error(serviceErr.DecodeDetails(&details))
+
+	// Asynchronous request
+	future := RequestAsync[struct{}, struct{}](context.Background(), nc, "test.quota", struct{}{})
+	_, err = future.Await(context.Background())
+	assert.ErrorIs(err, NewServiceError("429", ""))
+}
+
+func TestResponseError_NotAnError(t *testing.T) {
+	assert.NoError(t, ResponseError(nil))
+	assert.NoError(t, ResponseError(&nats.Msg{Data: []byte("{}")}))
+}
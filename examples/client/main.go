@@ -20,9 +20,18 @@ func main() {
 
 	ctx := context.Background()
 
+	// The demo service endpoints are in the "demo" group
+	client, err := natsservice.NewClient(nc,
+		natsservice.WithSubjectPrefix("demo"),
+		natsservice.WithRetry(natsservice.DefaultRetryPolicy),
+	)
+	if err != nil {
+		log.Fatal("Failed to create client:", err)
+	}
+
 	// Example 1: Call the add endpoint
 	fmt.Println("Calling add endpoint...")
-	result, err := CallAddEndpoint(ctx, nc, 5.5, 3.2)
+	result, err := CallAddEndpoint(ctx, client, 5.5, 3.2)
 	if err != nil {
 		log.Printf("Error calling add endpoint: %v", err)
 	} else {
@@ -31,24 +40,16 @@ func main() {
 
 }
 
-// CallAddEndpoint demonstrates how to call the add endpoint using the generic Request function
-func CallAddEndpoint(ctx context.Context, nc *nats.Conn, a, b float64) (float64, error) {
+// CallAddEndpoint demonstrates how to call the add endpoint using the generic Call function
+func CallAddEndpoint(ctx context.Context, client *natsservice.Client, a, b float64) (float64, error) {
 	// Create the request using the add package types
 	req := add.AddRequest{
 		A: a,
 		B: b,
 	}
 
-	// Call the generic Request function
-	// Note: The subject should match the endpoint's subject configuration
-	// The service uses Group: "demo" and the endpoint name is "add", resulting in "demo.add"
-	response, err := natsservice.Request[add.AddRequest, add.AddResponse](
-		ctx,
-		nc,
-		"demo.add", // Subject for the add endpoint
-		req,
-	)
-
+	// The client prefixes the endpoint name with the service group
+	response, err := natsservice.Call[add.AddRequest, add.AddResponse](ctx, client, "add", req)
 	if err != nil {
 		return 0, fmt.Errorf("failed to call add endpoint: %w", err)
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"time"
 
@@ -46,13 +47,15 @@ func main() {
 		Version:     "0.0.1",
 		Description: "demo service",
 		Metadata:    nil,
+		Middlewares: []natsservice.Middleware{
+			natsservice.Logging(slog.LevelDebug),
+		},
 	})
 
 	if err != nil {
 		log.Error("Failed to start service", "error", err)
 		return
 	}
-	defer service.Stop()
 
 	commonCounter := &counter.CommonCounter{}
 
@@ -67,4 +70,12 @@ func main() {
 	}
 
 	<-ctx.Done()
+
+	// Wait for in-flight requests before closing the connection
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	err = service.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Error("Failed to shutdown service", "error", err)
+	}
 }
@@ -2,7 +2,6 @@ package user_store
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 
 	"github.com/telemac/natsservice/examples/user_service/model"
@@ -12,14 +11,14 @@ import (
 var _ UserStore = (*KvUserStore)(nil)
 
 type KvUserStore struct {
-	kv  keyvalue.KeyValuer
-	ctx context.Context
+	users *keyvalue.Store[model.User]
+	ctx   context.Context
 }
 
 func NewKvUserStore(ctx context.Context, kv keyvalue.KeyValuer) *KvUserStore {
 	return &KvUserStore{
-		ctx: ctx,
-		kv:  kv,
+		ctx:   ctx,
+		users: keyvalue.NewStore[model.User](kv, "user"),
 	}
 }
 
@@ -29,30 +28,24 @@ func (store *KvUserStore) Add(user *model.User) error {
 		return err
 	}
 
-	userData, err := json.Marshal(user)
-	if err != nil {
-		return err
+	// Store user by UUID, without overwriting an existing user if the store supports it
+	err = store.users.Create(store.ctx, user.Uuid, user)
+	if errors.Is(err, keyvalue.ErrNotSupported) {
+		return store.users.Put(store.ctx, user.Uuid, user)
 	}
-
-	// Store user by UUID
-	return store.kv.Set(store.ctx, "user."+user.Uuid, userData)
+	if errors.Is(err, keyvalue.ErrKeyExists) {
+		return errors.New("user already exists")
+	}
+	return err
 }
 
 func (store *KvUserStore) Get(uuid string) (model.User, error) {
-	// Then get user by UUID
-	userData, err := store.kv.Get(store.ctx, "user."+uuid)
+	user, err := store.users.Get(store.ctx, uuid)
 	if err != nil {
 		if errors.Is(err, keyvalue.ErrKeyNotFound) {
 			return model.User{}, errors.New("user not found")
 		}
 		return model.User{}, err
 	}
-
-	var user model.User
-	err = json.Unmarshal(userData, &user)
-	if err != nil {
-		return model.User{}, err
-	}
-
-	return user, nil
+	return *user, nil
 }
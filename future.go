@@ -0,0 +1,102 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Future is the pending response of a request sent by RequestAsync
+type Future[Resp any] struct {
+	done     chan struct{}
+	cancel   context.CancelFunc
+	response *Resp
+	err      error
+}
+
+// RequestAsync sends a request to a NATS microservice endpoint without waiting for its response,
+// which is returned by the Await method of the future.
+// The request waits for its response on the shared response subscription of the connection,
+// as Request does, until ctx is done, DefaultRequestTimeout if ctx has no deadline, or Cancel is called.
+// ctx: context for the request
+// nc: NATS connection
+// subject: the subject to send the request to
+// request: the request payload (any type that can be marshaled to JSON)
+// opts: optional request options, e.g. WithNkey to attach credentials
+//
+// Returns:
+//   future: the pending response, its error is a *ServiceError if the endpoint replied with an error
+func RequestAsync[TRequest, TResponse any](
+	ctx context.Context,
+	nc *nats.Conn,
+	subject string,
+	request TRequest,
+	opts ...RequestOption,
+) *Future[TResponse] {
+	var ctxCancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, ctxCancel = context.WithCancel(ctx)
+	} else {
+		ctx, ctxCancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+	}
+
+	f := &Future[TResponse]{
+		done:   make(chan struct{}),
+		cancel: ctxCancel,
+	}
+	go func() {
+		defer ctxCancel()
+		f.response, f.err = Request[TRequest, TResponse](ctx, nc, subject, request, opts...)
+		close(f.done)
+	}()
+	return f
+}
+
+// Done returns a channel closed once the response is received or the request failed
+func (f *Future[Resp]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Await waits for the response of the request.
+// If ctx is done first, it returns the error of ctx and the request goes on.
+func (f *Future[Resp]) Await(ctx context.Context) (*Resp, error) {
+	select {
+	case <-f.done:
+		return f.response, f.err
+	default:
+	}
+	select {
+	case <-f.done:
+		return f.response, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Cancel cancels the request, stopping to wait for its response.
+// Await then returns an error wrapping context.Canceled, unless the response was already received.
+func (f *Future[Resp]) Cancel() {
+	f.cancel()
+}
+
+// AwaitAll waits for the responses of futures, e.g. of requests fanned out with RequestAsync.
+// The responses are in the order of futures, nil for the failed requests, whose errors are joined in error.
+// If ctx is done first, the pending requests are cancelled.
+func AwaitAll[Resp any](ctx context.Context, futures ...*Future[Resp]) ([]*Resp, error) {
+	responses := make([]*Resp, len(futures))
+	var errs []error
+	for i, f := range futures {
+		response, err := f.Await(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				f.Cancel()
+			}
+			errs = append(errs, fmt.Errorf("request %d: %w", i, err))
+			continue
+		}
+		responses[i] = response
+	}
+	return responses, errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package natsservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestAsync(t *testing.T) {
+	assert := assert.New(t)
+	svc, nc := startTestService(t, nil)
+	require.NoError(t, svc.AddEndpoint(TypedEndpoint("divide", func(ctx context.Context, req *divideRequest) (*divideResponse, error) {
+		return &divideResponse{Result: req.A / req.B}, nil
+	})))
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "silent"},
+		handle: func(request micro.Request) {},
+	}))
+	ctx := context.Background()
+
+	future := RequestAsync[divideRequest, divideResponse](ctx, nc, "test.divide", divideRequest{A: 6, B: 3})
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("no response")
+	}
+	response, err := future.Await(ctx)
+	require.NoError(t, err)
+	assert.Equal(2.0, response.Result)
+
+	_, err = RequestAsync[divideRequest, divideResponse](ctx, nc, "test.divide", divideRequest{A: 6, B: 0}).Await(ctx)
+	assert.ErrorIs(err, NewServiceError(CodeBadRequest, ""))
+	_, err = RequestAsync[struct{}, struct{}](ctx, nc, "test.missing", struct{}{}).Await(ctx)
+	assert.ErrorIs(err, nats.ErrNoResponders)
+
+	// The request context bounds the request
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = RequestAsync[struct{}, struct{}](timeoutCtx, nc, "test.silent", struct{}{}).Await(ctx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+
+	// Await gives up without cancelling the request, Cancel does
+	future = RequestAsync[divideRequest, divideResponse](ctx, nc, "test.silent", divideRequest{})
+	awaitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = future.Await(awaitCtx)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	select {
+	case <-future.Done():
+		t.Fatal("request done")
+	default:
+	}
+	future.Cancel()
+	_, err = future.Await(ctx)
+	assert.ErrorIs(err, context.Canceled)
+}
+
+func TestAwaitAll(t *testing.T) {
+	assert := assert.New(t)
+	svc, nc := startTestService(t, nil)
+	require.NoError(t, svc.AddEndpoint(TypedEndpoint("divide", func(ctx context.Context, req *divideRequest) (*divideResponse, error) {
+		return &divideResponse{Result: req.A / req.B}, nil
+	})))
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "silent"},
+		handle: func(request micro.Request) {},
+	}))
+	ctx := context.Background()
+
+	var futures []*Future[divideResponse]
+	for _, b := range []float64{1, 2, 0, 4} {
+		futures = append(futures, RequestAsync[divideRequest, divideResponse](ctx, nc, "test.divide", divideRequest{A: 8, B: b}))
+	}
+	responses, err := AwaitAll(ctx, futures...)
+	assert.ErrorIs(err, NewServiceError(CodeBadRequest, ""))
+	assert.ErrorContains(err, "request 2")
+	require.Len(t, responses, 4)
+	assert.Equal(8.0, responses[0].Result)
+	assert.Equal(4.0, responses[1].Result)
+	assert.Nil(responses[2])
+	assert.Equal(2.0, responses[3].Result)
+
+	// The pending requests are cancelled once the context is done
+	silent := RequestAsync[struct{}, struct{}](ctx, nc, "test.silent", struct{}{})
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = AwaitAll(timeoutCtx, silent)
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	select {
+	case <-silent.Done():
+	case <-time.After(time.Second):
+		t.Fatal("request not cancelled")
+	}
+}
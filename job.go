@@ -0,0 +1,334 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/telemac/natsservice/pkg/keyvalue"
+	"github.com/telemac/natsservice/pkg/schedule"
+)
+
+// KindJob is the value of KindMetadataKey for jobs
+const KindJob = "job"
+
+// JobFunc is the function run by a scheduled job
+type JobFunc func(ctx context.Context) error
+
+// JobOption configures a scheduled job
+type JobOption func(*jobOptions)
+
+type jobOptions struct {
+	jitter  time.Duration
+	timeout time.Duration
+	locker  keyvalue.Locker
+}
+
+// WithJitter delays each run by a random duration up to jitter, to spread the load of periodic jobs.
+// The delay is clamped to half the interval to the next activation, so that no activation is skipped.
+func WithJitter(jitter time.Duration) JobOption {
+	return func(o *jobOptions) {
+		o.jitter = jitter
+	}
+}
+
+// WithJobTimeout bounds the context of each run
+func WithJobTimeout(timeout time.Duration) JobOption {
+	return func(o *jobOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithClusterLock runs each activation on a single instance of the service.
+// The instances try to acquire the "<service>.<job>" lock of locker, e.g. a keyvalue.JetStreamKV shared
+// by the instances : only the winner runs the job, its context is cancelled if the lock is lost.
+// The winner holds the lock for half the interval to the next activation, past the jitter of the other
+// instances, so their clocks must agree within this delay.
+func WithClusterLock(locker keyvalue.Locker) JobOption {
+	return func(o *jobOptions) {
+		o.locker = locker
+	}
+}
+
+// JobStats reports the runs of a scheduled job
+type JobStats struct {
+	Schedule     string        `json:"schedule"`
+	Runs         uint64        `json:"runs"`                    // Completed runs
+	Failures     uint64        `json:"failures"`                // Runs returning an error or panicking
+	Skipped      uint64        `json:"skipped"`                 // Activations skipped because the previous run was still running
+	RanElsewhere uint64        `json:"ran_elsewhere,omitempty"` // Activations whose lock was held by another instance (WithClusterLock)
+	LastRun      time.Time     `json:"last_run,omitempty"`      // Start time of the last run
+	LastDuration time.Duration `json:"last_duration,omitempty"` // Duration of the last run
+	LastError    string        `json:"last_error,omitempty"`    // Error of the last failed run
+	NextRun      time.Time     `json:"next_run,omitempty"`      // Next activation time
+}
+
+// job is a scheduled job added to the service
+type job struct {
+	name     string
+	spec     string
+	schedule schedule.Schedule
+	fn       JobFunc
+	options  jobOptions
+	running  atomic.Bool
+
+	mu    sync.Mutex
+	stats JobStats
+}
+
+func (j *job) snapshot() *JobStats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stats := j.stats
+	return &stats
+}
+
+// AddJob runs fn periodically until the service is stopped.
+//
+// spec is a cron expression ("*/5 * * * *", 6 fields with seconds), a descriptor ("@hourly")
+// or a fixed interval ("@every 30s"), see package schedule. An activation is skipped if the previous
+// run is still running. Runs are tracked as in-flight requests, so Shutdown waits for them.
+//
+// Jobs are registered as micro endpoints with the "kind: job" metadata : their stats are reported in
+// $SRV.STATS, and a request to the "jobs.<service>.<job>" subject, prefixed with the group of the service, returns them.
+func (svc *Service) AddJob(name string, spec string, fn JobFunc, opts ...JobOption) error {
+	if fn == nil {
+		return errors.New("nil job function")
+	}
+	if svc.microSvc == nil {
+		return errors.New("micro service not initialized")
+	}
+	if name == "" {
+		return errors.New("missing job name")
+	}
+	sched, err := schedule.Parse(spec)
+	if err != nil {
+		return err
+	}
+
+	j := &job{
+		name:     name,
+		spec:     spec,
+		schedule: sched,
+		fn:       fn,
+		stats:    JobStats{Schedule: spec},
+	}
+	for _, opt := range opts {
+		opt(&j.options)
+	}
+
+	svc.mu.Lock()
+	if _, exists := svc.jobs[name]; exists {
+		svc.mu.Unlock()
+		return fmt.Errorf("job %s already exists", name)
+	}
+	if _, err := svc.reserveName(name, KindJob); err != nil {
+		svc.mu.Unlock()
+		return err
+	}
+	svc.jobs[name] = j
+	svc.mu.Unlock()
+
+	err = svc.microSvc.AddEndpoint(name, micro.HandlerFunc(func(request micro.Request) {
+		request.RespondJSON(j.snapshot())
+	}),
+		micro.WithEndpointSubject(svc.jobSubject(name)),
+		micro.WithEndpointMetadata(map[string]string{KindMetadataKey: KindJob, "schedule": spec}),
+		micro.WithEndpointQueueGroupDisabled(),
+	)
+	if err != nil {
+		svc.mu.Lock()
+		delete(svc.jobs, name)
+		delete(svc.handlerKinds, name)
+		svc.mu.Unlock()
+		return err
+	}
+
+	go svc.runJob(j)
+	return nil
+}
+
+// jobSubject returns the subject of the stats of the job name, "[<group>.]jobs.<service>.<job>"
+func (svc *Service) jobSubject(name string) string {
+	subject := "jobs." + svc.config.Name + "." + name
+	if svc.config.Group != "" {
+		subject = svc.config.Group + "." + subject
+	}
+	return subject
+}
+
+// maxJitter returns the jitter of the activation at next, clamped below the interval to the following activation
+func (j *job) maxJitter(next time.Time) time.Duration {
+	jitter := j.options.jitter
+	if jitter <= 0 {
+		return 0
+	}
+	if following := j.schedule.Next(next); !following.IsZero() {
+		jitter = min(jitter, following.Sub(next)/2)
+	}
+	return jitter
+}
+
+// lockTTL returns the ttl of the cluster lock of the activation at next, half the interval to the following activation
+func (j *job) lockTTL(next time.Time) time.Duration {
+	ttl := keyvalue.MinLockTTL
+	if following := j.schedule.Next(next); !following.IsZero() {
+		ttl = max(ttl, following.Sub(next)/2)
+	}
+	return ttl
+}
+
+// runJob schedules the activations of j until the service is stopped
+func (svc *Service) runJob(j *job) {
+	log := svc.Logger().With(
+		"service", svc.config.Name,
+		"job", j.name,
+	)
+
+	for {
+		next := j.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Warn("job schedule has no next activation")
+			return
+		}
+		j.mu.Lock()
+		j.stats.NextRun = next
+		j.mu.Unlock()
+
+		delay := time.Until(next)
+		if jitter := j.maxJitter(next); jitter > 0 {
+			delay += rand.N(jitter)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-svc.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !j.running.CompareAndSwap(false, true) {
+			log.Warn("job still running, activation skipped")
+			j.mu.Lock()
+			j.stats.Skipped++
+			j.mu.Unlock()
+			continue
+		}
+		if !svc.beginRequest() {
+			j.running.Store(false)
+			return
+		}
+		go func() {
+			defer svc.endRequest()
+			defer j.running.Store(false)
+			svc.activateJob(log, j, next)
+		}()
+	}
+}
+
+// activateJob runs j for the activation time, unless another instance won the cluster lock
+func (svc *Service) activateJob(log *slog.Logger, j *job, activation time.Time) {
+	ctx := ContextWithLogger(svc.handlerCtx, log)
+
+	if j.options.locker != nil {
+		lock, err := j.options.locker.TryLock(ctx, svc.config.Name+"."+j.name, j.lockTTL(activation))
+		if errors.Is(err, keyvalue.ErrLocked) {
+			j.mu.Lock()
+			j.stats.RanElsewhere++
+			j.mu.Unlock()
+			return
+		}
+		if err != nil {
+			log.Error("job lock failed", "error", err)
+			j.mu.Lock()
+			j.stats.Failures++
+			j.stats.LastError = err.Error()
+			j.mu.Unlock()
+			return
+		}
+		defer svc.releaseJobLock(log, j, lock, activation)
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-lock.Lost():
+				log.Warn("job lock lost, run cancelled")
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	if j.options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.options.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := runJobFunc(ctx, j.fn)
+	duration := time.Since(start)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats.Runs++
+	j.stats.LastRun = start
+	j.stats.LastDuration = duration
+	if err != nil {
+		log.Error("job failed", "error", err, "duration", duration)
+		j.stats.Failures++
+		j.stats.LastError = err.Error()
+		return
+	}
+	log.Debug("job completed", "duration", duration)
+}
+
+// runJobFunc calls fn, converting panics to errors
+func runJobFunc(ctx context.Context, fn JobFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// releaseJobLock releases the cluster lock of the activation once held for its ttl, so that the other
+// instances find it held until their jittered activation. The lock is released right away when the service stops.
+func (svc *Service) releaseJobLock(log *slog.Logger, j *job, lock *keyvalue.Lock, activation time.Time) {
+	release := func() {
+		if err := j.options.locker.Unlock(context.WithoutCancel(svc.handlerCtx), lock); err != nil {
+			log.Warn("job unlock failed", "error", err)
+		}
+	}
+	hold := time.Until(activation.Add(lock.TTL))
+	if hold <= 0 {
+		release()
+		return
+	}
+	go func() {
+		timer := time.NewTimer(hold)
+		defer timer.Stop()
+		select {
+		case <-svc.done:
+		case <-timer.C:
+		}
+		release()
+	}()
+}
+
+// jobStats returns the stats of the job named name, or nil
+func (svc *Service) jobStats(name string) *JobStats {
+	if j, ok := svc.jobs[name]; ok {
+		return j.snapshot()
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/keyvalue"
+	"github.com/telemac/natsservice/pkg/schedule"
+)
+
+func TestJob_Run(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	var runs atomic.Int32
+	err := svc.AddJob("tick", "* * * * * *", func(ctx context.Context) error {
+		if runs.Add(1) == 1 {
+			return errors.New("first run fails")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Error(svc.AddJob("tick", "@every 1s", func(ctx context.Context) error { return nil }))
+	assert.ErrorContains(svc.AddJob("invalid", "* *", func(ctx context.Context) error { return nil }), "invalid schedule")
+
+	assert.Eventually(func() bool { return runs.Load() >= 2 }, 3*time.Second, 10*time.Millisecond)
+
+	// Job stats are exposed in $SRV.STATS and on the job subject
+	stats := svc.Stats()
+	require.Len(t, stats.Endpoints, 1)
+	var data EndpointStatsData
+	require.NoError(t, json.Unmarshal(stats.Endpoints[0].Data, &data))
+	require.NotNil(t, data.Job)
+	assert.Equal("* * * * * *", data.Job.Schedule)
+	assert.GreaterOrEqual(data.Job.Runs, uint64(1))
+	assert.Equal(uint64(1), data.Job.Failures)
+	assert.Equal("first run fails", data.Job.LastError)
+	assert.False(data.Job.NextRun.IsZero())
+
+	msg, err := nc.Request("test.jobs.test.tick", nil, time.Second)
+	require.NoError(t, err)
+	var jobStats JobStats
+	require.NoError(t, json.Unmarshal(msg.Data, &jobStats))
+	assert.Equal(uint64(1), jobStats.Failures)
+
+	// Jobs stop with the service
+	require.NoError(t, svc.Stop())
+	time.Sleep(50 * time.Millisecond) // let a run started before Stop complete
+	stopped := runs.Load()
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(stopped, runs.Load())
+}
+
+func TestJob_OverlapSkipped(t *testing.T) {
+	svc, _ := startTestService(t, nil)
+	release := make(chan struct{})
+	var runs atomic.Int32
+	err := svc.AddJob("slow", "* * * * * *", func(ctx context.Context) error {
+		runs.Add(1)
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return svc.jobStats("slow").Skipped >= 1
+	}, 3*time.Second, 10*time.Millisecond)
+	close(release)
+	assert.Equal(t, int32(1), runs.Load())
+}
+
+func TestJob_ClusterLock(t *testing.T) {
+	assert := assert.New(t)
+
+	svc1, _ := startTestService(t, func(config *ServiceConfig) {
+		var err error
+		config.Js, err = jetstream.New(config.Nc)
+		require.NoError(t, err)
+	})
+	ctx := context.Background()
+	bucket, err := keyvalue.NewJetStreamKVWithOptions(ctx, svc1.Jetstream(), &jetstream.KeyValueConfig{Bucket: "jobs", Storage: jetstream.MemoryStorage}, nil)
+	require.NoError(t, err)
+
+	// Second instance of the service
+	svc2, err := StartService(&ServiceConfig{
+		Ctx:     ctx,
+		Nc:      svc1.Nc(),
+		Logger:  slog.Default(),
+		Name:    "test",
+		Version: "0.0.1",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { svc2.Stop() })
+
+	activations := make(chan int64, 10)
+	job := func(ctx context.Context) error {
+		activations <- time.Now().Truncate(time.Second).Unix()
+		return nil
+	}
+	require.NoError(t, svc1.AddJob("single", "* * * * * *", job, WithClusterLock(bucket)))
+	require.NoError(t, svc2.AddJob("single", "* * * * * *", job, WithClusterLock(bucket)))
+
+	assert.Eventually(func() bool {
+		stats1, stats2 := svc1.jobStats("single"), svc2.jobStats("single")
+		return stats1.Runs+stats2.Runs >= 2 && stats1.RanElsewhere+stats2.RanElsewhere >= 2
+	}, 4*time.Second, 10*time.Millisecond)
+	// Shutdown waits for the running jobs
+	require.NoError(t, svc1.Shutdown(ctx))
+	require.NoError(t, svc2.Shutdown(ctx))
+	close(activations)
+
+	// Each activation ran once
+	seen := map[int64]bool{}
+	for activation := range activations {
+		assert.False(seen[activation], "activation %d ran twice", activation)
+		seen[activation] = true
+	}
+}
+
+func TestJob_ClusterLockHeld(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, _ := startTestService(t, nil)
+	locker := keyvalue.NewMemoryKV()
+	ran := make(chan struct{}, 10)
+	require.NoError(t, svc.AddJob("held", "* * * * * *", func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}, WithClusterLock(locker)))
+	ctx := context.Background()
+
+	// The lock is held after the run, until the jitter of the other instances is over
+	select {
+	case <-ran:
+	case <-time.After(3 * time.Second):
+		t.Fatal("job not run")
+	}
+	_, err := locker.TryLock(ctx, "test.held", time.Second)
+	assert.ErrorIs(err, keyvalue.ErrLocked)
+
+	// Activations are not run while another instance holds the lock
+	var lock *keyvalue.Lock
+	require.Eventually(t, func() bool {
+		lock, err = locker.TryLock(ctx, "test.held", time.Second)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Eventually(func() bool { return svc.jobStats("held").RanElsewhere >= 1 }, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, locker.Unlock(ctx, lock))
+}
+
+func TestJob_LockTTL(t *testing.T) {
+	sched, err := schedule.Parse("@every 10s")
+	require.NoError(t, err)
+	j := &job{schedule: sched}
+	assert.Equal(t, 5*time.Second, j.lockTTL(sched.Next(time.Now())))
+}
+
+func TestJob_MaxJitter(t *testing.T) {
+	assert := assert.New(t)
+
+	sched, err := schedule.Parse("@every 10s")
+	require.NoError(t, err)
+	next := sched.Next(time.Now())
+
+	j := &job{schedule: sched, options: jobOptions{jitter: 2 * time.Second}}
+	assert.Equal(2*time.Second, j.maxJitter(next))
+
+	// Jitter larger than the interval would skip activations
+	j.options.jitter = time.Minute
+	assert.Equal(5*time.Second, j.maxJitter(next))
+
+	j.options.jitter = 0
+	assert.Zero(j.maxJitter(next))
+}
@@ -0,0 +1,36 @@
+package natsservice
+
+import (
+	"github.com/nats-io/nats.go/micro"
+)
+
+// LeaderElector reports whether the service instance is the leader of its replicas,
+// it is implemented by keyvalue.Election
+type LeaderElector interface {
+	IsLeader() bool
+	Leader() string // ID of the current leader, or an empty string if unknown
+}
+
+// NotLeaderDetails are the details of the "503 not leader" error sent by leader-only endpoints
+// of non-leader instances, Leader is the ID of the current leader to redirect to
+type NotLeaderDetails struct {
+	Leader string `json:"leader,omitempty"`
+}
+
+// leaderMiddleware rejects the requests with a retryable "503 not leader" error
+// when the service instance is not the leader
+func leaderMiddleware(elector LeaderElector) Middleware {
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(request micro.Request) {
+			if !elector.IsLeader() {
+				serviceErr := NewServiceError(CodeUnavailable, "not leader").WithRetryable(true)
+				if leader := elector.Leader(); leader != "" {
+					serviceErr = serviceErr.WithDetails(NotLeaderDetails{Leader: leader})
+				}
+				RespondError(request, serviceErr)
+				return
+			}
+			next.Handle(request)
+		})
+	}
+}
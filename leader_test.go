@@ -0,0 +1,72 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testElector is a LeaderElector switched by the tests
+type testElector struct {
+	leader atomic.Bool
+}
+
+func (e *testElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+func (e *testElector) Leader() string {
+	if e.leader.Load() {
+		return "self"
+	}
+	return "other"
+}
+
+func TestLeaderOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	elector := &testElector{}
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Leader = elector
+	})
+	echo := func(ctx context.Context, req *string) (*string, error) { return req, nil }
+	require.NoError(t, svc.AddEndpoint(TypedEndpoint("leader", echo).WithConfig(func(svc *Service, config *EndpointConfig) {
+		config.LeaderOnly = true
+	})))
+	require.NoError(t, svc.AddEndpoint(TypedEndpoint("any", echo)))
+	ctx := context.Background()
+	hello := "hello"
+
+	// Non-leaders reject leader-only requests with the leader ID
+	_, err := Request[string, string](ctx, nc, "test.leader", hello)
+	var serviceErr *ServiceError
+	require.True(t, errors.As(err, &serviceErr))
+	assert.Equal(CodeUnavailable, serviceErr.Code)
+	assert.True(serviceErr.Retryable)
+	var details NotLeaderDetails
+	require.NoError(t, serviceErr.DecodeDetails(&details))
+	assert.Equal("other", details.Leader)
+
+	resp, err := Request[string, string](ctx, nc, "test.any", hello)
+	require.NoError(t, err)
+	assert.Equal(hello, *resp)
+
+	elector.leader.Store(true)
+	resp, err = Request[string, string](ctx, nc, "test.leader", hello)
+	require.NoError(t, err)
+	assert.Equal(hello, *resp)
+}
+
+func TestLeaderOnly_RequiresElector(t *testing.T) {
+	svc, _ := startTestService(t, nil)
+	err := svc.AddEndpoint(TypedEndpoint("leader", func(ctx context.Context, req *string) (*string, error) {
+		return req, nil
+	}).WithConfig(func(svc *Service, config *EndpointConfig) {
+		config.LeaderOnly = true
+	}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package natsservice
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/hypersequent/uuid7"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// RequestIDHeader is the header carrying the request identifier
+const RequestIDHeader = "X-Request-Id"
+
+// Middleware wraps a micro.Handler with additional behaviour.
+// Middlewares are applied when an endpoint is added to the service :
+// service-wide middlewares (ServiceConfig.Middlewares, Service.Use) wrap
+// endpoint middlewares (EndpointConfig.Middlewares), which wrap the endpoint Handle method.
+type Middleware func(next micro.Handler) micro.Handler
+
+// Chain wraps handler with the given middlewares, the first middleware being the outermost
+func Chain(handler micro.Handler, middlewares ...Middleware) micro.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			handler = middlewares[i](handler)
+		}
+	}
+	return handler
+}
+
+// Recover returns a middleware catching panics in the wrapped handler.
+// The panic is logged with the request logger and a "500 internal error" response is sent.
+// The service installs it on every endpoint, adding it to the middlewares only recovers closer to the handler.
+func Recover() Middleware {
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(request micro.Request) {
+			defer func() {
+				if r := recover(); r != nil {
+					RequestLogger(request).Error("service endpoint panicked", "panic", r)
+					if req, ok := request.(*serviceRequest); ok && req.responded() {
+						return
+					}
+					request.Error("500", "internal error", nil)
+				}
+			}()
+			next.Handle(request)
+		})
+	}
+}
+
+// Logging returns a middleware logging every request with its subject and duration at the given level
+func Logging(level slog.Level) Middleware {
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(request micro.Request) {
+			start := time.Now()
+			next.Handle(request)
+			RequestLogger(request).Log(RequestContext(request), level, "request handled",
+				"subject", request.Subject(),
+				"duration", time.Since(start),
+			)
+		})
+	}
+}
+
+// Latency returns a middleware measuring the handler execution time.
+// observe is called after each request with the endpoint name and the elapsed time.
+func Latency(observe func(endpoint string, elapsed time.Duration)) Middleware {
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(request micro.Request) {
+			start := time.Now()
+			next.Handle(request)
+			if observe != nil {
+				observe(RequestEndpointName(request), time.Since(start))
+			}
+		})
+	}
+}
+
+// RequestID returns a middleware injecting a request identifier (correlation ID).
+// The identifier is taken from the X-Request-Id request header, or generated (UUIDv7) if absent.
+// It is stored in the request context, added to the request logger and echoed in the response headers.
+// The client helpers forward the request ID found in their context, so calls made from a handler
+// with the request context share the same correlation ID.
+//
+// The service applies RequestID to all endpoints, requests already carrying a request ID are passed through.
+func RequestID() Middleware {
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(request micro.Request) {
+			if GetRequestID(request) != "" {
+				next.Handle(request)
+				return
+			}
+			id := request.Headers().Get(RequestIDHeader)
+			if id == "" {
+				id = uuid7.NewString()
+			}
+			ctx := ContextWithRequestID(RequestContext(request), id)
+			ctx = ContextWithLogger(ctx, LoggerFromContext(ctx).With("request_id", id))
+			request = WithRequestContext(request, ctx)
+			SetResponseHeader(request, RequestIDHeader, id)
+			next.Handle(request)
+		})
+	}
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request identifier
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request identifier stored in ctx, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// GetRequestID returns the identifier of a request handled by the service, or an empty string
+func GetRequestID(request micro.Request) string {
+	return RequestIDFromContext(RequestContext(request))
+}
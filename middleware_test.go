@@ -0,0 +1,186 @@
+package natsservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_Order(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next micro.Handler) micro.Handler {
+			return micro.HandlerFunc(func(request micro.Request) {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				next.Handle(request)
+			})
+		}
+	}
+
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Middlewares = []Middleware{record("service")}
+	})
+	svc.Use(record("use"))
+
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "echo", Middlewares: []Middleware{record("endpoint")}},
+		handle: func(request micro.Request) {
+			assert.Equal("echo", RequestEndpointName(request))
+			assert.NotNil(RequestEndpoint(request))
+			request.Respond(request.Data())
+		},
+	})
+	require.NoError(t, err)
+
+	msg, err := nc.Request("test.echo", []byte("hello"), time.Second)
+	require.NoError(t, err)
+	assert.Equal("hello", string(msg.Data))
+	assert.Equal([]string{"service", "use", "endpoint"}, calls)
+}
+
+func TestMiddleware_Recover(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Middlewares = []Middleware{Recover()}
+	})
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "panic"},
+		handle: func(request micro.Request) {
+			panic("boom")
+		},
+	})
+	require.NoError(t, err)
+
+	msg, err := nc.Request("test.panic", nil, time.Second)
+	require.NoError(t, err)
+	assert.Equal("500", msg.Header.Get(micro.ErrorCodeHeader))
+	assert.Equal("internal error", msg.Header.Get(micro.ErrorHeader))
+}
+
+func TestMiddleware_RecoverByDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "panic"},
+		handle: func(request micro.Request) {
+			if string(request.Data()) == "panic" {
+				panic("boom")
+			}
+			request.Respond(request.Data())
+		},
+	})
+	require.NoError(t, err)
+
+	msg, err := nc.Request("test.panic", []byte("panic"), time.Second)
+	require.NoError(t, err)
+	assert.Equal("500", msg.Header.Get(micro.ErrorCodeHeader))
+	assert.Equal("internal error", msg.Header.Get(micro.ErrorHeader))
+	assert.NotEmpty(msg.Header.Get(RequestIDHeader))
+
+	// the service keeps serving after the panic
+	msg, err = nc.Request("test.panic", []byte("hello"), time.Second)
+	require.NoError(t, err)
+	assert.Equal("hello", string(msg.Data))
+}
+
+func TestMiddleware_RequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Middlewares = []Middleware{RequestID()} // built-in, applying it twice keeps the same ID
+	})
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "id"},
+		handle: func(request micro.Request) {
+			request.Respond([]byte(GetRequestID(request)))
+		},
+	})
+	require.NoError(t, err)
+
+	// Provided request ID is used and echoed
+	msg, err := nc.RequestMsg(&nats.Msg{
+		Subject: "test.id",
+		Header:  nats.Header{RequestIDHeader: []string{"my-id"}},
+	}, time.Second)
+	require.NoError(t, err)
+	assert.Equal("my-id", string(msg.Data))
+	assert.Equal("my-id", msg.Header.Get(RequestIDHeader))
+
+	// Missing request ID is generated
+	msg, err = nc.Request("test.id", nil, time.Second)
+	require.NoError(t, err)
+	assert.NotEmpty(msg.Data)
+	assert.Equal(string(msg.Data), msg.Header.Get(RequestIDHeader))
+}
+
+func TestMiddleware_Latency(t *testing.T) {
+	assert := assert.New(t)
+
+	observed := make(chan string, 1)
+	svc, nc := startTestService(t, nil)
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{
+			Name: "slow",
+			Middlewares: []Middleware{Latency(func(endpoint string, elapsed time.Duration) {
+				assert.GreaterOrEqual(elapsed, 10*time.Millisecond)
+				observed <- endpoint
+			})},
+		},
+		handle: func(request micro.Request) {
+			time.Sleep(10 * time.Millisecond)
+			request.Respond(nil)
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = nc.Request("test.slow", nil, time.Second)
+	require.NoError(t, err)
+	select {
+	case endpoint := <-observed:
+		assert.Equal("slow", endpoint)
+	case <-time.After(time.Second):
+		t.Fatal("latency not observed")
+	}
+}
+
+func TestMiddleware_RequestIDForwarded(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	// "front" calls "back" with the request context, "back" returns the request ID it received
+	err := svc.AddEndpoint(TypedEndpoint("front", func(ctx context.Context, req *struct{}) (*string, error) {
+		return Request[struct{}, string](ctx, nc, "test.back", struct{}{})
+	}))
+	require.NoError(t, err)
+	err = svc.AddEndpoint(TypedEndpoint("back", func(ctx context.Context, req *struct{}) (*string, error) {
+		id := RequestIDFromContext(ctx)
+		return &id, nil
+	}))
+	require.NoError(t, err)
+
+	// Request ID generated by the service is forwarded
+	msg, err := nc.Request("test.front", []byte("{}"), time.Second)
+	require.NoError(t, err)
+	id := msg.Header.Get(RequestIDHeader)
+	assert.NotEmpty(id)
+	assert.Equal(`"`+id+`"`, string(msg.Data))
+
+	// Request ID set in the caller context is forwarded
+	ctx := ContextWithRequestID(context.Background(), "caller-id")
+	back, err := Request[struct{}, string](ctx, nc, "test.front", struct{}{})
+	require.NoError(t, err)
+	assert.Equal("caller-id", *back)
+}
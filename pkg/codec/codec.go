@@ -0,0 +1,24 @@
+// Package codec defines how values are encoded on the wire or in a store.
+// It is shared by the natsservice Client and the keyvalue Store.
+package codec
+
+import "encoding/json"
+
+// Codec encodes and decodes values
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSON encodes values as JSON, it is the default codec of the Client and of the Store
+type JSON struct{}
+
+var _ Codec = JSON{}
+
+func (JSON) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSON) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,245 @@
+// Package discovery finds the NATS micro services and their endpoints, by querying
+// the $SRV.PING, $SRV.INFO and $SRV.STATS subjects answered by every service instance.
+//
+// The queries collect the replies of all the instances until the timeout of the client,
+// or until no reply was received for the stall timeout once the first one arrived.
+// They can be restricted to the instances of a service name, or to a single instance with its ID.
+// The replies that cannot be decoded are logged with the logger of the context and skipped,
+// so that a faulty instance does not hide the others.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/telemac/natsservice"
+)
+
+// Client defaults
+const (
+	DefaultTimeout      = 2 * time.Second
+	DefaultStallTimeout = 200 * time.Millisecond
+)
+
+var (
+	ErrServiceNotFound  = errors.New("discovery: service not found")
+	ErrEndpointNotFound = errors.New("discovery: endpoint not found")
+)
+
+// Filter restricts a query to the instances of a service
+type Filter struct {
+	Name string // Service name, all the services if empty
+	ID   string // Instance ID, all the instances of Name if empty, requires Name
+}
+
+// Endpoint describes an endpoint of a service instance
+type Endpoint struct {
+	Name       string            `json:"name"`                  // Endpoint name
+	Subject    string            `json:"subject"`               // Subject of the endpoint, including the group of the service
+	QueueGroup string            `json:"queue_group,omitempty"` // Queue group, empty if every instance receives all the requests
+	Kind       string            `json:"kind,omitempty"`        // Handler kind, "subscriber" or "job" for natsservice subscribers and jobs, empty for endpoints
+	Metadata   map[string]string `json:"metadata,omitempty"`    // Endpoint metadata (EndpointConfig.Metadata)
+}
+
+// Instance describes a running instance of a service
+type Instance struct {
+	ID          string            `json:"id"`                    // Instance ID
+	Version     string            `json:"version"`               // Service version
+	Description string            `json:"description,omitempty"` // Service description
+	Metadata    map[string]string `json:"metadata,omitempty"`    // Service metadata
+	Endpoints   []Endpoint        `json:"endpoints"`             // Endpoints of the instance
+}
+
+// Service gathers the running instances of a service
+type Service struct {
+	Name      string     `json:"name"`      // Service name
+	Instances []Instance `json:"instances"` // Instances sorted by ID
+}
+
+// Endpoint returns the endpoint name of the first instance having it
+func (s *Service) Endpoint(name string) (*Endpoint, bool) {
+	for _, instance := range s.Instances {
+		for i := range instance.Endpoints {
+			if instance.Endpoints[i].Name == name {
+				return &instance.Endpoints[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Client queries the services reachable on a NATS connection
+type Client struct {
+	nc           *nats.Conn
+	timeout      time.Duration
+	stallTimeout time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTimeout bounds the collection of the replies of a query (DefaultTimeout), 0 relies on the context deadline,
+// or natsservice.DefaultRequestTimeout without deadline
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithStallTimeout ends the collection of the replies of a query when no reply was received
+// for timeout since the last one (DefaultStallTimeout), 0 waits until the timeout
+func WithStallTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.stallTimeout = timeout
+	}
+}
+
+// NewClient creates a discovery client sending its queries on nc
+func NewClient(nc *nats.Conn, opts ...Option) (*Client, error) {
+	if nc == nil {
+		return nil, fmt.Errorf("NATS connection is nil")
+	}
+	c := &Client{
+		nc:           nc,
+		timeout:      DefaultTimeout,
+		stallTimeout: DefaultStallTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Ping returns the identity of the instances matching filter
+func (c *Client) Ping(ctx context.Context, filter Filter) ([]micro.Ping, error) {
+	return query[micro.Ping](ctx, c, micro.PingVerb, filter)
+}
+
+// Info returns the description of the instances matching filter, with their endpoints
+func (c *Client) Info(ctx context.Context, filter Filter) ([]micro.Info, error) {
+	return query[micro.Info](ctx, c, micro.InfoVerb, filter)
+}
+
+// Stats returns the statistics of the instances matching filter, with the statistics of their endpoints
+func (c *Client) Stats(ctx context.Context, filter Filter) ([]micro.Stats, error) {
+	return query[micro.Stats](ctx, c, micro.StatsVerb, filter)
+}
+
+// Services returns the services matching filter with their instances, sorted by name
+func (c *Client) Services(ctx context.Context, filter Filter) ([]Service, error) {
+	infos, err := c.Info(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*Service)
+	for _, info := range infos {
+		service, ok := byName[info.Name]
+		if !ok {
+			service = &Service{Name: info.Name}
+			byName[info.Name] = service
+		}
+		service.Instances = append(service.Instances, newInstance(info))
+	}
+
+	services := make([]Service, 0, len(byName))
+	for _, service := range byName {
+		sort.Slice(service.Instances, func(i, j int) bool {
+			return service.Instances[i].ID < service.Instances[j].ID
+		})
+		services = append(services, *service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+	return services, nil
+}
+
+// Service returns the instances of the service name, or ErrServiceNotFound if none is running
+func (c *Client) Service(ctx context.Context, name string) (*Service, error) {
+	if name == "" {
+		return nil, fmt.Errorf("service name is empty")
+	}
+	services, err := c.Services(ctx, Filter{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, name)
+	}
+	return &services[0], nil
+}
+
+// Resolve returns the subject of the endpoint of the service name, e.g. "demo.add" for the endpoint "add"
+// of a service started with the group "demo". It returns ErrServiceNotFound if the service is not running,
+// and ErrEndpointNotFound if none of its instances has the endpoint.
+func (c *Client) Resolve(ctx context.Context, name, endpoint string) (string, error) {
+	service, err := c.Service(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	found, ok := service.Endpoint(endpoint)
+	if !ok {
+		return "", fmt.Errorf("%w: %s.%s", ErrEndpointNotFound, name, endpoint)
+	}
+	return found.Subject, nil
+}
+
+// newInstance converts the micro description of an instance
+func newInstance(info micro.Info) Instance {
+	instance := Instance{
+		ID:          info.ID,
+		Version:     info.Version,
+		Description: info.Description,
+		Metadata:    info.Metadata,
+		Endpoints:   make([]Endpoint, 0, len(info.Endpoints)),
+	}
+	for _, endpoint := range info.Endpoints {
+		instance.Endpoints = append(instance.Endpoints, Endpoint{
+			Name:       endpoint.Name,
+			Subject:    endpoint.Subject,
+			QueueGroup: endpoint.QueueGroup,
+			Kind:       endpoint.Metadata[natsservice.KindMetadataKey],
+			Metadata:   endpoint.Metadata,
+		})
+	}
+	return instance
+}
+
+// query sends the verb request to the instances matching filter, and decodes their replies.
+// The invalid replies are logged and skipped.
+func query[T any](ctx context.Context, c *Client, verb micro.Verb, filter Filter) ([]T, error) {
+	subject, err := micro.ControlSubject(verb, filter.Name, filter.ID)
+	if err != nil {
+		return nil, err
+	}
+	received, err := natsservice.RequestMany[struct{}, T](ctx, c.nc, subject, struct{}{}, natsservice.RequestManyConfig{
+		Timeout:      c.timeout,
+		StallTimeout: c.stallTimeout,
+	})
+	if errors.Is(err, nats.ErrNoResponders) {
+		return nil, nil // no service running
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([]T, 0, len(received))
+	for _, reply := range received {
+		if reply.Err != nil {
+			natsservice.LoggerFromContext(ctx).Warn("discovery: invalid reply skipped",
+				"verb", verb.String(),
+				"instance", reply.InstanceID,
+				"error", reply.Err,
+			)
+			continue
+		}
+		replies = append(replies, *reply.Response)
+	}
+	return replies, nil
+}
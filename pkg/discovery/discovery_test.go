@@ -0,0 +1,153 @@
+package discovery_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice"
+	"github.com/telemac/natsservice/pkg/discovery"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+type addRequest struct{ A, B float64 }
+type addResponse struct{ Result float64 }
+
+// startService starts an instance of the service name with an "add" endpoint and an "events" subscriber
+func startService(t *testing.T, nc *nats.Conn, name, group string) *natsservice.Service {
+	t.Helper()
+	svc, err := natsservice.StartService(&natsservice.ServiceConfig{
+		Ctx:     context.Background(),
+		Nc:      nc,
+		Logger:  slog.Default(),
+		Name:    name,
+		Group:   group,
+		Version: "1.0.0",
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { svc.Stop() })
+
+	add := natsservice.TypedEndpoint("add", func(ctx context.Context, req *addRequest) (*addResponse, error) {
+		return &addResponse{Result: req.A + req.B}, nil
+	}).WithConfig(func(svc *natsservice.Service, config *natsservice.EndpointConfig) {
+		config.Metadata = map[string]string{"unit": "none"}
+	})
+	require.NoError(t, svc.AddEndpoint(add))
+	require.NoError(t, svc.AddSubscriber(natsservice.NewSubscriber("events", "events.>", func(ctx context.Context, msg *nats.Msg) error {
+		return nil
+	})))
+	return svc
+}
+
+func newClient(t *testing.T) (*discovery.Client, *nats.Conn) {
+	srv, cleanup := natstools.TestServer(t)
+	t.Cleanup(cleanup)
+	client, err := discovery.NewClient(srv.Connection(), discovery.WithTimeout(time.Second), discovery.WithStallTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	return client, srv.Connection()
+}
+
+func TestClient_Queries(t *testing.T) {
+	assert := assert.New(t)
+	client, nc := newClient(t)
+	ctx := context.Background()
+
+	calc1 := startService(t, nc, "calc", "demo")
+	calc2 := startService(t, nc, "calc", "demo")
+	startService(t, nc, "other", "")
+
+	pings, err := client.Ping(ctx, discovery.Filter{})
+	require.NoError(t, err)
+	assert.Len(pings, 3)
+
+	infos, err := client.Info(ctx, discovery.Filter{Name: "calc", ID: calc2.ID()})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(calc2.ID(), infos[0].ID)
+
+	stats, err := client.Stats(ctx, discovery.Filter{Name: "calc"})
+	require.NoError(t, err)
+	assert.Len(stats, 2)
+
+	services, err := client.Services(ctx, discovery.Filter{})
+	require.NoError(t, err)
+	require.Len(t, services, 2)
+	assert.Equal("calc", services[0].Name)
+	assert.Equal("other", services[1].Name)
+	require.Len(t, services[0].Instances, 2)
+	ids := []string{services[0].Instances[0].ID, services[0].Instances[1].ID}
+	assert.ElementsMatch([]string{calc1.ID(), calc2.ID()}, ids)
+
+	instance := services[0].Instances[0]
+	assert.Equal("1.0.0", instance.Version)
+	require.Len(t, instance.Endpoints, 2)
+	endpoint, ok := services[0].Endpoint("add")
+	require.True(t, ok)
+	assert.Equal("demo.add", endpoint.Subject)
+	assert.Empty(endpoint.Kind)
+	assert.Equal("none", endpoint.Metadata["unit"])
+	endpoint, ok = services[0].Endpoint("events")
+	require.True(t, ok)
+	assert.Equal("events.>", endpoint.Subject)
+	assert.Equal(natsservice.KindSubscriber, endpoint.Kind)
+
+	_, err = client.Ping(ctx, discovery.Filter{ID: calc1.ID()})
+	assert.ErrorIs(err, micro.ErrServiceNameRequired)
+
+	// No instance of the service
+	pings, err = client.Ping(ctx, discovery.Filter{Name: "missing"})
+	require.NoError(t, err)
+	assert.Empty(pings)
+}
+
+func TestClient_InvalidReply(t *testing.T) {
+	assert := assert.New(t)
+	client, nc := newClient(t)
+	ctx := context.Background()
+
+	svc := startService(t, nc, "calc", "demo")
+	sub, err := nc.Subscribe("$SRV.PING", func(msg *nats.Msg) {
+		msg.Respond([]byte("garbage"))
+	})
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	// The garbage reply is skipped, the valid instances are returned
+	pings, err := client.Ping(ctx, discovery.Filter{})
+	require.NoError(t, err)
+	require.Len(t, pings, 1)
+	assert.Equal(svc.ID(), pings[0].ID)
+}
+
+func TestClient_Resolve(t *testing.T) {
+	assert := assert.New(t)
+	client, nc := newClient(t)
+	ctx := context.Background()
+	startService(t, nc, "calc", "demo")
+	startService(t, nc, "other", "")
+
+	subject, err := client.Resolve(ctx, "calc", "add")
+	require.NoError(t, err)
+	assert.Equal("demo.add", subject)
+	response, err := natsservice.Request[addRequest, addResponse](ctx, nc, subject, addRequest{A: 1, B: 2})
+	require.NoError(t, err)
+	assert.Equal(3.0, response.Result)
+
+	// Without group, the subject is the endpoint name
+	subject, err = client.Resolve(ctx, "other", "add")
+	require.NoError(t, err)
+	assert.Equal("add", subject)
+
+	_, err = client.Resolve(ctx, "calc", "missing")
+	assert.ErrorIs(err, discovery.ErrEndpointNotFound)
+	_, err = client.Resolve(ctx, "missing", "add")
+	assert.ErrorIs(err, discovery.ErrServiceNotFound)
+
+	_, err = discovery.NewClient(nil)
+	assert.Error(err)
+}
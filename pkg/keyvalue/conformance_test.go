@@ -0,0 +1,42 @@
+package keyvalue_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/keyvalue"
+	"github.com/telemac/natsservice/pkg/keyvalue/kvtest"
+	"github.com/telemac/natsservice/pkg/natstools"
+	"github.com/telemac/natsservice/pkg/typeregistry"
+)
+
+func TestConformance_Memory(t *testing.T) {
+	kvtest.RunConformance(t, func(t *testing.T, registry *typeregistry.Registry) keyvalue.KeyValuer {
+		return keyvalue.NewMemoryKVWithOptions(registry).WithHistory(kvtest.HistorySize)
+	})
+}
+
+func TestConformance_JetStream(t *testing.T) {
+	embedded, err := natstools.StartEmbedded()
+	require.NoError(t, err)
+	defer embedded.Shutdown()
+
+	// Each test gets a new bucket, in memory to start empty. The buckets keep a history,
+	// their LimitMarkerTTL must not exceed the one second TTL of the tests.
+	buckets := 0
+	kvtest.RunConformance(t, func(t *testing.T, registry *typeregistry.Registry) keyvalue.KeyValuer {
+		buckets++
+		kv, err := keyvalue.NewJetStreamKVWithOptions(context.TODO(), embedded.JetStream(), &jetstream.KeyValueConfig{
+			Bucket:         fmt.Sprintf("conformance-%d", buckets),
+			History:        kvtest.HistorySize,
+			Storage:        jetstream.MemoryStorage,
+			LimitMarkerTTL: time.Second,
+		}, registry)
+		require.NoError(t, err)
+		return kv
+	})
+}
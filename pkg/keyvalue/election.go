@@ -0,0 +1,239 @@
+package keyvalue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// ErrElectionTTL is returned when the election bucket has no TTL : the leader key would never expire
+var ErrElectionTTL = errors.New("election bucket requires a TTL")
+
+// ElectionConfig configures a leader election
+type ElectionConfig struct {
+	Key           string        // Key holding the leader ID, shared by all the candidates
+	ID            string        // Identifier of this candidate, unique among the candidates
+	RenewInterval time.Duration // Delay between leadership renewals and campaigns (default bucket TTL / 3)
+	OnElected     func()        // Called when this candidate becomes the leader
+	OnRevoked     func()        // Called when this candidate loses the leadership
+}
+
+// Election elects a single leader among the candidates sharing a JetStreamKV bucket and a key.
+//
+// The leader is the candidate that created the key (create-if-absent). It renews its leadership with
+// revision-checked updates, and the bucket TTL expires the key when the leader stops renewing it,
+// so that another candidate can be elected. A leader that fails to renew considers its leadership
+// lost one TTL after its last successful renewal, before the key expires on the server.
+type Election struct {
+	kv      *JetStreamKV
+	config  ElectionConfig
+	ttl     time.Duration
+	changes chan bool
+
+	mu       sync.RWMutex
+	leader   bool
+	leaderID string    // last known leader
+	revision uint64    // revision of the leader key, when leader
+	renewed  time.Time // time the last successful renewal was sent, when leader
+
+	cancel     context.CancelFunc
+	done       chan struct{}
+	releaseErr error // error releasing the leadership, set when done is closed
+}
+
+// StartElection starts campaigning for the leadership, until ctx is cancelled or Stop is called,
+// the leadership is then released.
+// The bucket of kv must have a TTL (KeyValueConfig.TTL).
+func StartElection(ctx context.Context, kv *JetStreamKV, config ElectionConfig) (*Election, error) {
+	if kv == nil {
+		return nil, errors.New("key value store is required")
+	}
+	if config.Key == "" {
+		return nil, ErrEmptyKey
+	}
+	if config.ID == "" {
+		return nil, errors.New("candidate id is required")
+	}
+
+	status, err := kv.bucket.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bucket status: %w", err)
+	}
+	ttl := status.TTL()
+	if ttl <= 0 {
+		return nil, ErrElectionTTL
+	}
+	if config.RenewInterval <= 0 {
+		config.RenewInterval = ttl / 3
+	}
+	if config.RenewInterval >= ttl {
+		return nil, fmt.Errorf("renew interval %s must be shorter than the bucket TTL %s", config.RenewInterval, ttl)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	e := &Election{
+		kv:      kv,
+		config:  config,
+		ttl:     ttl,
+		changes: make(chan bool, 1),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go e.run(ctx)
+	return e, nil
+}
+
+// IsLeader reports whether this candidate is the leader
+func (e *Election) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader && time.Since(e.renewed) < e.ttl
+}
+
+// Leader returns the ID of the last known leader, or an empty string
+func (e *Election) Leader() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leaderID
+}
+
+// Changes returns a channel receiving the leadership of this candidate when it changes.
+// Only the latest change is kept if the channel is not read.
+func (e *Election) Changes() <-chan bool {
+	return e.changes
+}
+
+// Stop stops campaigning and releases the leadership, so that another candidate can be elected
+// without waiting for the key to expire
+func (e *Election) Stop() error {
+	e.cancel()
+	<-e.done
+	return e.releaseErr
+}
+
+// run campaigns every renew interval until ctx is cancelled
+func (e *Election) run(ctx context.Context) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.RenewInterval)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			e.releaseErr = e.release(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// release deletes the leader key if this candidate is the leader
+func (e *Election) release(ctx context.Context) error {
+	e.mu.RLock()
+	leader, revision := e.leader, e.revision
+	e.mu.RUnlock()
+	if !leader {
+		return nil
+	}
+	e.setLeader(false, "")
+
+	ctx, cancel := context.WithTimeout(ctx, e.config.RenewInterval)
+	defer cancel()
+	err := e.kv.bucket.Delete(ctx, e.config.Key, jetstream.LastRevision(revision))
+	if err != nil && !isWrongRevision(err) {
+		return fmt.Errorf("failed to release leadership: %w", err)
+	}
+	return nil
+}
+
+// campaign renews the leadership of the leader, or tries to take the leadership
+func (e *Election) campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.config.RenewInterval)
+	defer cancel()
+	key, id := e.config.Key, []byte(e.config.ID)
+	start := time.Now()
+
+	e.mu.RLock()
+	leader, revision := e.leader, e.revision
+	e.mu.RUnlock()
+
+	if leader {
+		revision, err := e.kv.bucket.Update(ctx, key, id, revision)
+		switch {
+		case err == nil:
+			e.renew(revision, start)
+		case isWrongRevision(err):
+			e.setLeader(false, "") // the key expired or was taken over
+		case !e.IsLeader():
+			e.setLeader(false, "") // lease expired while failing to renew
+		}
+		return
+	}
+
+	revision, err := e.kv.bucket.Create(ctx, key, id)
+	if err == nil {
+		e.renew(revision, start)
+		return
+	}
+	if !errors.Is(err, jetstream.ErrKeyExists) {
+		return
+	}
+
+	entry, err := e.kv.bucket.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	if string(entry.Value()) != e.config.ID {
+		e.setLeader(false, string(entry.Value()))
+		return
+	}
+	// The key was created by this candidate before a restart : resume the leadership
+	if revision, err = e.kv.bucket.Update(ctx, key, id, entry.Revision()); err == nil {
+		e.renew(revision, start)
+	}
+}
+
+// renew records a successful creation or renewal of the leader key
+func (e *Election) renew(revision uint64, start time.Time) {
+	e.mu.Lock()
+	e.revision = revision
+	e.renewed = start
+	e.mu.Unlock()
+	e.setLeader(true, e.config.ID)
+}
+
+// setLeader updates the leadership state, and notifies the changes
+func (e *Election) setLeader(leader bool, leaderID string) {
+	e.mu.Lock()
+	changed := e.leader != leader
+	e.leader = leader
+	e.leaderID = leaderID
+	e.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	select {
+	case <-e.changes: // drop the change not read yet
+	default:
+	}
+	e.changes <- leader
+
+	if leader && e.config.OnElected != nil {
+		e.config.OnElected()
+	}
+	if !leader && e.config.OnRevoked != nil {
+		e.config.OnRevoked()
+	}
+}
+
+// isWrongRevision reports whether err is a revision mismatch of a revision-checked operation
+func isWrongRevision(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
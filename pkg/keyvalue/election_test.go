@@ -0,0 +1,118 @@
+package keyvalue
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+func setupElectionKV(t *testing.T, ttl time.Duration) *JetStreamKV {
+	embedded, err := natstools.StartEmbedded()
+	require.NoError(t, err, "Failed to start embedded NATS")
+	t.Cleanup(func() { embedded.Shutdown() })
+
+	kv, err := NewJetStreamKVWithOptions(context.TODO(), embedded.JetStream(), &jetstream.KeyValueConfig{
+		Bucket:  "election",
+		TTL:     ttl,
+		Storage: jetstream.MemoryStorage,
+	}, nil)
+	require.NoError(t, err)
+	return kv
+}
+
+func TestElection_SingleLeader(t *testing.T) {
+	assert := assert.New(t)
+	kv := setupElectionKV(t, time.Second)
+	ctx := context.Background()
+
+	var elected, revoked atomic.Int32
+	e1, err := StartElection(ctx, kv, ElectionConfig{
+		Key:       "leader",
+		ID:        "a",
+		OnElected: func() { elected.Add(1) },
+		OnRevoked: func() { revoked.Add(1) },
+	})
+	require.NoError(t, err)
+	select {
+	case leader := <-e1.Changes():
+		assert.True(leader)
+	case <-time.After(time.Second):
+		t.Fatal("a not elected")
+	}
+	assert.True(e1.IsLeader())
+	assert.Equal("a", e1.Leader())
+
+	e2, err := StartElection(ctx, kv, ElectionConfig{Key: "leader", ID: "b", RenewInterval: 100 * time.Millisecond})
+	require.NoError(t, err)
+	defer e2.Stop()
+	assert.Eventually(func() bool { return e2.Leader() == "a" }, time.Second, 10*time.Millisecond)
+	assert.False(e2.IsLeader())
+
+	// Renewals keep the leadership after the TTL
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(e1.IsLeader())
+	assert.False(e2.IsLeader())
+
+	// Stopping releases the leadership
+	require.NoError(t, e1.Stop())
+	assert.False(e1.IsLeader())
+	assert.Equal(int32(1), elected.Load())
+	assert.Equal(int32(1), revoked.Load())
+	select {
+	case leader := <-e2.Changes():
+		assert.True(leader)
+	case <-time.After(time.Second):
+		t.Fatal("b not elected")
+	}
+	assert.Equal("b", e2.Leader())
+}
+
+func TestElection_Expiry(t *testing.T) {
+	kv := setupElectionKV(t, time.Second)
+	ctx := context.Background()
+
+	// A leader that stopped renewing
+	_, err := kv.bucket.Create(ctx, "leader", []byte("crashed"))
+	require.NoError(t, err)
+
+	e, err := StartElection(ctx, kv, ElectionConfig{Key: "leader", ID: "a", RenewInterval: 100 * time.Millisecond})
+	require.NoError(t, err)
+	defer e.Stop()
+	assert.Eventually(t, func() bool { return e.Leader() == "crashed" }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, e.IsLeader, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestElection_Resume(t *testing.T) {
+	kv := setupElectionKV(t, time.Minute)
+	ctx := context.Background()
+
+	// The key created before a restart is taken over by the same candidate
+	_, err := kv.bucket.Create(ctx, "leader", []byte("a"))
+	require.NoError(t, err)
+
+	e, err := StartElection(ctx, kv, ElectionConfig{Key: "leader", ID: "a"})
+	require.NoError(t, err)
+	defer e.Stop()
+	assert.Eventually(t, e.IsLeader, time.Second, 10*time.Millisecond)
+}
+
+func TestElection_InvalidConfig(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	kv := setupElectionKV(t, 0)
+	_, err := StartElection(ctx, kv, ElectionConfig{Key: "leader", ID: "a"})
+	assert.ErrorIs(err, ErrElectionTTL)
+	_, err = StartElection(ctx, kv, ElectionConfig{ID: "a"})
+	assert.ErrorIs(err, ErrEmptyKey)
+	_, err = StartElection(ctx, kv, ElectionConfig{Key: "leader"})
+	assert.Error(err)
+	_, err = StartElection(ctx, nil, ElectionConfig{Key: "leader", ID: "a"})
+	assert.Error(err)
+}
@@ -94,10 +94,9 @@ func Example_withTTL() {
 
 	fmt.Println("Key set with bucket TTL")
 
-	// Note: Per-key TTL via WithTTL() is NOT supported
-	// Attempting to use it will return an error:
+	// Per-key TTL via WithTTL() requires LimitMarkerTTL on the bucket (set by NewJetStreamKV):
 	// err = kv.Set(context.Background(), "key", []byte("value"), keyvalue.WithTTL(1*time.Second))
-	// This will return: "per-key TTL is not supported"
+	// On this bucket it returns: "per-key TTL is not enabled on the bucket"
 
 	// Output:
 	// Key set with bucket TTL
@@ -0,0 +1,75 @@
+package keyvalue
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// validKeyToken matches the tokens of a key, as for JetStream
+var validKeyToken = regexp.MustCompile(`^[-/_=a-zA-Z0-9]+$`)
+
+// validKey checks that key is not empty and is a valid key, it returns ErrEmptyKey or ErrInvalidKey
+func validKey(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	for _, token := range strings.Split(key, ".") {
+		if !validKeyToken.MatchString(token) {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// validDataKey checks that key is a valid key not reserved to the locks (see LockKeyPrefix)
+func validDataKey(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if isLockKey(key) {
+		return fmt.Errorf("%w: key %q is reserved to the locks", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// isLockKey reports whether key is the key of a lock
+func isLockKey(key string) bool {
+	return strings.HasPrefix(key, LockKeyPrefix)
+}
+
+// filterMatchesLocks reports whether filter may match the keys of the locks
+func filterMatchesLocks(filter string) bool {
+	first, _, _ := strings.Cut(filter, ".")
+	return first == "*" || first == ">" || first+"." == LockKeyPrefix
+}
+
+// validFilter checks that filter is a valid NATS subject, with "*" and ">" wildcards
+func validFilter(filter string) error {
+	tokens := strings.Split(filter, ".")
+	for i, token := range tokens {
+		if token == "" || strings.ContainsAny(token, " \t\r\n") ||
+			(token == ">" && i != len(tokens)-1) ||
+			(len(token) > 1 && strings.ContainsAny(token, "*>")) {
+			return fmt.Errorf("%w: invalid filter %q", ErrInvalidKey, filter)
+		}
+	}
+	return nil
+}
+
+// matchFilter reports whether key matches filter, a valid NATS subject with wildcards
+func matchFilter(filter, key string) bool {
+	filterTokens := strings.Split(filter, ".")
+	keyTokens := strings.Split(key, ".")
+	for i, token := range filterTokens {
+		switch {
+		case token == ">":
+			return len(keyTokens) > i
+		case i >= len(keyTokens):
+			return false
+		case token != "*" && token != keyTokens[i]:
+			return false
+		}
+	}
+	return len(filterTokens) == len(keyTokens)
+}
@@ -0,0 +1,40 @@
+package keyvalue
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		key    string
+		match  bool
+	}{
+		{"user.1", "user.1", true},
+		{"user.1", "user.2", false},
+		{"user.*", "user.1", true},
+		{"user.*", "user.1.name", false},
+		{"user.*", "user", false},
+		{"*.1", "user.1", true},
+		{"user.>", "user.1", true},
+		{"user.>", "user.1.name", true},
+		{"user.>", "user", false},
+		{">", "user", true},
+		{"*.*.name", "user.1.name", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.match, matchFilter(tt.filter, tt.key), "%s ~ %s", tt.filter, tt.key)
+	}
+}
+
+func TestValidFilter(t *testing.T) {
+	for _, filter := range []string{"user", "user.*", "user.>", "*.1", ">"} {
+		assert.NoError(t, validFilter(filter), filter)
+	}
+	for _, filter := range []string{"", ".user", "user.", "user..1", "user.>.1", "user*", "us>", "user 1"} {
+		assert.ErrorIs(t, validFilter(filter), jetstream.ErrInvalidKey, filter)
+	}
+}
@@ -4,11 +4,19 @@ import (
 	"context"
 	"errors"
 	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 var (
-	ErrKeyNotFound = errors.New("key not found")
-	ErrEmptyKey    = errors.New("empty is key")
+	ErrKeyNotFound      = errors.New("key not found")
+	ErrEmptyKey         = errors.New("empty is key")
+	ErrKeyExists        = errors.New("key already exists")
+	ErrRevisionMismatch = errors.New("revision mismatch")
+
+	// ErrInvalidKey is returned for a key that is not a valid NATS subject token list
+	// (letters, digits, "-", "/", "_" and "=", separated by dots), it is jetstream.ErrInvalidKey
+	ErrInvalidKey = jetstream.ErrInvalidKey
 )
 
 // SetOption is a functional option for Set operations
@@ -19,19 +27,24 @@ type setOptions struct {
 	ttl time.Duration
 }
 
-// WithTTL sets a TTL for the key
+// WithTTL sets a TTL for the key : the key is deleted once expired.
 //
-// IMPORTANT: Per-key TTL is NOT supported in our JetStream KV implementation.
-// Calling Set() with this option will return an error.
-//
-// For TTL support, use bucket-level TTL
+// JetStreamKV uses per-message TTLs, rounded up to the second, enabled on the buckets created by NewJetStreamKV
+// (KeyValueConfig.LimitMarkerTTL with NewJetStreamKVWithOptions, nats-server 2.11+). On buckets keeping a history,
+// the ttl can't be shorter than LimitMarkerTTL and the previous revisions of the key are dropped.
+// MemoryKV hides the expired keys and removes them with a background janitor.
 func WithTTL(ttl time.Duration) SetOption {
 	return func(opts *setOptions) {
 		opts.ttl = ttl
 	}
 }
 
-// KeyValuer defines basic key-value operations
+// KeyValuer defines basic key-value operations.
+//
+// Keys are dot-separated tokens of letters, digits, "-", "/", "_" and "=" (ErrEmptyKey, ErrInvalidKey otherwise).
+// Get returns ErrKeyNotFound for a missing key, and Delete of a missing key is not an error.
+// A nil value is read back as an empty value, which may or may not be nil.
+// kvtest.RunConformance checks an implementation against this contract.
 type KeyValuer interface {
 	Set(ctx context.Context, key string, value []byte, opts ...SetOption) error
 	Get(ctx context.Context, key string) ([]byte, error)
@@ -39,6 +52,56 @@ type KeyValuer interface {
 	Exists(ctx context.Context, key string) (bool, error)
 }
 
+// Entry is a value with its revision
+type Entry struct {
+	Key      string
+	Value    []byte
+	Revision uint64    // Revision of the value, increasing with each write to the store
+	Created  time.Time // Time the value was written
+}
+
+// RevisionedKeyValuer defines revision-aware key-value operations, used for optimistic concurrency :
+// read an entry, then update it with its revision, ErrRevisionMismatch reports a concurrent write.
+type RevisionedKeyValuer interface {
+	KeyValuer
+	// Create stores a key-value pair if the key does not exist, it returns ErrKeyExists otherwise
+	Create(ctx context.Context, key string, value []byte) (uint64, error)
+	// Update stores a key-value pair if the revision of the key is expectedRevision,
+	// it returns ErrRevisionMismatch otherwise
+	Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error)
+	// GetEntry retrieves the value of a key with its revision
+	GetEntry(ctx context.Context, key string) (*Entry, error)
+}
+
+// Lister lists the keys of a store
+type Lister interface {
+	// Keys returns all keys
+	Keys(ctx context.Context) ([]string, error)
+	// ListKeys returns the keys matching filter, a NATS subject with wildcards :
+	// "*" matches a single dot-separated token, ">" matches one or more trailing tokens
+	ListKeys(ctx context.Context, filter string) ([]string, error)
+}
+
+// Watcher watches the changes of the keys of a store.
+//
+// A watcher first receives the last entry of each key matching the filter, then a nil entry,
+// then the changes until it is stopped or ctx is done. Deleted and expired keys are received
+// as KeyValuePurge entries. The Updates channel is closed when the watcher stops.
+type Watcher interface {
+	// Watch watches the keys matching filter (see Lister.ListKeys)
+	Watch(ctx context.Context, filter string) (jetstream.KeyWatcher, error)
+	// WatchAll watches all keys, ignoring deletions
+	WatchAll(ctx context.Context) (jetstream.KeyWatcher, error)
+}
+
+// Historian retrieves the previous values of a key, as many as the history size of the store
+type Historian interface {
+	// History returns the entries of key from the oldest, including the deletion markers
+	History(ctx context.Context, key string) ([]jetstream.KeyValueEntry, error)
+	// GetRevision returns the value of key at revision, ErrKeyNotFound if not kept
+	GetRevision(ctx context.Context, key string, revision uint64) ([]byte, error)
+}
+
 // TypedKeyValuer defines typed key-value operations
 type TypedKeyValuer interface {
 	SetTyped(ctx context.Context, key string, value interface{}, opts ...SetOption) error
@@ -5,28 +5,59 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/telemac/natsservice/pkg/typeregistry"
 )
 
-// JetStreamKV implements KeyValuer and TypedKeyValuer using NATS JetStream
+// JetStreamKV implements KeyValuer, RevisionedKeyValuer, TypedKeyValuer and Locker using NATS JetStream
 type JetStreamKV struct {
 	bucket   jetstream.KeyValue
+	js       jetstream.JetStream
 	registry *typeregistry.Registry
+
+	putPrefix string        // prefix of the subjects the values are published to, see putPrefix
+	markerTTL time.Duration // KeyValueConfig.LimitMarkerTTL of the bucket, per-key TTL is disabled if zero
+	history   uint8         // KeyValueConfig.History of the bucket
 }
 
-// NewJetStreamKV creates a new JetStream-backed key-value store with default configuration
+// DefaultLimitMarkerTTL is the KeyValueConfig.LimitMarkerTTL set by NewJetStreamKV, enabling the per-key TTL :
+// the markers left by expired keys are kept for this duration. It requires nats-server 2.11+.
+//
+// On buckets keeping a history (KeyValueConfig.History > 1), the server raises the per-key TTLs shorter than
+// LimitMarkerTTL to it : WithTTL rejects them.
+const DefaultLimitMarkerTTL = time.Minute
+
+// Ensure JetStreamKV implements all the store interfaces
+var _ KeyValuer = (*JetStreamKV)(nil)
+var _ RevisionedKeyValuer = (*JetStreamKV)(nil)
+var _ TypedKeyValuer = (*JetStreamKV)(nil)
+var _ Locker = (*JetStreamKV)(nil)
+var _ Lister = (*JetStreamKV)(nil)
+var _ Watcher = (*JetStreamKV)(nil)
+var _ Historian = (*JetStreamKV)(nil)
+
+// NewJetStreamKV creates a new JetStream-backed key-value store with default configuration,
+// with per-key TTL enabled (DefaultLimitMarkerTTL).
+//
+// Breaking change: the bucket is created or updated with LimitMarkerTTL, which requires nats-server 2.11+
+// and can't be disabled afterwards. Use NewJetStreamKVWithOptions to keep an existing bucket unchanged.
 func NewJetStreamKV(ctx context.Context, js jetstream.JetStream, bucketName, description string, registry *typeregistry.Registry) (*JetStreamKV, error) {
 	// Use NewJetStreamKVWithOptions with default configuration
 	cfg := &jetstream.KeyValueConfig{
-		Bucket:      bucketName,
-		Description: description,
+		Bucket:         bucketName,
+		Description:    description,
+		LimitMarkerTTL: DefaultLimitMarkerTTL,
 	}
 	return NewJetStreamKVWithOptions(ctx, js, cfg, registry)
 }
 
-// NewJetStreamKVWithOptions creates a new JetStream KV store with custom configuration
+// NewJetStreamKVWithOptions creates a new JetStream KV store with custom configuration.
+// Per-key TTL (WithTTL) requires cfg.LimitMarkerTTL to be set, e.g. to DefaultLimitMarkerTTL, and nats-server 2.11+.
 func NewJetStreamKVWithOptions(ctx context.Context, js jetstream.JetStream, cfg *jetstream.KeyValueConfig, registry *typeregistry.Registry) (*JetStreamKV, error) {
 	if js == nil {
 		return nil, errors.New("jetstream instance is required")
@@ -44,17 +75,43 @@ func NewJetStreamKVWithOptions(ctx context.Context, js jetstream.JetStream, cfg
 	}
 
 	return &JetStreamKV{
-		bucket:   bucket,
-		registry: registry,
+		bucket:    bucket,
+		js:        js,
+		registry:  registry,
+		putPrefix: putPrefix(js, cfg),
+		markerTTL: cfg.LimitMarkerTTL,
+		history:   max(cfg.History, 1),
 	}, nil
 }
 
+// putPrefix returns the prefix of the subjects the values of the bucket of cfg are published to, built as
+// the bucket Put does : the subjects of the mirrored bucket, prefixed with the JetStream domain or API prefix.
+func putPrefix(js jetstream.JetStream, cfg *jetstream.KeyValueConfig) string {
+	bucket := cfg.Bucket
+	if mirror := cfg.Mirror; mirror != nil {
+		bucket = strings.TrimPrefix(mirror.Name, "KV_")
+		if mirror.External != nil && mirror.External.APIPrefix != "" {
+			return mirror.External.APIPrefix + ".$KV." + bucket + "."
+		}
+	}
+	prefix := "$KV." + bucket + "."
+
+	opts := js.Options()
+	switch {
+	case opts.Domain != "":
+		return "$JS." + opts.Domain + ".API." + prefix
+	case opts.APIPrefix != "" && opts.APIPrefix != jetstream.DefaultAPIPrefix:
+		return strings.TrimSuffix(opts.APIPrefix, ".") + "." + prefix
+	}
+	return prefix
+}
+
 // --- KeyValuer Implementation ---
 
 // Set stores a key-value pair
 func (kv *JetStreamKV) Set(ctx context.Context, key string, value []byte, opts ...SetOption) error {
-	if key == "" {
-		return ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return err
 	}
 
 	options := &setOptions{}
@@ -62,13 +119,12 @@ func (kv *JetStreamKV) Set(ctx context.Context, key string, value []byte, opts .
 		opt(options)
 	}
 
-	// Per-key TTL is not supported in NATS JetStream KV
-	// Use bucket-level TTL via WithDefaultTTL when creating the bucket
+	var err error
 	if options.ttl > 0 {
-		return fmt.Errorf("per-key TTL is not supported; use bucket-level TTL via WithDefaultTTL when creating the KV store")
+		err = kv.putWithTTL(ctx, key, value, options.ttl)
+	} else {
+		_, err = kv.bucket.Put(ctx, key, value)
 	}
-
-	_, err := kv.bucket.Put(ctx, key, value)
 	if err != nil {
 		return fmt.Errorf("failed to set key %s: %w", key, err)
 	}
@@ -76,10 +132,32 @@ func (kv *JetStreamKV) Set(ctx context.Context, key string, value []byte, opts .
 	return nil
 }
 
+// putWithTTL writes the value of key with a per-message TTL, rounded up to the second.
+// The value is published to the bucket subject with a Nats-TTL header, replacing the previous value
+// in a single write as Put does. On buckets keeping a history, the write rolls up the previous revisions
+// of the key, which would be visible again once the value expires.
+func (kv *JetStreamKV) putWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if kv.markerTTL <= 0 {
+		return errors.New("per-key TTL is not enabled on the bucket; set LimitMarkerTTL when creating the KV store")
+	}
+	if r := ttl % time.Second; r != 0 {
+		ttl += time.Second - r
+	}
+	if kv.history > 1 && ttl < kv.markerTTL {
+		return fmt.Errorf("ttl %s is shorter than the LimitMarkerTTL %s of the bucket, the minimum of buckets keeping a history", ttl, kv.markerTTL)
+	}
+	msg := &nats.Msg{Subject: kv.putPrefix + key, Header: nats.Header{}, Data: value}
+	if kv.history > 1 {
+		msg.Header.Set(jetstream.MsgRollup, jetstream.MsgRollupSubject)
+	}
+	_, err := kv.js.PublishMsg(ctx, msg, jetstream.WithMsgTTL(ttl))
+	return err
+}
+
 // Get retrieves a value by key
 func (kv *JetStreamKV) Get(ctx context.Context, key string) ([]byte, error) {
-	if key == "" {
-		return nil, ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return nil, err
 	}
 
 	entry, err := kv.bucket.Get(ctx, key)
@@ -96,8 +174,8 @@ func (kv *JetStreamKV) Get(ctx context.Context, key string) ([]byte, error) {
 // Delete removes a key from the store
 // WARNING : if the key does not exist, Delete won't return an error with jetstream kv
 func (kv *JetStreamKV) Delete(ctx context.Context, key string) error {
-	if key == "" {
-		return ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return err
 	}
 
 	err := kv.bucket.Purge(ctx, key)
@@ -110,8 +188,8 @@ func (kv *JetStreamKV) Delete(ctx context.Context, key string) error {
 
 // Exists checks if a key exists without retrieving its value
 func (kv *JetStreamKV) Exists(ctx context.Context, key string) (bool, error) {
-	if key == "" {
-		return false, ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return false, err
 	}
 
 	_, err := kv.bucket.Get(ctx, key)
@@ -125,6 +203,64 @@ func (kv *JetStreamKV) Exists(ctx context.Context, key string) (bool, error) {
 	return true, nil
 }
 
+// --- RevisionedKeyValuer Implementation ---
+
+// Create stores a key-value pair if the key does not exist (or was deleted), it returns the revision of the value
+func (kv *JetStreamKV) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	if err := validDataKey(key); err != nil {
+		return 0, err
+	}
+
+	revision, err := kv.bucket.Create(ctx, key, value)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return 0, ErrKeyExists
+		}
+		return 0, fmt.Errorf("failed to create key %s: %w", key, err)
+	}
+
+	return revision, nil
+}
+
+// Update stores a key-value pair if the revision of the key is expectedRevision, it returns the new revision
+func (kv *JetStreamKV) Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error) {
+	if err := validDataKey(key); err != nil {
+		return 0, err
+	}
+
+	revision, err := kv.bucket.Update(ctx, key, value, expectedRevision)
+	if err != nil {
+		if isWrongRevision(err) {
+			return 0, ErrRevisionMismatch
+		}
+		return 0, fmt.Errorf("failed to update key %s: %w", key, err)
+	}
+
+	return revision, nil
+}
+
+// GetEntry retrieves the value of a key with its revision
+func (kv *JetStreamKV) GetEntry(ctx context.Context, key string) (*Entry, error) {
+	if err := validDataKey(key); err != nil {
+		return nil, err
+	}
+
+	entry, err := kv.bucket.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get key %s: %w", key, err)
+	}
+
+	return &Entry{
+		Key:      entry.Key(),
+		Value:    entry.Value(),
+		Revision: entry.Revision(),
+		Created:  entry.Created(),
+	}, nil
+}
+
 // --- TypedKeyValuer Implementation ---
 
 // SetTyped stores a typed value with automatic marshaling
@@ -181,6 +317,91 @@ func (kv *JetStreamKV) DeleteTyped(ctx context.Context, key string) error {
 	return kv.Delete(ctx, key)
 }
 
+// --- Locker Implementation ---
+
+// Lock acquires the lock name, waiting until it is released or expires, or ctx is done.
+// The lock is stored in the LockKeyPrefix+name key, the fencing token is the revision of its acquisition.
+func (kv *JetStreamKV) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return lock(ctx, kv, name, ttl, true)
+}
+
+// TryLock acquires the lock name, it returns ErrLocked if the lock is held
+func (kv *JetStreamKV) TryLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return lock(ctx, kv, name, ttl, false)
+}
+
+// Unlock stops the renewal and releases the lock
+func (kv *JetStreamKV) Unlock(ctx context.Context, lock *Lock) error {
+	return lock.unlock(ctx)
+}
+
+// Refresh extends the lock for its ttl
+func (kv *JetStreamKV) Refresh(ctx context.Context, lock *Lock) error {
+	return lock.refresh(ctx)
+}
+
+func (kv *JetStreamKV) acquireLock(ctx context.Context, name string, expires time.Time) (uint64, uint64, error) {
+	value, err := json.Marshal(lockRecord{Expires: expires})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var revision uint64
+	entry, err := kv.bucket.Get(ctx, lockKey(name))
+	switch {
+	case errors.Is(err, jetstream.ErrKeyNotFound):
+		revision, err = kv.bucket.Create(ctx, lockKey(name), value)
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return 0, 0, ErrLocked
+		}
+	case err != nil:
+		return 0, 0, fmt.Errorf("failed to get lock %s: %w", name, err)
+	default:
+		var record lockRecord
+		if err := json.Unmarshal(entry.Value(), &record); err != nil {
+			return 0, 0, fmt.Errorf("invalid lock %s: %w", name, err)
+		}
+		if time.Now().Before(record.Expires) {
+			return 0, 0, ErrLocked
+		}
+		// The lock expired : take it over
+		revision, err = kv.bucket.Update(ctx, lockKey(name), value, entry.Revision())
+		if isWrongRevision(err) {
+			return 0, 0, ErrLocked
+		}
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+	return revision, revision, nil
+}
+
+func (kv *JetStreamKV) refreshLock(ctx context.Context, name string, revision uint64, expires time.Time) (uint64, error) {
+	value, err := json.Marshal(lockRecord{Expires: expires})
+	if err != nil {
+		return 0, err
+	}
+	revision, err = kv.bucket.Update(ctx, lockKey(name), value, revision)
+	if isWrongRevision(err) {
+		return 0, ErrLockLost
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh lock %s: %w", name, err)
+	}
+	return revision, nil
+}
+
+func (kv *JetStreamKV) releaseLock(ctx context.Context, name string, revision uint64) error {
+	err := kv.bucket.Delete(ctx, lockKey(name), jetstream.LastRevision(revision))
+	if isWrongRevision(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", name, err)
+	}
+	return nil
+}
+
 // --- Additional Helper Methods ---
 
 // Keys returns all keys
@@ -193,31 +414,113 @@ func (kv *JetStreamKV) Keys(ctx context.Context) ([]string, error) {
 
 	var keys []string
 	for key := range keyLister.Keys() {
-		keys = append(keys, key)
+		if !isLockKey(key) {
+			keys = append(keys, key)
+		}
 	}
 
 	return keys, nil
 }
 
-// Watch watches for changes to a key
-func (kv *JetStreamKV) Watch(ctx context.Context, key string) (jetstream.KeyWatcher, error) {
-	return kv.bucket.Watch(ctx, key)
+// ListKeys returns the keys matching filter, which may contain "*" and ">" wildcards
+func (kv *JetStreamKV) ListKeys(ctx context.Context, filter string) ([]string, error) {
+	keyLister, err := kv.bucket.ListKeysFiltered(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys %s: %w", filter, err)
+	}
+	defer keyLister.Stop()
+
+	var keys []string
+	for key := range keyLister.Keys() {
+		if !isLockKey(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// Watch watches for changes to the keys matching filter, which may contain "*" and ">" wildcards
+func (kv *JetStreamKV) Watch(ctx context.Context, filter string) (jetstream.KeyWatcher, error) {
+	return kv.WatchFiltered(ctx, []string{filter})
 }
 
-// WatchAll watches for changes to all keys with optional prefix
+// WatchAll watches for changes to all keys, ignoring deletions
 func (kv *JetStreamKV) WatchAll(ctx context.Context) (jetstream.KeyWatcher, error) {
-	return kv.bucket.WatchAll(ctx, jetstream.IgnoreDeletes())
+	return kv.WatchFiltered(ctx, []string{">"}, jetstream.IgnoreDeletes())
 }
 
 // WatchFiltered watches multiple keys for changes based on specified filters and options. Returns a KeyWatcher or an error.
+// The changes of the locks are not reported.
 func (kv *JetStreamKV) WatchFiltered(ctx context.Context, keys []string, opts ...jetstream.WatchOpt) (jetstream.KeyWatcher, error) {
-	return kv.bucket.WatchFiltered(ctx, keys, opts...)
+	hideLocks := false
+	for _, key := range keys {
+		if filterMatchesLocks(key) {
+			hideLocks = true
+			break
+		}
+	}
+	// The bucket rewrites the filters in place into subjects, keep the keys of the caller
+	watcher, err := kv.bucket.WatchFiltered(ctx, append([]string(nil), keys...), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if hideLocks {
+		return newDataWatcher(watcher), nil
+	}
+	return watcher, nil
+}
+
+// dataWatcher forwards the entries of a bucket watcher, skipping the entries of the locks
+type dataWatcher struct {
+	jetstream.KeyWatcher
+	updates  chan jetstream.KeyValueEntry
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newDataWatcher(watcher jetstream.KeyWatcher) *dataWatcher {
+	w := &dataWatcher{
+		KeyWatcher: watcher,
+		updates:    make(chan jetstream.KeyValueEntry, 256),
+		stop:       make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// run forwards the entries until the bucket watcher closes its updates channel or the watcher is stopped
+func (w *dataWatcher) run() {
+	defer close(w.updates)
+	for entry := range w.KeyWatcher.Updates() {
+		if entry != nil && isLockKey(entry.Key()) {
+			continue
+		}
+		select {
+		case w.updates <- entry:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Updates returns the channel of the watched entries, closed when the watcher stops
+func (w *dataWatcher) Updates() <-chan jetstream.KeyValueEntry {
+	return w.updates
+}
+
+// Stop stops the watcher
+func (w *dataWatcher) Stop() error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	return w.KeyWatcher.Stop()
 }
 
 // Purge deletes all versions of a key
 func (kv *JetStreamKV) Purge(ctx context.Context, key string) error {
-	if key == "" {
-		return ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return err
 	}
 
 	err := kv.bucket.Purge(ctx, key)
@@ -235,8 +538,8 @@ func (kv *JetStreamKV) Status(ctx context.Context) (jetstream.KeyValueStatus, er
 
 // GetRevision gets a specific revision of a key
 func (kv *JetStreamKV) GetRevision(ctx context.Context, key string, revision uint64) ([]byte, error) {
-	if key == "" {
-		return nil, ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return nil, err
 	}
 
 	entry, err := kv.bucket.GetRevision(ctx, key, revision)
@@ -252,12 +555,15 @@ func (kv *JetStreamKV) GetRevision(ctx context.Context, key string, revision uin
 
 // History returns the history of values for a key
 func (kv *JetStreamKV) History(ctx context.Context, key string) ([]jetstream.KeyValueEntry, error) {
-	if key == "" {
-		return nil, ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return nil, err
 	}
 
 	entries, err := kv.bucket.History(ctx, key)
 	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, ErrKeyNotFound
+		}
 		return nil, fmt.Errorf("failed to get key history %s: %w", key, err)
 	}
 
@@ -2,6 +2,7 @@ package keyvalue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -353,6 +354,116 @@ func TestKeyValuer_Purge(t *testing.T) {
 	assert.ErrorIs(err, ErrKeyNotFound)
 }
 
+func TestKeyValuer_TTL(t *testing.T) {
+	assert := assert.New(t)
+	kv, cleanup := setupTestKV(t, true)
+	defer cleanup()
+	ctx := context.Background()
+
+	// NewJetStreamKV enables the per-key TTL
+	err := kv.Set(ctx, "session", []byte("token"), WithTTL(time.Second))
+	assert.NoError(err)
+	// Existing keys are replaced with the TTL
+	err = kv.Set(ctx, "replaced", []byte("old"))
+	assert.NoError(err)
+	err = kv.Set(ctx, "replaced", []byte("new"), WithTTL(time.Second))
+	assert.NoError(err)
+	value, err := kv.Get(ctx, "replaced")
+	assert.NoError(err)
+	assert.Equal([]byte("new"), value)
+	// A write with a TTL is a single put
+	history, err := kv.History(ctx, "replaced")
+	assert.NoError(err)
+	if assert.NotEmpty(history) {
+		assert.Equal(jetstream.KeyValuePut, history[len(history)-1].Operation())
+	}
+	err = kv.Set(ctx, "invalid key", []byte("token"), WithTTL(time.Second))
+	assert.ErrorIs(err, ErrInvalidKey)
+	err = kv.Set(ctx, "invalid..key", []byte("token"), WithTTL(time.Second))
+	assert.ErrorIs(err, ErrInvalidKey)
+	err = kv.SetTyped(ctx, "user", TestUser{ID: "1"}, WithTTL(time.Second))
+	assert.NoError(err)
+	err = kv.Set(ctx, "persistent", []byte("value"))
+	assert.NoError(err)
+
+	value, err = kv.Get(ctx, "session")
+	assert.NoError(err)
+	assert.Equal([]byte("token"), value)
+
+	// Keys expire after their TTL
+	assert.Eventually(func() bool {
+		exists, err := kv.Exists(ctx, "session")
+		if err != nil || exists {
+			return false
+		}
+		if exists, err := kv.Exists(ctx, "replaced"); err != nil || exists {
+			return false
+		}
+		_, err = kv.GetTyped(ctx, "user")
+		return errors.Is(err, ErrKeyNotFound)
+	}, 5*time.Second, 50*time.Millisecond)
+	exists, err := kv.Exists(ctx, "persistent")
+	assert.NoError(err)
+	assert.True(exists)
+
+	// Per-key TTL must be enabled on the bucket
+	noTTL, err := NewJetStreamKVWithOptions(ctx, kv.js, &jetstream.KeyValueConfig{Bucket: "no-ttl-bucket"}, nil)
+	require.NoError(t, err)
+	err = noTTL.Set(ctx, "session", []byte("token"), WithTTL(time.Second))
+	assert.ErrorContains(err, "per-key TTL is not enabled")
+
+	// Buckets keeping a history reject the TTLs the server would raise to their LimitMarkerTTL
+	historyKV, err := NewJetStreamKVWithOptions(ctx, kv.js, &jetstream.KeyValueConfig{
+		Bucket:         "history-ttl-bucket",
+		History:        3,
+		LimitMarkerTTL: 2 * time.Second,
+	}, nil)
+	require.NoError(t, err)
+	err = historyKV.Set(ctx, "session", []byte("token"), WithTTL(time.Second))
+	assert.ErrorContains(err, "shorter than the LimitMarkerTTL")
+	// The previous revisions are dropped, not visible again once the value expires
+	err = historyKV.Set(ctx, "session", []byte("old"))
+	assert.NoError(err)
+	err = historyKV.Set(ctx, "session", []byte("token"), WithTTL(2*time.Second))
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		exists, err := historyKV.Exists(ctx, "session")
+		return err == nil && !exists
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPutPrefix(t *testing.T) {
+	kv, cleanup := setupTestKV(t, false)
+	defer cleanup()
+	nc := kv.js.Conn()
+
+	domainJS, err := jetstream.NewWithDomain(nc, "hub")
+	require.NoError(t, err)
+	prefixJS, err := jetstream.NewWithAPIPrefix(nc, "$JS.leaf.API")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		js   jetstream.JetStream
+		cfg  jetstream.KeyValueConfig
+		want string
+	}{
+		{"default", kv.js, jetstream.KeyValueConfig{Bucket: "b"}, "$KV.b."},
+		{"domain", domainJS, jetstream.KeyValueConfig{Bucket: "b"}, "$JS.hub.API.$KV.b."},
+		{"api prefix", prefixJS, jetstream.KeyValueConfig{Bucket: "b"}, "$JS.leaf.API.$KV.b."},
+		{"mirror", kv.js, jetstream.KeyValueConfig{Bucket: "b", Mirror: &jetstream.StreamSource{Name: "KV_origin"}}, "$KV.origin."},
+		{"external mirror", kv.js, jetstream.KeyValueConfig{Bucket: "b", Mirror: &jetstream.StreamSource{
+			Name:     "origin",
+			External: &jetstream.ExternalStream{APIPrefix: "$JS.hub.API"},
+		}}, "$JS.hub.API.$KV.origin."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, putPrefix(tt.js, &tt.cfg))
+		})
+	}
+}
+
 func TestKeyValuer_Status(t *testing.T) {
 	assert := assert.New(t)
 	kv, cleanup := setupTestKV(t, false)
@@ -459,6 +570,7 @@ func TestKeyValuer_WatchFiltered(t *testing.T) {
 	watcher, err := kv.WatchFiltered(context.Background(), keysToWatch, jetstream.UpdatesOnly())
 	require.NoError(t, err, "Failed to create filtered watcher")
 	defer watcher.Stop()
+	assert.Equal([]string{"watch1", "watch2", "watch3"}, keysToWatch, "the filters of the caller are kept")
 
 	// Channel to collect updates
 	updates := make(chan jetstream.KeyValueEntry, 10)
@@ -0,0 +1,133 @@
+package keyvalue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// memoryEntry is an entry of the history of a MemoryKV key, it is immutable
+type memoryEntry struct {
+	key      string
+	value    []byte
+	revision uint64
+	created  time.Time
+	op       jetstream.KeyValueOp
+}
+
+// Ensure memoryEntry implements jetstream.KeyValueEntry
+var _ jetstream.KeyValueEntry = (*memoryEntry)(nil)
+
+func (e *memoryEntry) Bucket() string { return "" }
+func (e *memoryEntry) Key() string    { return e.key }
+
+// Value returns a copy of the value, to prevent modifications of the history
+func (e *memoryEntry) Value() []byte {
+	if e.value == nil {
+		return nil
+	}
+	value := make([]byte, len(e.value))
+	copy(value, e.value)
+	return value
+}
+
+func (e *memoryEntry) Revision() uint64                { return e.revision }
+func (e *memoryEntry) Created() time.Time              { return e.created }
+func (e *memoryEntry) Delta() uint64                   { return 0 }
+func (e *memoryEntry) Operation() jetstream.KeyValueOp { return e.op }
+
+// memoryWatcher delivers the changes of the keys of a MemoryKV.
+// Entries are queued without blocking the store, and forwarded to Updates by run.
+type memoryWatcher struct {
+	kv            *MemoryKV
+	filter        string
+	ignoreDeletes bool
+	updates       chan jetstream.KeyValueEntry
+
+	mu       sync.Mutex
+	queue    []jetstream.KeyValueEntry
+	signal   chan struct{} // signals a queued entry
+	done     chan struct{} // closed by Stop
+	stopOnce sync.Once
+}
+
+// Ensure memoryWatcher implements jetstream.KeyWatcher
+var _ jetstream.KeyWatcher = (*memoryWatcher)(nil)
+
+func newMemoryWatcher(kv *MemoryKV, filter string, ignoreDeletes bool, initial []jetstream.KeyValueEntry) *memoryWatcher {
+	return &memoryWatcher{
+		kv:            kv,
+		filter:        filter,
+		ignoreDeletes: ignoreDeletes,
+		updates:       make(chan jetstream.KeyValueEntry),
+		queue:         initial,
+		signal:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// Updates returns the channel receiving the entries, closed when the watcher stops
+func (w *memoryWatcher) Updates() <-chan jetstream.KeyValueEntry {
+	return w.updates
+}
+
+// Stop stops the watcher
+func (w *memoryWatcher) Stop() error {
+	w.stopOnce.Do(func() {
+		w.kv.mu.Lock()
+		delete(w.kv.watchers, w)
+		w.kv.mu.Unlock()
+		close(w.done)
+	})
+	return nil
+}
+
+// push queues entry if it matches the watcher, w.kv.mu must be locked
+func (w *memoryWatcher) push(entry *memoryEntry) {
+	if !matchFilter(w.filter, entry.key) || (w.ignoreDeletes && entry.op != jetstream.KeyValuePut) {
+		return
+	}
+
+	w.mu.Lock()
+	w.queue = append(w.queue, entry)
+	w.mu.Unlock()
+
+	select {
+	case w.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run forwards the queued entries to Updates until the watcher is stopped or ctx is done
+func (w *memoryWatcher) run(ctx context.Context) {
+	defer close(w.updates)
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			select {
+			case <-w.signal:
+				continue
+			case <-w.done:
+				return
+			case <-ctx.Done():
+				w.Stop()
+				return
+			}
+		}
+		entry := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		select {
+		case w.updates <- entry:
+		case <-w.done:
+			return
+		case <-ctx.Done():
+			w.Stop()
+			return
+		}
+	}
+}
@@ -4,55 +4,103 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/telemac/natsservice/pkg/typeregistry"
 )
 
-// MemoryKV implements a thread-safe in-memory key-value store
+// memoryJanitorInterval is the delay between two removals of the expired keys
+var memoryJanitorInterval = time.Second
+
+// maxMemoryHistory is the maximum history size of a MemoryKV, as for a JetStream bucket
+const maxMemoryHistory = 64
+
+// MemoryKV implements a thread-safe in-memory key-value store.
+// Keys set with WithTTL are hidden once expired, and removed by a background janitor
+// running while the store has keys with a TTL.
+// As with a JetStream bucket, deleted and expired keys leave a purge marker, seen by the watchers
+// and in the history.
 type MemoryKV struct {
 	mu       sync.RWMutex
 	data     map[string][]byte
 	registry *typeregistry.Registry
+
+	history     map[string][]*memoryEntry   // entries by key from the oldest, the last one is the current value or purge marker
+	historySize int                         // number of entries kept by key
+	watchers    map[*memoryWatcher]struct{} // active watchers
+	locks       map[string]memoryLock       // locks by key (LockKeyPrefix+name), separate from the data
+	revision    uint64                      // last revision of the store, incremented by each write of a value or lock
+	expiries    map[string]time.Time        // expiration time of the keys set with a TTL
+	janitor     bool                        // the janitor removing the expired keys is running
+}
+
+// memoryLock is a lock held in a MemoryKV
+type memoryLock struct {
+	revision uint64
+	expires  time.Time
 }
 
-// Ensure MemoryKV implements KeyValuer and TypedKeyValuer
+// Ensure MemoryKV implements all the store interfaces
 var _ KeyValuer = (*MemoryKV)(nil)
+var _ RevisionedKeyValuer = (*MemoryKV)(nil)
 var _ TypedKeyValuer = (*MemoryKV)(nil)
+var _ Locker = (*MemoryKV)(nil)
+var _ Lister = (*MemoryKV)(nil)
+var _ Watcher = (*MemoryKV)(nil)
+var _ Historian = (*MemoryKV)(nil)
 
 // NewMemoryKV creates a new in-memory key-value store
 func NewMemoryKV() *MemoryKV {
 	return &MemoryKV{
-		data: make(map[string][]byte),
+		data:        make(map[string][]byte),
+		history:     make(map[string][]*memoryEntry),
+		historySize: 1,
+		watchers:    make(map[*memoryWatcher]struct{}),
+		locks:       make(map[string]memoryLock),
 	}
 }
 
 // NewMemoryKVWithOptions creates a new in-memory key-value store with options
 func NewMemoryKVWithOptions(registry *typeregistry.Registry) *MemoryKV {
-	return &MemoryKV{
-		data:     make(map[string][]byte),
-		registry: registry,
-	}
+	m := NewMemoryKV()
+	m.registry = registry
+	return m
+}
+
+// WithHistory sets the number of entries kept by key (1 by default, at most 64), and returns the store
+func (m *MemoryKV) WithHistory(size int) *MemoryKV {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.historySize = min(max(size, 1), maxMemoryHistory)
+	return m
 }
 
 // Set stores a key-value pair
 func (m *MemoryKV) Set(ctx context.Context, key string, value []byte, opts ...SetOption) error {
-	if key == "" {
-		return ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return err
 	}
 
-	// Process options (TTL is not supported in memory implementation)
 	options := &setOptions{}
 	for _, opt := range opts {
 		opt(options)
 	}
-	if options.ttl > 0 {
-		return fmt.Errorf("TTL is not supported in memory implementation")
-	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.put(key, value, options.ttl)
+	return nil
+}
+
+// put stores a copy of value with a new revision, expiring after ttl if not 0, m.mu must be locked
+func (m *MemoryKV) put(key string, value []byte, ttl time.Duration) uint64 {
+	m.expire(key)
+
 	// Handle nil value specially
 	if value == nil {
 		m.data[key] = nil
@@ -62,20 +110,121 @@ func (m *MemoryKV) Set(ctx context.Context, key string, value []byte, opts ...Se
 		copy(valueCopy, value)
 		m.data[key] = valueCopy
 	}
+	m.record(key, m.data[key], jetstream.KeyValuePut)
 
-	return nil
+	if ttl <= 0 {
+		delete(m.expiries, key)
+		return m.revision
+	}
+	if m.expiries == nil {
+		m.expiries = make(map[string]time.Time)
+	}
+	m.expiries[key] = time.Now().Add(ttl)
+	if !m.janitor {
+		m.janitor = true
+		go m.runJanitor()
+	}
+	return m.revision
+}
+
+// lookup returns the value of key if it exists and is not expired, m.mu must be locked
+func (m *MemoryKV) lookup(key string) ([]byte, bool) {
+	value, exists := m.data[key]
+	if !exists {
+		return nil, false
+	}
+	if expires, ok := m.expiries[key]; ok && !time.Now().Before(expires) {
+		return nil, false
+	}
+	return value, true
+}
+
+// remove deletes key and replaces its history with a purge marker, m.mu must be locked
+func (m *MemoryKV) remove(key string) {
+	delete(m.data, key)
+	delete(m.expiries, key)
+	m.record(key, nil, jetstream.KeyValuePurge)
+}
+
+// expire removes key if it is expired and not yet removed by the janitor, m.mu must be locked
+func (m *MemoryKV) expire(key string) {
+	if _, stored := m.data[key]; stored {
+		if _, exists := m.lookup(key); !exists {
+			m.remove(key)
+		}
+	}
+}
+
+// record appends an entry with a new revision to the history of key and notifies the watchers, m.mu must be locked
+func (m *MemoryKV) record(key string, value []byte, op jetstream.KeyValueOp) uint64 {
+	m.revision++
+	entry := &memoryEntry{
+		key:      key,
+		value:    value,
+		revision: m.revision,
+		created:  time.Now(),
+		op:       op,
+	}
+
+	if m.history == nil {
+		m.history = make(map[string][]*memoryEntry)
+	}
+	entries := append(m.history[key], entry)
+	if op == jetstream.KeyValuePurge {
+		// A purge removes the previous entries
+		entries = entries[len(entries)-1:]
+	}
+	if size := max(m.historySize, 1); len(entries) > size {
+		entries = append([]*memoryEntry(nil), entries[len(entries)-size:]...)
+	}
+	m.history[key] = entries
+
+	for watcher := range m.watchers {
+		watcher.push(entry)
+	}
+	return m.revision
+}
+
+// last returns the last entry of key, nil if key has no history, m.mu must be locked
+func (m *MemoryKV) last(key string) *memoryEntry {
+	entries := m.history[key]
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[len(entries)-1]
+}
+
+// runJanitor removes the expired keys, until no key has a TTL
+func (m *MemoryKV) runJanitor() {
+	ticker := time.NewTicker(memoryJanitorInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		now := time.Now()
+		for key, expires := range m.expiries {
+			if !now.Before(expires) {
+				m.remove(key)
+			}
+		}
+		if len(m.expiries) == 0 {
+			m.janitor = false
+			m.mu.Unlock()
+			return
+		}
+		m.mu.Unlock()
+	}
 }
 
 // Get retrieves a value by key
 func (m *MemoryKV) Get(ctx context.Context, key string) ([]byte, error) {
-	if key == "" {
-		return nil, ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return nil, err
 	}
 
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	value, exists := m.data[key]
+	value, exists := m.lookup(key)
 	if !exists {
 		return nil, ErrKeyNotFound
 	}
@@ -93,27 +242,28 @@ func (m *MemoryKV) Get(ctx context.Context, key string) ([]byte, error) {
 
 // Delete removes a key-value pair
 func (m *MemoryKV) Delete(ctx context.Context, key string) error {
-	if key == "" {
-		return ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return err
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	delete(m.data, key)
+	// As with JetStream, a purge marker is recorded even if the key does not exist
+	m.remove(key)
 	return nil
 }
 
 // Exists checks if a key exists
 func (m *MemoryKV) Exists(ctx context.Context, key string) (bool, error) {
-	if key == "" {
-		return false, ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return false, err
 	}
 
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	_, exists := m.data[key]
+	_, exists := m.lookup(key)
 	return exists, nil
 }
 
@@ -123,18 +273,14 @@ func (m *MemoryKV) SetTyped(ctx context.Context, key string, value interface{},
 		return fmt.Errorf("type registry is required for typed operations")
 	}
 
-	if key == "" {
-		return ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return err
 	}
 
-	// Process options (TTL is not supported in memory implementation)
 	options := &setOptions{}
 	for _, opt := range opts {
 		opt(options)
 	}
-	if options.ttl > 0 {
-		return fmt.Errorf("TTL is not supported in memory implementation")
-	}
 
 	// Marshal the value with type information
 	typedData, err := m.registry.MarshalTypedData(value)
@@ -151,7 +297,7 @@ func (m *MemoryKV) SetTyped(ctx context.Context, key string, value interface{},
 		return fmt.Errorf("failed to marshal typed data to JSON: %w", err)
 	}
 
-	m.data[key] = typedJSON
+	m.put(key, typedJSON, options.ttl)
 	return nil
 }
 
@@ -161,14 +307,14 @@ func (m *MemoryKV) GetTyped(ctx context.Context, key string) (interface{}, error
 		return nil, fmt.Errorf("type registry is required for typed operations")
 	}
 
-	if key == "" {
-		return nil, ErrEmptyKey
+	if err := validDataKey(key); err != nil {
+		return nil, err
 	}
 
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	data, exists := m.data[key]
+	data, exists := m.lookup(key)
 	if !exists {
 		return nil, ErrKeyNotFound
 	}
@@ -190,4 +336,244 @@ func (m *MemoryKV) GetTyped(ctx context.Context, key string) (interface{}, error
 // DeleteTyped removes a typed key-value pair
 func (m *MemoryKV) DeleteTyped(ctx context.Context, key string) error {
 	return m.Delete(ctx, key)
-}
\ No newline at end of file
+}
+
+// Keys returns all keys
+func (m *MemoryKV) Keys(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		if _, exists := m.lookup(key); exists {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// ListKeys returns the keys matching filter, which may contain "*" and ">" wildcards
+func (m *MemoryKV) ListKeys(ctx context.Context, filter string) ([]string, error) {
+	if err := validFilter(filter); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []string
+	for key := range m.data {
+		if _, exists := m.lookup(key); exists && matchFilter(filter, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Watch watches for changes to the keys matching filter, which may contain "*" and ">" wildcards
+func (m *MemoryKV) Watch(ctx context.Context, filter string) (jetstream.KeyWatcher, error) {
+	return m.watch(ctx, filter, false)
+}
+
+// WatchAll watches for changes to all keys, ignoring deletions
+func (m *MemoryKV) WatchAll(ctx context.Context) (jetstream.KeyWatcher, error) {
+	return m.watch(ctx, ">", true)
+}
+
+func (m *MemoryKV) watch(ctx context.Context, filter string, ignoreDeletes bool) (jetstream.KeyWatcher, error) {
+	if err := validFilter(filter); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The last entries of the matching keys, in the order they were written, then the nil marker
+	var initial []jetstream.KeyValueEntry
+	for key := range m.history {
+		if !matchFilter(filter, key) {
+			continue
+		}
+		m.expire(key)
+		if last := m.last(key); !ignoreDeletes || last.op == jetstream.KeyValuePut {
+			initial = append(initial, last)
+		}
+	}
+	sort.Slice(initial, func(i, j int) bool {
+		return initial[i].Revision() < initial[j].Revision()
+	})
+	initial = append(initial, nil)
+
+	watcher := newMemoryWatcher(m, filter, ignoreDeletes, initial)
+	if m.watchers == nil {
+		m.watchers = make(map[*memoryWatcher]struct{})
+	}
+	m.watchers[watcher] = struct{}{}
+	go watcher.run(ctx)
+	return watcher, nil
+}
+
+// History returns the entries of key from the oldest, including the purge marker if it was deleted
+func (m *MemoryKV) History(ctx context.Context, key string) ([]jetstream.KeyValueEntry, error) {
+	if err := validDataKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := m.history[key]
+	if len(entries) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	history := make([]jetstream.KeyValueEntry, len(entries))
+	for i, entry := range entries {
+		history[i] = entry
+	}
+	return history, nil
+}
+
+// GetRevision gets a specific revision of a key, it returns ErrKeyNotFound if the revision
+// is not in the history of the key or is a purge marker
+func (m *MemoryKV) GetRevision(ctx context.Context, key string, revision uint64) ([]byte, error) {
+	if err := validDataKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, entry := range m.history[key] {
+		if entry.revision == revision && entry.op == jetstream.KeyValuePut {
+			return entry.Value(), nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Create stores a key-value pair if the key does not exist, it returns the revision of the value
+func (m *MemoryKV) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	if err := validDataKey(key); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.lookup(key); exists {
+		return 0, ErrKeyExists
+	}
+	return m.put(key, value, 0), nil
+}
+
+// Update stores a key-value pair if the revision of the key is expectedRevision, it returns the new revision.
+// As with JetStream, an expected revision of 0 matches a key that was never set, and the revision of
+// a deleted key is the one of its purge marker.
+func (m *MemoryKV) Update(ctx context.Context, key string, value []byte, expectedRevision uint64) (uint64, error) {
+	if err := validDataKey(key); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire(key)
+	var revision uint64
+	if last := m.last(key); last != nil {
+		revision = last.revision
+	}
+	if revision != expectedRevision {
+		return 0, ErrRevisionMismatch
+	}
+	return m.put(key, value, 0), nil
+}
+
+// GetEntry retrieves the value of a key with its revision
+func (m *MemoryKV) GetEntry(ctx context.Context, key string) (*Entry, error) {
+	if err := validDataKey(key); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, exists := m.lookup(key)
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+
+	last := m.last(key)
+	entry := &Entry{
+		Key:      key,
+		Revision: last.revision,
+		Created:  last.created,
+	}
+	if value != nil {
+		entry.Value = make([]byte, len(value))
+		copy(entry.Value, value)
+	}
+	return entry, nil
+}
+
+// Lock acquires the lock name, waiting until it is released or expires, or ctx is done.
+// Locks are kept apart from the key-value pairs, under the same LockKeyPrefix+name keys as JetStreamKV.
+func (m *MemoryKV) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return lock(ctx, m, name, ttl, true)
+}
+
+// TryLock acquires the lock name, it returns ErrLocked if the lock is held
+func (m *MemoryKV) TryLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	return lock(ctx, m, name, ttl, false)
+}
+
+// Unlock stops the renewal and releases the lock
+func (m *MemoryKV) Unlock(ctx context.Context, lock *Lock) error {
+	return lock.unlock(ctx)
+}
+
+// Refresh extends the lock for its ttl
+func (m *MemoryKV) Refresh(ctx context.Context, lock *Lock) error {
+	return lock.refresh(ctx)
+}
+
+func (m *MemoryKV) acquireLock(ctx context.Context, name string, expires time.Time) (uint64, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if l, held := m.locks[lockKey(name)]; held && time.Now().Before(l.expires) {
+		return 0, 0, ErrLocked
+	}
+	if m.locks == nil {
+		m.locks = make(map[string]memoryLock)
+	}
+	m.revision++
+	m.locks[lockKey(name)] = memoryLock{revision: m.revision, expires: expires}
+	return m.revision, m.revision, nil
+}
+
+func (m *MemoryKV) refreshLock(ctx context.Context, name string, revision uint64, expires time.Time) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, held := m.locks[lockKey(name)]
+	if !held || l.revision != revision {
+		return 0, ErrLockLost
+	}
+	m.revision++
+	l.revision = m.revision
+	l.expires = expires
+	m.locks[lockKey(name)] = l
+	return l.revision, nil
+}
+
+func (m *MemoryKV) releaseLock(ctx context.Context, name string, revision uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l, held := m.locks[lockKey(name)]
+	if !held || l.revision != revision {
+		return ErrLockLost
+	}
+	delete(m.locks, lockKey(name))
+	return nil
+}
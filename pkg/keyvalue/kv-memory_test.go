@@ -121,15 +121,50 @@ func TestMemoryKV_DeleteNonExistentKey(t *testing.T) {
 	assert.NoError(err)
 }
 
-func TestMemoryKV_TTLNotSupported(t *testing.T) {
+func TestMemoryKV_TTL(t *testing.T) {
 	assert := assert.New(t)
 	ctx := context.Background()
 	kv := NewMemoryKV()
 
 	// Test Set with TTL
-	err := kv.Set(ctx, "key", []byte("value"), WithTTL(time.Minute))
-	assert.Error(err)
-	assert.Contains(err.Error(), "TTL is not supported")
+	err := kv.Set(ctx, "key", []byte("value"), WithTTL(100*time.Millisecond))
+	assert.NoError(err)
+	err = kv.Set(ctx, "persistent", []byte("value"))
+	assert.NoError(err)
+	err = kv.Set(ctx, "reset", []byte("value"), WithTTL(100*time.Millisecond))
+	assert.NoError(err)
+	err = kv.Set(ctx, "reset", []byte("value"))
+	assert.NoError(err)
+
+	value, err := kv.Get(ctx, "key")
+	assert.NoError(err)
+	assert.Equal([]byte("value"), value)
+
+	// Expired keys are hidden on read
+	time.Sleep(150 * time.Millisecond)
+	_, err = kv.Get(ctx, "key")
+	assert.Equal(ErrKeyNotFound, err)
+	exists, err := kv.Exists(ctx, "key")
+	assert.NoError(err)
+	assert.False(exists)
+	exists, err = kv.Exists(ctx, "reset")
+	assert.NoError(err)
+	assert.True(exists)
+	_, err = kv.Create(ctx, "key", []byte("again"))
+	assert.NoError(err)
+
+	// and removed by the janitor
+	err = kv.Set(ctx, "expiring", []byte("value"), WithTTL(10*time.Millisecond))
+	assert.NoError(err)
+	assert.Eventually(func() bool {
+		kv.mu.RLock()
+		defer kv.mu.RUnlock()
+		_, stored := kv.data["expiring"]
+		return !stored && !kv.janitor
+	}, 3*time.Second, 10*time.Millisecond)
+	exists, err = kv.Exists(ctx, "persistent")
+	assert.NoError(err)
+	assert.True(exists)
 }
 
 func TestMemoryKV_ConcurrentOperations(t *testing.T) {
@@ -0,0 +1,663 @@
+// Package kvtest provides the conformance test suite of the keyvalue stores.
+//
+// RunConformance checks that a keyvalue.KeyValuer implementation follows the contract of
+// JetStreamKV and MemoryKV, so that the stores can replace each other:
+//
+//	func TestConformance(t *testing.T) {
+//		kvtest.RunConformance(t, func(t *testing.T, registry *typeregistry.Registry) keyvalue.KeyValuer {
+//			return NewMyKV(registry)
+//		})
+//	}
+//
+// The optional interfaces (TypedKeyValuer, RevisionedKeyValuer, Lister, Watcher, Historian, Locker) are checked
+// when the store implements them. Historian stores must keep HistorySize entries by key.
+// The per-key TTL (keyvalue.WithTTL) is checked with a one second TTL unless WithoutTTL is passed.
+package kvtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/keyvalue"
+	"github.com/telemac/natsservice/pkg/typeregistry"
+)
+
+// Factory returns a new empty store for a test.
+// registry is the type registry of the typed operations, nil to test the stores without registry.
+type Factory func(t *testing.T, registry *typeregistry.Registry) keyvalue.KeyValuer
+
+// Item is the value stored by the typed operations tests
+type Item struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+}
+
+// Option configures RunConformance
+type Option func(*options)
+
+type options struct {
+	withoutTTL bool
+}
+
+// WithoutTTL skips the per-key TTL tests, for the stores not supporting keyvalue.WithTTL
+func WithoutTTL() Option {
+	return func(o *options) {
+		o.withoutTTL = true
+	}
+}
+
+// HistorySize is the number of entries by key the Historian stores must keep for the tests
+const HistorySize = 3
+
+// invalidKeys are keys rejected with keyvalue.ErrInvalidKey
+var invalidKeys = []string{"a b", "a.*", "a.>", "*", ".a", "a.", "a:b", "é"}
+
+// RunConformance runs the conformance tests, each on a new store created by factory
+func RunConformance(t *testing.T, factory Factory, opts ...Option) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	t.Run("SetGet", func(t *testing.T) { testSetGet(t, factory(t, nil)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, factory(t, nil)) })
+	t.Run("EmptyValue", func(t *testing.T) { testEmptyValue(t, factory(t, nil)) })
+	t.Run("LargeValue", func(t *testing.T) { testLargeValue(t, factory(t, nil)) })
+	t.Run("EmptyKey", func(t *testing.T) { testEmptyKey(t, factory(t, nil)) })
+	t.Run("InvalidKey", func(t *testing.T) { testInvalidKey(t, factory(t, nil)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, factory(t, nil)) })
+
+	t.Run("Typed", func(t *testing.T) {
+		registry := typeregistry.New()
+		require.NoError(t, typeregistry.Register[Item](registry, "kvtest.Item"))
+		testTyped(t, typed(t, factory(t, registry)))
+	})
+	t.Run("TypedWithoutRegistry", func(t *testing.T) {
+		testTypedWithoutRegistry(t, typed(t, factory(t, nil)))
+	})
+	t.Run("Revisioned", func(t *testing.T) {
+		kv, ok := factory(t, nil).(keyvalue.RevisionedKeyValuer)
+		if !ok {
+			t.Skip("not a RevisionedKeyValuer")
+		}
+		testRevisioned(t, kv)
+	})
+	t.Run("Lister", func(t *testing.T) {
+		kv := factory(t, nil)
+		if _, ok := kv.(keyvalue.Lister); !ok {
+			t.Skip("not a Lister")
+		}
+		testLister(t, kv)
+	})
+	t.Run("Watcher", func(t *testing.T) {
+		kv := factory(t, nil)
+		if _, ok := kv.(keyvalue.Watcher); !ok {
+			t.Skip("not a Watcher")
+		}
+		testWatcher(t, kv)
+	})
+	t.Run("Historian", func(t *testing.T) {
+		kv := factory(t, nil)
+		if _, ok := kv.(keyvalue.Historian); !ok {
+			t.Skip("not a Historian")
+		}
+		testHistorian(t, kv)
+	})
+	t.Run("TTL", func(t *testing.T) {
+		if o.withoutTTL {
+			t.Skip("per-key TTL not supported")
+		}
+		testTTL(t, factory(t, nil))
+	})
+	t.Run("Locker", func(t *testing.T) {
+		kv := factory(t, nil)
+		if _, ok := kv.(keyvalue.Locker); !ok {
+			t.Skip("not a Locker")
+		}
+		testLocker(t, kv)
+	})
+}
+
+// typed returns kv as a TypedKeyValuer, skipping the test if it is not
+func typed(t *testing.T, kv keyvalue.KeyValuer) keyvalue.TypedKeyValuer {
+	typed, ok := kv.(keyvalue.TypedKeyValuer)
+	if !ok {
+		t.Skip("not a TypedKeyValuer")
+	}
+	return typed
+}
+
+func testSetGet(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	value := []byte("value")
+	require.NoError(t, kv.Set(ctx, "key", value))
+	got, err := kv.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal([]byte("value"), got)
+	exists, err := kv.Exists(ctx, "key")
+	require.NoError(t, err)
+	assert.True(exists)
+
+	// The store keeps its own copy of the values
+	value[0] = 'X'
+	got[1] = 'X'
+	got, err = kv.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal([]byte("value"), got)
+
+	require.NoError(t, kv.Set(ctx, "key", []byte("overwritten")))
+	got, err = kv.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal([]byte("overwritten"), got)
+
+	// Keys are case-sensitive and dot-separated
+	require.NoError(t, kv.Set(ctx, "user.1.name", []byte("Alice")))
+	require.NoError(t, kv.Set(ctx, "User.1.name", []byte("Bob")))
+	got, err = kv.Get(ctx, "user.1.name")
+	require.NoError(t, err)
+	assert.Equal([]byte("Alice"), got)
+
+	_, err = kv.Get(ctx, "missing")
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+	exists, err = kv.Exists(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(exists)
+}
+
+func testDelete(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	require.NoError(t, kv.Set(ctx, "key", []byte("value")))
+	require.NoError(t, kv.Delete(ctx, "key"))
+	_, err := kv.Get(ctx, "key")
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+	exists, err := kv.Exists(ctx, "key")
+	require.NoError(t, err)
+	assert.False(exists)
+
+	// Deleting a missing key is not an error
+	assert.NoError(kv.Delete(ctx, "key"))
+	assert.NoError(kv.Delete(ctx, "never-set"))
+
+	// A deleted key can be set again
+	require.NoError(t, kv.Set(ctx, "key", []byte("again")))
+	got, err := kv.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal([]byte("again"), got)
+}
+
+// testEmptyValue checks that nil and empty values are stored.
+// They are read back as empty values, nil or not depending on the store.
+func testEmptyValue(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"nil", "empty"} {
+		var value []byte
+		if key == "empty" {
+			value = []byte{}
+		}
+		require.NoError(t, kv.Set(ctx, key, value))
+		got, err := kv.Get(ctx, key)
+		require.NoError(t, err, key)
+		assert.Empty(got, key)
+		exists, err := kv.Exists(ctx, key)
+		require.NoError(t, err, key)
+		assert.True(exists, key)
+	}
+}
+
+func testLargeValue(t *testing.T, kv keyvalue.KeyValuer) {
+	ctx := context.Background()
+
+	value := make([]byte, 256*1024)
+	for i := range value {
+		value[i] = byte(i % 256)
+	}
+	require.NoError(t, kv.Set(ctx, "large", value))
+	got, err := kv.Get(ctx, "large")
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+}
+
+func testEmptyKey(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	assert.ErrorIs(kv.Set(ctx, "", []byte("value")), keyvalue.ErrEmptyKey)
+	_, err := kv.Get(ctx, "")
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+	assert.ErrorIs(kv.Delete(ctx, ""), keyvalue.ErrEmptyKey)
+	_, err = kv.Exists(ctx, "")
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+}
+
+func testInvalidKey(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	for _, key := range invalidKeys {
+		assert.ErrorIs(kv.Set(ctx, key, []byte("value")), keyvalue.ErrInvalidKey, key)
+		_, err := kv.Get(ctx, key)
+		assert.ErrorIs(err, keyvalue.ErrInvalidKey, key)
+		assert.ErrorIs(kv.Delete(ctx, key), keyvalue.ErrInvalidKey, key)
+		_, err = kv.Exists(ctx, key)
+		assert.ErrorIs(err, keyvalue.ErrInvalidKey, key)
+	}
+
+	// All the allowed characters
+	require.NoError(t, kv.Set(ctx, "a-b/c_d=e.F.0", []byte("value")))
+	got, err := kv.Get(ctx, "a-b/c_d=e.F.0")
+	require.NoError(t, err)
+	assert.Equal([]byte("value"), got)
+}
+
+func testConcurrent(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	const workers, operations = 10, 20
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range operations {
+				key := fmt.Sprintf("worker-%d.key-%d", w, i)
+				value := []byte(key)
+				assert.NoError(kv.Set(ctx, key, value))
+				got, err := kv.Get(ctx, key)
+				assert.NoError(err)
+				assert.Equal(value, got)
+				exists, err := kv.Exists(ctx, key)
+				assert.NoError(err)
+				assert.True(exists)
+				if i%2 == 0 {
+					assert.NoError(kv.Delete(ctx, key))
+				}
+
+				// All workers write the shared key
+				assert.NoError(kv.Set(ctx, "shared", []byte(fmt.Sprintf("worker-%d", w))))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for w := range workers {
+		for i := range operations {
+			exists, err := kv.Exists(ctx, fmt.Sprintf("worker-%d.key-%d", w, i))
+			require.NoError(t, err)
+			assert.Equal(i%2 != 0, exists)
+		}
+	}
+	shared, err := kv.Get(ctx, "shared")
+	require.NoError(t, err)
+	assert.Regexp(`^worker-\d+$`, string(shared))
+}
+
+func testTyped(t *testing.T, kv keyvalue.TypedKeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	item := &Item{ID: "1", Name: "Laptop", Price: 999.99}
+	require.NoError(t, kv.SetTyped(ctx, "item.1", item))
+	got, err := kv.GetTyped(ctx, "item.1")
+	require.NoError(t, err)
+	assert.Equal(item, got)
+
+	require.NoError(t, kv.DeleteTyped(ctx, "item.1"))
+	_, err = kv.GetTyped(ctx, "item.1")
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+
+	assert.ErrorIs(kv.SetTyped(ctx, "", item), keyvalue.ErrEmptyKey)
+	_, err = kv.GetTyped(ctx, "")
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+	assert.ErrorIs(kv.DeleteTyped(ctx, ""), keyvalue.ErrEmptyKey)
+
+	// Values of unregistered types are rejected
+	assert.Error(kv.SetTyped(ctx, "item.2", &struct{ Name string }{"unregistered"}))
+}
+
+func testTypedWithoutRegistry(t *testing.T, kv keyvalue.TypedKeyValuer) {
+	ctx := context.Background()
+
+	assert.ErrorContains(t, kv.SetTyped(ctx, "item.1", &Item{ID: "1"}), "registry is required")
+	_, err := kv.GetTyped(ctx, "item.1")
+	assert.ErrorContains(t, err, "registry is required")
+}
+
+func testRevisioned(t *testing.T, kv keyvalue.RevisionedKeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// Create fails if the key exists
+	rev1, err := kv.Create(ctx, "counter", []byte("1"))
+	require.NoError(t, err)
+	_, err = kv.Create(ctx, "counter", []byte("2"))
+	assert.ErrorIs(err, keyvalue.ErrKeyExists)
+
+	entry, err := kv.GetEntry(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal("counter", entry.Key)
+	assert.Equal([]byte("1"), entry.Value)
+	assert.Equal(rev1, entry.Revision)
+	assert.WithinDuration(time.Now(), entry.Created, 5*time.Second)
+
+	// Update requires the current revision
+	rev2, err := kv.Update(ctx, "counter", []byte("2"), rev1)
+	require.NoError(t, err)
+	assert.Greater(rev2, rev1)
+	_, err = kv.Update(ctx, "counter", []byte("3"), rev1)
+	assert.ErrorIs(err, keyvalue.ErrRevisionMismatch)
+
+	// A blind Set changes the revision
+	require.NoError(t, kv.Set(ctx, "counter", []byte("10")))
+	_, err = kv.Update(ctx, "counter", []byte("11"), rev2)
+	assert.ErrorIs(err, keyvalue.ErrRevisionMismatch)
+	entry, err = kv.GetEntry(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal([]byte("10"), entry.Value)
+	assert.Greater(entry.Revision, rev2)
+
+	// A deleted key can be created again
+	require.NoError(t, kv.Delete(ctx, "counter"))
+	_, err = kv.GetEntry(ctx, "counter")
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+	_, err = kv.Update(ctx, "missing", []byte("1"), 42)
+	assert.ErrorIs(err, keyvalue.ErrRevisionMismatch)
+	rev3, err := kv.Create(ctx, "counter", []byte("1"))
+	require.NoError(t, err)
+	assert.Greater(rev3, entry.Revision)
+
+	_, err = kv.Create(ctx, "", nil)
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+	_, err = kv.Update(ctx, "", nil, 0)
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+	_, err = kv.GetEntry(ctx, "")
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+	_, err = kv.Create(ctx, "a b", nil)
+	assert.ErrorIs(err, keyvalue.ErrInvalidKey)
+}
+
+func testLister(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	lister := kv.(keyvalue.Lister)
+
+	keys, err := lister.Keys(ctx)
+	require.NoError(t, err)
+	assert.Empty(keys)
+
+	for _, key := range []string{"user.1", "user.2", "user.2.email", "product.1"} {
+		require.NoError(t, kv.Set(ctx, key, []byte(key)))
+	}
+	require.NoError(t, kv.Delete(ctx, "product.1"))
+
+	keys, err = lister.Keys(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch([]string{"user.1", "user.2", "user.2.email"}, keys)
+	keys, err = lister.ListKeys(ctx, "user.*")
+	require.NoError(t, err)
+	assert.ElementsMatch([]string{"user.1", "user.2"}, keys)
+	keys, err = lister.ListKeys(ctx, "user.>")
+	require.NoError(t, err)
+	assert.ElementsMatch([]string{"user.1", "user.2", "user.2.email"}, keys)
+	keys, err = lister.ListKeys(ctx, "product.>")
+	require.NoError(t, err)
+	assert.Empty(keys)
+
+	_, err = lister.ListKeys(ctx, "user.>.email")
+	assert.ErrorIs(err, keyvalue.ErrInvalidKey)
+}
+
+// nextEntry returns the next entry of watcher, nil for the end of the initial values
+func nextEntry(t *testing.T, watcher jetstream.KeyWatcher) jetstream.KeyValueEntry {
+	t.Helper()
+	select {
+	case entry, ok := <-watcher.Updates():
+		require.True(t, ok, "updates closed")
+		return entry
+	case <-time.After(2 * time.Second):
+		t.Fatal("no entry")
+		return nil
+	}
+}
+
+// stopped reports whether the updates of watcher are closed
+func stopped(watcher jetstream.KeyWatcher) bool {
+	select {
+	case _, open := <-watcher.Updates():
+		return !open
+	case <-time.After(10 * time.Millisecond):
+		return false
+	}
+}
+
+func testWatcher(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	w := kv.(keyvalue.Watcher)
+
+	for _, key := range []string{"orders.eu.1", "orders.eu.2", "orders.us.1", "users.1"} {
+		require.NoError(t, kv.Set(ctx, key, []byte(key)))
+	}
+
+	watcher, err := w.Watch(ctx, "orders.eu.*")
+	require.NoError(t, err)
+
+	// Current values in the order they were written, then nil
+	entry := nextEntry(t, watcher)
+	assert.Equal("orders.eu.1", entry.Key())
+	assert.Equal([]byte("orders.eu.1"), entry.Value())
+	assert.Equal(jetstream.KeyValuePut, entry.Operation())
+	assert.Equal("orders.eu.2", nextEntry(t, watcher).Key())
+	assert.Nil(nextEntry(t, watcher))
+
+	require.NoError(t, kv.Set(ctx, "orders.us.1", []byte("ignored")))
+	require.NoError(t, kv.Set(ctx, "orders.eu.1", []byte("updated")))
+	entry = nextEntry(t, watcher)
+	assert.Equal("orders.eu.1", entry.Key())
+	assert.Equal([]byte("updated"), entry.Value())
+
+	// Deletions are purge markers
+	require.NoError(t, kv.Delete(ctx, "orders.eu.2"))
+	entry = nextEntry(t, watcher)
+	assert.Equal("orders.eu.2", entry.Key())
+	assert.Equal(jetstream.KeyValuePurge, entry.Operation())
+
+	require.NoError(t, watcher.Stop())
+	assert.Eventually(func() bool { return stopped(watcher) }, time.Second, 10*time.Millisecond)
+
+	_, err = w.Watch(ctx, "orders.>.eu")
+	assert.ErrorIs(err, keyvalue.ErrInvalidKey)
+	_, err = w.Watch(ctx, "")
+	assert.ErrorIs(err, keyvalue.ErrInvalidKey)
+
+	// WatchAll ignores the deleted keys, and stops with its context
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watcher, err = w.WatchAll(watchCtx)
+	require.NoError(t, err)
+	var keys []string
+	for entry := nextEntry(t, watcher); entry != nil; entry = nextEntry(t, watcher) {
+		keys = append(keys, entry.Key())
+	}
+	assert.ElementsMatch([]string{"orders.eu.1", "orders.us.1", "users.1"}, keys)
+
+	require.NoError(t, kv.Delete(ctx, "users.1"))
+	require.NoError(t, kv.Set(ctx, "users.2", []byte("users.2")))
+	assert.Equal("users.2", nextEntry(t, watcher).Key())
+
+	cancel()
+	assert.Eventually(func() bool { return stopped(watcher) }, time.Second, 10*time.Millisecond)
+}
+
+func testHistorian(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	historian := kv.(keyvalue.Historian)
+
+	var revisions []uint64
+	for i := range HistorySize + 1 {
+		require.NoError(t, kv.Set(ctx, "counter", []byte(fmt.Sprint(i))))
+		history, err := historian.History(ctx, "counter")
+		require.NoError(t, err)
+		revisions = append(revisions, history[len(history)-1].Revision())
+	}
+
+	// Only the last HistorySize entries are kept, from the oldest
+	history, err := historian.History(ctx, "counter")
+	require.NoError(t, err)
+	require.Len(t, history, HistorySize)
+	for i, entry := range history {
+		assert.Equal("counter", entry.Key())
+		assert.Equal(revisions[i+1], entry.Revision())
+		assert.Equal([]byte(fmt.Sprint(i+1)), entry.Value())
+		assert.Equal(jetstream.KeyValuePut, entry.Operation())
+	}
+	value, err := historian.GetRevision(ctx, "counter", revisions[1])
+	require.NoError(t, err)
+	assert.Equal([]byte("1"), value)
+	_, err = historian.GetRevision(ctx, "counter", revisions[0])
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+
+	// A deletion leaves only its purge marker
+	require.NoError(t, kv.Delete(ctx, "counter"))
+	history, err = historian.History(ctx, "counter")
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(jetstream.KeyValuePurge, history[0].Operation())
+	_, err = historian.GetRevision(ctx, "counter", history[0].Revision())
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+
+	_, err = historian.History(ctx, "never-set")
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+	_, err = historian.History(ctx, "")
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+	_, err = historian.History(ctx, "a b")
+	assert.ErrorIs(err, keyvalue.ErrInvalidKey)
+}
+
+func testTTL(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	ttl := keyvalue.WithTTL(time.Second)
+
+	require.NoError(t, kv.Set(ctx, "session", []byte("token"), ttl))
+	// Setting a key with a TTL replaces its value, setting it without TTL makes it persistent
+	require.NoError(t, kv.Set(ctx, "replaced", []byte("old")))
+	require.NoError(t, kv.Set(ctx, "replaced", []byte("new"), ttl))
+	require.NoError(t, kv.Set(ctx, "persistent", []byte("old"), ttl))
+	require.NoError(t, kv.Set(ctx, "persistent", []byte("new")))
+
+	got, err := kv.Get(ctx, "session")
+	require.NoError(t, err)
+	assert.Equal([]byte("token"), got)
+	got, err = kv.Get(ctx, "replaced")
+	require.NoError(t, err)
+	assert.Equal([]byte("new"), got)
+	assert.ErrorIs(kv.Set(ctx, "a b", []byte("token"), ttl), keyvalue.ErrInvalidKey)
+
+	// Keys expire after their TTL
+	assert.Eventually(func() bool {
+		for _, key := range []string{"session", "replaced"} {
+			if exists, err := kv.Exists(ctx, key); err != nil || exists {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+	_, err = kv.Get(ctx, "session")
+	assert.ErrorIs(err, keyvalue.ErrKeyNotFound)
+	got, err = kv.Get(ctx, "persistent")
+	require.NoError(t, err)
+	assert.Equal([]byte("new"), got)
+
+	// An expired key can be set again
+	require.NoError(t, kv.Set(ctx, "session", []byte("again")))
+	got, err = kv.Get(ctx, "session")
+	require.NoError(t, err)
+	assert.Equal([]byte("again"), got)
+}
+
+func testLocker(t *testing.T, kv keyvalue.KeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	locker := kv.(keyvalue.Locker)
+	ttl := time.Second
+
+	// Watchers do not receive the changes of the locks
+	var watcher jetstream.KeyWatcher
+	if w, ok := kv.(keyvalue.Watcher); ok {
+		var err error
+		watcher, err = w.Watch(ctx, ">")
+		require.NoError(t, err)
+		defer watcher.Stop()
+		assert.Nil(nextEntry(t, watcher))
+	}
+
+	l1, err := locker.TryLock(ctx, "job", ttl)
+	require.NoError(t, err)
+	_, err = locker.TryLock(ctx, "job", ttl)
+	assert.ErrorIs(err, keyvalue.ErrLocked)
+	require.NoError(t, locker.Refresh(ctx, l1))
+
+	// Lock waits for the release
+	acquired := make(chan *keyvalue.Lock, 1)
+	go func() {
+		l, err := locker.Lock(ctx, "job", ttl)
+		assert.NoError(err)
+		acquired <- l
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-acquired:
+		t.Fatal("lock acquired while held")
+	default:
+	}
+	require.NoError(t, locker.Unlock(ctx, l1))
+	var l2 *keyvalue.Lock
+	select {
+	case l2 = <-acquired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("lock not acquired after its release")
+	}
+	require.NotNil(t, l2)
+	assert.Greater(l2.Token, l1.Token)
+
+	// The keys of the locks are reserved
+	key := keyvalue.LockKeyPrefix + "job"
+	assert.ErrorIs(kv.Set(ctx, key, []byte("value")), keyvalue.ErrInvalidKey)
+	_, err = kv.Get(ctx, key)
+	assert.ErrorIs(err, keyvalue.ErrInvalidKey)
+	assert.ErrorIs(kv.Delete(ctx, key), keyvalue.ErrInvalidKey)
+	if lister, ok := kv.(keyvalue.Lister); ok {
+		keys, err := lister.Keys(ctx)
+		require.NoError(t, err)
+		assert.Empty(keys)
+		keys, err = lister.ListKeys(ctx, ">")
+		require.NoError(t, err)
+		assert.Empty(keys)
+	}
+	require.NoError(t, locker.Unlock(ctx, l2))
+
+	if watcher != nil {
+		require.NoError(t, kv.Set(ctx, "data", []byte("value")))
+		assert.Equal("data", nextEntry(t, watcher).Key())
+	}
+
+	_, err = locker.TryLock(ctx, "", ttl)
+	assert.ErrorIs(err, keyvalue.ErrEmptyKey)
+	_, err = locker.TryLock(ctx, "a b", ttl)
+	assert.ErrorIs(err, keyvalue.ErrInvalidKey)
+}
@@ -0,0 +1,185 @@
+package keyvalue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLocked   = errors.New("lock held by another owner")
+	ErrLockLost = errors.New("lock lost")
+)
+
+// LockKeyPrefix prefixes the keys of the locks, keys starting with it are reserved to the locks :
+// the data operations reject them with ErrInvalidKey, and the listings and watchers skip them
+const LockKeyPrefix = "_lock."
+
+// MinLockTTL is the shortest ttl of a lock, leaving time for its background refreshes
+const MinLockTTL = 100 * time.Millisecond
+
+// lockRetryInterval is the delay between two acquisition attempts of Lock
+var lockRetryInterval = 50 * time.Millisecond
+
+// Locker acquires named locks shared by the users of a store.
+//
+// A lock expires ttl after its acquisition or last refresh, it is refreshed in the background
+// every ttl/3 until Unlock. Each acquisition returns a fencing token, greater than the tokens
+// of the previous acquisitions of the lock : resources protected by the lock should reject
+// writes with a token lower than the last one seen.
+//
+// Lock names are keys (see ErrInvalidKey), stored under LockKeyPrefix apart from the data keys.
+// The expiration time of a lock is recorded by its owner and checked against the clock of
+// the other acquirers: their clocks must agree within a small fraction of ttl.
+type Locker interface {
+	// Lock acquires the lock, waiting until it is released or expires, or ctx is done
+	Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error)
+	// TryLock acquires the lock, it returns ErrLocked if the lock is held
+	TryLock(ctx context.Context, name string, ttl time.Duration) (*Lock, error)
+	// Unlock stops the renewal and releases the lock, it returns ErrLockLost if the lock expired and was acquired again
+	Unlock(ctx context.Context, lock *Lock) error
+	// Refresh extends the lock for its ttl, it returns ErrLockLost if the lock expired and was acquired again
+	Refresh(ctx context.Context, lock *Lock) error
+}
+
+// lockStore is implemented by the stores supporting locks, using revision-based compare-and-set
+type lockStore interface {
+	// acquireLock acquires the lock name until expires, it returns the fencing token and the revision of the lock
+	acquireLock(ctx context.Context, name string, expires time.Time) (token uint64, revision uint64, err error)
+	// refreshLock extends the lock at revision until expires, it returns the new revision of the lock
+	refreshLock(ctx context.Context, name string, revision uint64, expires time.Time) (uint64, error)
+	// releaseLock deletes the lock at revision
+	releaseLock(ctx context.Context, name string, revision uint64) error
+}
+
+// lockRecord is the value of a lock key
+type lockRecord struct {
+	Expires time.Time `json:"expires"`
+}
+
+// Lock is a lock acquired from a Locker
+type Lock struct {
+	Name  string        // Lock name
+	Token uint64        // Fencing token of this acquisition
+	TTL   time.Duration // Lease duration
+
+	store lockStore
+
+	mu       sync.Mutex
+	revision uint64
+
+	stop     chan struct{} // closed to stop the renewal
+	stopOnce sync.Once
+	done     chan struct{} // closed when the renewal stopped
+	lost     chan struct{} // closed when the lock is lost
+	lostOnce sync.Once
+}
+
+// Lost returns a channel closed when the lock could not be refreshed before its expiration,
+// or was acquired by another owner
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// lock acquires name from store, waiting for its release if wait is true
+func lock(ctx context.Context, store lockStore, name string, ttl time.Duration, wait bool) (*Lock, error) {
+	if err := validKey(name); err != nil {
+		return nil, err
+	}
+	if ttl < MinLockTTL {
+		return nil, fmt.Errorf("lock ttl %s is shorter than %s", ttl, MinLockTTL)
+	}
+
+	for {
+		token, revision, err := store.acquireLock(ctx, name, time.Now().Add(ttl))
+		if err == nil {
+			l := &Lock{
+				Name:     name,
+				Token:    token,
+				TTL:      ttl,
+				store:    store,
+				revision: revision,
+				stop:     make(chan struct{}),
+				done:     make(chan struct{}),
+				lost:     make(chan struct{}),
+			}
+			go l.renew()
+			return l, nil
+		}
+		if !wait || !errors.Is(err, ErrLocked) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// lockKey returns the key of the lock name
+func lockKey(name string) string {
+	return LockKeyPrefix + name
+}
+
+// refresh extends the lock for its ttl
+func (l *Lock) refresh(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	revision, err := l.store.refreshLock(ctx, l.Name, l.revision, time.Now().Add(l.TTL))
+	if err != nil {
+		if errors.Is(err, ErrLockLost) {
+			l.lostOnce.Do(func() { close(l.lost) })
+		}
+		return err
+	}
+	l.revision = revision
+	return nil
+}
+
+// unlock stops the renewal and releases the lock
+func (l *Lock) unlock(ctx context.Context) error {
+	l.stopRenewal()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.store.releaseLock(ctx, l.Name, l.revision)
+}
+
+// stopRenewal stops the background renewal and waits for its end
+func (l *Lock) stopRenewal() {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+}
+
+// renew refreshes the lock every ttl/3 until it is unlocked or lost
+func (l *Lock) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+	refreshed := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.lost:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.TTL/3)
+		start := time.Now()
+		err := l.refresh(ctx)
+		cancel()
+		if err == nil {
+			refreshed = start
+		} else if time.Since(refreshed) >= l.TTL {
+			l.lostOnce.Do(func() { close(l.lost) })
+		}
+	}
+}
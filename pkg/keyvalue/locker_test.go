@@ -0,0 +1,184 @@
+package keyvalue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+// setupLockerKV creates an empty bucket in memory, the file buckets of setupTestKV keep the keys of previous runs
+func setupLockerKV(t *testing.T) *JetStreamKV {
+	embedded, err := natstools.StartEmbedded()
+	require.NoError(t, err, "Failed to start embedded NATS")
+	t.Cleanup(func() { embedded.Shutdown() })
+
+	kv, err := NewJetStreamKVWithOptions(context.TODO(), embedded.JetStream(), &jetstream.KeyValueConfig{
+		Bucket:  "locker",
+		Storage: jetstream.MemoryStorage,
+	}, nil)
+	require.NoError(t, err)
+	return kv
+}
+
+func TestLocker_Memory(t *testing.T) {
+	testLocker(t, NewMemoryKV())
+	testLockKeysReserved(t, NewMemoryKV())
+}
+
+func TestLocker_JetStream(t *testing.T) {
+	kv, cleanup := setupTestKV(t, false)
+	defer cleanup()
+	testLocker(t, kv)
+}
+
+func TestLocker_KeysReservedJetStream(t *testing.T) {
+	testLockKeysReserved(t, setupLockerKV(t))
+}
+
+// testLockKeysReserved checks the keys of the locks are out of reach of the data operations
+func testLockKeysReserved(t *testing.T, store interface {
+	RevisionedKeyValuer
+	Locker
+	Lister
+	Watcher
+}) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	watcher, err := store.Watch(ctx, ">")
+	require.NoError(t, err)
+	defer watcher.Stop()
+	select {
+	case entry := <-watcher.Updates():
+		require.Nil(t, entry, "empty store")
+	case <-time.After(time.Second):
+		t.Fatal("no initial marker")
+	}
+
+	l, err := store.TryLock(ctx, "reserved", time.Second)
+	require.NoError(t, err)
+	key := LockKeyPrefix + "reserved"
+	assert.ErrorIs(store.Set(ctx, key, []byte("value")), ErrInvalidKey)
+	_, err = store.Create(ctx, key, []byte("value"))
+	assert.ErrorIs(err, ErrInvalidKey)
+	assert.ErrorIs(store.Delete(ctx, key), ErrInvalidKey)
+	_, err = store.Get(ctx, key)
+	assert.ErrorIs(err, ErrInvalidKey)
+
+	// Locks are not listed nor watched
+	require.NoError(t, store.Set(ctx, "data", []byte("value")))
+	keys, err := store.Keys(ctx)
+	assert.NoError(err)
+	assert.Equal([]string{"data"}, keys)
+	keys, err = store.ListKeys(ctx, "*.*")
+	assert.NoError(err)
+	assert.Empty(keys)
+	select {
+	case entry := <-watcher.Updates():
+		require.NotNil(t, entry)
+		assert.Equal("data", entry.Key())
+	case <-time.After(time.Second):
+		t.Fatal("data change not watched")
+	}
+
+	require.NoError(t, store.Unlock(ctx, l))
+}
+
+func testLocker(t *testing.T, locker Locker) {
+	ctx := context.Background()
+	ttl := 300 * time.Millisecond
+
+	t.Run("TryLock", func(t *testing.T) {
+		assert := assert.New(t)
+
+		l1, err := locker.TryLock(ctx, "try", ttl)
+		require.NoError(t, err)
+		_, err = locker.TryLock(ctx, "try", ttl)
+		assert.ErrorIs(err, ErrLocked)
+
+		require.NoError(t, locker.Unlock(ctx, l1))
+		l2, err := locker.TryLock(ctx, "try", ttl)
+		require.NoError(t, err)
+		assert.Greater(l2.Token, l1.Token)
+		require.NoError(t, locker.Unlock(ctx, l2))
+
+		_, err = locker.TryLock(ctx, "", ttl)
+		assert.ErrorIs(err, ErrEmptyKey)
+		_, err = locker.TryLock(ctx, "invalid name", ttl)
+		assert.ErrorIs(err, ErrInvalidKey)
+		_, err = locker.TryLock(ctx, "short", time.Nanosecond)
+		assert.ErrorContains(err, "shorter than")
+	})
+
+	t.Run("Renewal", func(t *testing.T) {
+		l, err := locker.TryLock(ctx, "renewal", ttl)
+		require.NoError(t, err)
+		time.Sleep(3 * ttl)
+		_, err = locker.TryLock(ctx, "renewal", ttl)
+		assert.ErrorIs(t, err, ErrLocked)
+		require.NoError(t, locker.Refresh(ctx, l))
+		require.NoError(t, locker.Unlock(ctx, l))
+	})
+
+	t.Run("Lock waits", func(t *testing.T) {
+		assert := assert.New(t)
+
+		l1, err := locker.TryLock(ctx, "wait", ttl)
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			locker.Unlock(ctx, l1)
+		}()
+		l2, err := locker.Lock(ctx, "wait", ttl)
+		require.NoError(t, err)
+		assert.Greater(l2.Token, l1.Token)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err = locker.Lock(timeoutCtx, "wait", ttl)
+		assert.ErrorIs(err, context.DeadlineExceeded)
+		require.NoError(t, locker.Unlock(ctx, l2))
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// The owner stops renewing the lock
+		l1, err := locker.TryLock(ctx, "expiry", ttl)
+		require.NoError(t, err)
+		l1.stopRenewal()
+
+		l2, err := locker.Lock(ctx, "expiry", ttl)
+		require.NoError(t, err)
+		assert.Greater(l2.Token, l1.Token)
+
+		assert.ErrorIs(locker.Refresh(ctx, l1), ErrLockLost)
+		select {
+		case <-l1.Lost():
+		default:
+			t.Error("lock not reported lost")
+		}
+		assert.ErrorIs(locker.Unlock(ctx, l1), ErrLockLost)
+		require.NoError(t, locker.Unlock(ctx, l2))
+	})
+
+	t.Run("Apart from data", func(t *testing.T) {
+		assert := assert.New(t)
+
+		// A lock does not take over the data key of the same name
+		kv := locker.(KeyValuer)
+		require.NoError(t, kv.Set(ctx, "shared", []byte(`{"amount":10}`)))
+		l, err := locker.TryLock(ctx, "shared", ttl)
+		require.NoError(t, err)
+		value, err := kv.Get(ctx, "shared")
+		require.NoError(t, err)
+		assert.JSONEq(`{"amount":10}`, string(value))
+		require.NoError(t, locker.Unlock(ctx, l))
+		require.NoError(t, kv.Delete(ctx, "shared"))
+	})
+}
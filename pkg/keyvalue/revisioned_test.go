@@ -0,0 +1,85 @@
+package keyvalue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+// setupRevisionedKV creates an empty bucket in memory, Create fails on the keys left by previous runs in file buckets
+func setupRevisionedKV(t *testing.T) *JetStreamKV {
+	embedded, err := natstools.StartEmbedded()
+	require.NoError(t, err, "Failed to start embedded NATS")
+	t.Cleanup(func() { embedded.Shutdown() })
+
+	kv, err := NewJetStreamKVWithOptions(context.TODO(), embedded.JetStream(), &jetstream.KeyValueConfig{
+		Bucket:  "revisioned",
+		Storage: jetstream.MemoryStorage,
+	}, nil)
+	require.NoError(t, err)
+	return kv
+}
+
+func TestRevisionedKeyValuer_Memory(t *testing.T) {
+	testRevisionedKeyValuer(t, NewMemoryKV())
+}
+
+func TestRevisionedKeyValuer_JetStream(t *testing.T) {
+	testRevisionedKeyValuer(t, setupRevisionedKV(t))
+}
+
+func testRevisionedKeyValuer(t *testing.T, kv RevisionedKeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	// Create fails if the key exists
+	rev1, err := kv.Create(ctx, "counter", []byte("1"))
+	require.NoError(t, err)
+	_, err = kv.Create(ctx, "counter", []byte("2"))
+	assert.ErrorIs(err, ErrKeyExists)
+
+	entry, err := kv.GetEntry(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal("counter", entry.Key)
+	assert.Equal([]byte("1"), entry.Value)
+	assert.Equal(rev1, entry.Revision)
+	assert.WithinDuration(time.Now(), entry.Created, 5*time.Second)
+
+	// Update requires the current revision
+	rev2, err := kv.Update(ctx, "counter", []byte("2"), rev1)
+	require.NoError(t, err)
+	assert.Greater(rev2, rev1)
+	_, err = kv.Update(ctx, "counter", []byte("3"), rev1)
+	assert.ErrorIs(err, ErrRevisionMismatch)
+
+	// A blind Set changes the revision
+	require.NoError(t, kv.Set(ctx, "counter", []byte("10")))
+	_, err = kv.Update(ctx, "counter", []byte("11"), rev2)
+	assert.ErrorIs(err, ErrRevisionMismatch)
+	entry, err = kv.GetEntry(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal([]byte("10"), entry.Value)
+	assert.Greater(entry.Revision, rev2)
+
+	// A deleted key can be created again
+	require.NoError(t, kv.Delete(ctx, "counter"))
+	_, err = kv.GetEntry(ctx, "counter")
+	assert.ErrorIs(err, ErrKeyNotFound)
+	_, err = kv.Update(ctx, "missing", []byte("1"), 42)
+	assert.ErrorIs(err, ErrRevisionMismatch)
+	rev3, err := kv.Create(ctx, "counter", []byte("1"))
+	require.NoError(t, err)
+	assert.Greater(rev3, entry.Revision)
+
+	_, err = kv.Create(ctx, "", nil)
+	assert.ErrorIs(err, ErrEmptyKey)
+	_, err = kv.Update(ctx, "", nil, 0)
+	assert.ErrorIs(err, ErrEmptyKey)
+	_, err = kv.GetEntry(ctx, "")
+	assert.ErrorIs(err, ErrEmptyKey)
+}
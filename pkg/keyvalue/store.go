@@ -0,0 +1,254 @@
+package keyvalue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/telemac/natsservice/pkg/codec"
+)
+
+// ErrNotSupported is returned when the underlying store does not support an operation
+var ErrNotSupported = errors.New("operation not supported by the store")
+
+// EventOp is the operation of a store event
+type EventOp int
+
+const (
+	EventPut EventOp = iota
+	EventDelete
+	EventSynced // end of the current values, the following events are changes
+)
+
+func (op EventOp) String() string {
+	switch op {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	case EventSynced:
+		return "synced"
+	default:
+		return fmt.Sprintf("EventOp(%d)", int(op))
+	}
+}
+
+// Event is a change of a value of a Store
+type Event[T any] struct {
+	Op       EventOp
+	ID       string
+	Value    *T // nil for EventDelete and EventSynced, or if Err is set
+	Revision uint64
+	Err      error // decode error of the value of an EventPut, e.g. a corrupt value or a value of another type
+}
+
+// Store stores values of type T in a KeyValuer, under the "<prefix>.<id>" keys
+type Store[T any] struct {
+	kv     KeyValuer
+	prefix string
+	codec  codec.Codec
+}
+
+// StoreOption is a functional option for NewStore
+type StoreOption func(*storeOptions)
+
+type storeOptions struct {
+	codec codec.Codec
+}
+
+// WithCodec sets the codec of the store values, codec.JSON by default
+func WithCodec(c codec.Codec) StoreOption {
+	return func(opts *storeOptions) {
+		opts.codec = c
+	}
+}
+
+// NewStore creates a store of T values in kv, prefix is prepended to the ids to build the keys
+// (e.g. "user" for "user.<id>" keys), an empty prefix uses the ids as keys.
+func NewStore[T any](kv KeyValuer, prefix string, opts ...StoreOption) *Store[T] {
+	options := storeOptions{codec: codec.JSON{}}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &Store[T]{
+		kv:     kv,
+		prefix: prefix,
+		codec:  options.codec,
+	}
+}
+
+// key returns the key of id
+func (s *Store[T]) key(id string) (string, error) {
+	if id == "" {
+		return "", ErrEmptyKey
+	}
+	return s.prefix + id, nil
+}
+
+// Get retrieves the value of id, it returns ErrKeyNotFound if id does not exist
+func (s *Store[T]) Get(ctx context.Context, id string) (*T, error) {
+	key, err := s.key(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.kv.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.decode(key, data)
+}
+
+// Put stores the value of id
+func (s *Store[T]) Put(ctx context.Context, id string, value *T, opts ...SetOption) error {
+	key, err := s.key(id)
+	if err != nil {
+		return err
+	}
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	return s.kv.Set(ctx, key, data, opts...)
+}
+
+// Create stores the value of id if id does not exist, it returns ErrKeyExists otherwise.
+// The underlying store must implement RevisionedKeyValuer.
+func (s *Store[T]) Create(ctx context.Context, id string, value *T) error {
+	kv, ok := s.kv.(RevisionedKeyValuer)
+	if !ok {
+		return ErrNotSupported
+	}
+	key, err := s.key(id)
+	if err != nil {
+		return err
+	}
+	data, err := s.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	_, err = kv.Create(ctx, key, data)
+	return err
+}
+
+// Delete removes the value of id
+func (s *Store[T]) Delete(ctx context.Context, id string) error {
+	key, err := s.key(id)
+	if err != nil {
+		return err
+	}
+	return s.kv.Delete(ctx, key)
+}
+
+// filter returns the filter matching the keys of the store
+func (s *Store[T]) filter() string {
+	return s.prefix + ">"
+}
+
+// IDs returns the sorted ids of the store.
+// The underlying store must implement Lister.
+func (s *Store[T]) IDs(ctx context.Context) ([]string, error) {
+	kv, ok := s.kv.(Lister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	keys, err := kv.ListKeys(ctx, s.filter())
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, key := range keys {
+		if id, found := strings.CutPrefix(key, s.prefix); found && id != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// List returns the values of the store, sorted by id
+func (s *Store[T]) List(ctx context.Context) ([]*T, error) {
+	ids, err := s.IDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]*T, 0, len(ids))
+	for _, id := range ids {
+		value, err := s.Get(ctx, id)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue // deleted since listed
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Watch returns a channel receiving the current values of the store, an EventSynced event,
+// then their changes, until ctx is done. The underlying store must implement Watcher.
+// Values that can't be decoded are reported by events with Err set.
+func (s *Store[T]) Watch(ctx context.Context) (<-chan Event[T], error) {
+	kv, ok := s.kv.(Watcher)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	watcher, err := kv.Watch(ctx, s.filter())
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %w", s.filter(), err)
+	}
+
+	events := make(chan Event[T])
+	go func() {
+		defer close(events)
+		defer watcher.Stop()
+		for {
+			var update jetstream.KeyValueEntry
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok = <-watcher.Updates():
+				if !ok {
+					return
+				}
+			}
+
+			event := Event[T]{Op: EventSynced} // nil marks the end of the current values
+			if update != nil {
+				event = Event[T]{
+					ID:       strings.TrimPrefix(update.Key(), s.prefix),
+					Revision: update.Revision(),
+				}
+				if update.Operation() == jetstream.KeyValuePut {
+					event.Value, event.Err = s.decode(update.Key(), update.Value())
+				} else {
+					event.Op = EventDelete
+				}
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// decode unmarshals the value of key
+func (s *Store[T]) decode(key string, data []byte) (*T, error) {
+	var value T
+	if err := s.codec.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return &value, nil
+}
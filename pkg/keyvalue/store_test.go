@@ -0,0 +1,163 @@
+package keyvalue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/codec"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+// setupStoreWatchKV creates an empty bucket in memory, the watch would replay the values left by previous runs in file buckets
+func setupStoreWatchKV(t *testing.T) *JetStreamKV {
+	embedded, err := natstools.StartEmbedded()
+	require.NoError(t, err, "Failed to start embedded NATS")
+	t.Cleanup(func() { embedded.Shutdown() })
+
+	kv, err := NewJetStreamKVWithOptions(context.TODO(), embedded.JetStream(), &jetstream.KeyValueConfig{
+		Bucket:  "store-watch",
+		Storage: jetstream.MemoryStorage,
+	}, nil)
+	require.NoError(t, err)
+	return kv
+}
+
+func TestStore_Memory(t *testing.T) {
+	testStore(t, NewMemoryKV())
+}
+
+func TestStore_JetStream(t *testing.T) {
+	kv, cleanup := setupTestKV(t, false)
+	defer cleanup()
+	testStore(t, kv)
+}
+
+func testStore(t *testing.T, kv RevisionedKeyValuer) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	users := NewStore[TestUser](kv, "user")
+	products := NewStore[TestProduct](kv, "product")
+
+	require.NoError(t, users.Put(ctx, "2", &TestUser{ID: "2", Name: "Bob"}))
+	require.NoError(t, users.Create(ctx, "1", &TestUser{ID: "1", Name: "Alice"}))
+	assert.ErrorIs(users.Create(ctx, "1", &TestUser{ID: "1"}), ErrKeyExists)
+	require.NoError(t, products.Put(ctx, "sku-1", &TestProduct{SKU: "sku-1", Price: 9.5}))
+
+	user, err := users.Get(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal("Alice", user.Name)
+	_, err = users.Get(ctx, "3")
+	assert.ErrorIs(err, ErrKeyNotFound)
+	_, err = users.Get(ctx, "")
+	assert.ErrorIs(err, ErrEmptyKey)
+
+	// Values are stored under the prefixed keys
+	data, err := kv.Get(ctx, "product.sku-1")
+	require.NoError(t, err)
+	assert.JSONEq(`{"SKU":"sku-1","Name":"","Price":9.5}`, string(data))
+
+	ids, err := users.IDs(ctx)
+	require.NoError(t, err)
+	assert.Equal([]string{"1", "2"}, ids)
+	list, err := users.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal("Alice", list[0].Name)
+	assert.Equal("Bob", list[1].Name)
+
+	require.NoError(t, users.Delete(ctx, "1"))
+	ids, err = users.IDs(ctx)
+	require.NoError(t, err)
+	assert.Equal([]string{"2"}, ids)
+}
+
+type validatingCodec struct {
+	codec.JSON
+}
+
+func (c validatingCodec) Marshal(v any) ([]byte, error) {
+	if user, ok := v.(*TestUser); ok && user.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	return c.JSON.Marshal(v)
+}
+
+func TestStore_Codec(t *testing.T) {
+	ctx := context.Background()
+	users := NewStore[TestUser](NewMemoryKV(), "", WithCodec(validatingCodec{}))
+	assert.ErrorContains(t, users.Put(ctx, "1", &TestUser{}), "missing name")
+	require.NoError(t, users.Put(ctx, "1", &TestUser{Name: "Alice"}))
+	user, err := users.Get(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", user.Name)
+}
+
+func TestStore_WatchMemory(t *testing.T) {
+	testStoreWatch(t, NewMemoryKV())
+}
+
+func TestStore_WatchJetStream(t *testing.T) {
+	testStoreWatch(t, setupStoreWatchKV(t))
+}
+
+func testStoreWatch(t *testing.T, kv KeyValuer) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	users := NewStore[TestUser](kv, "watched")
+	require.NoError(t, users.Put(ctx, "1", &TestUser{Name: "Alice"}))
+	require.NoError(t, kv.Set(ctx, "other", []byte("ignored")))
+
+	events, err := users.Watch(ctx)
+	require.NoError(t, err)
+	next := func() Event[TestUser] {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return Event[TestUser]{}
+		}
+	}
+
+	// Current values first
+	event := next()
+	assert.Equal(EventPut, event.Op)
+	assert.Equal("1", event.ID)
+	assert.Equal("Alice", event.Value.Name)
+	event = next()
+	assert.Equal(EventSynced, event.Op)
+	assert.Empty(event.ID)
+	assert.Nil(event.Value)
+
+	require.NoError(t, users.Put(ctx, "2", &TestUser{Name: "Bob"}))
+	event = next()
+	assert.Equal("2", event.ID)
+	assert.Equal("Bob", event.Value.Name)
+
+	require.NoError(t, users.Delete(ctx, "1"))
+	event = next()
+	assert.Equal(EventDelete, event.Op)
+	assert.Equal("1", event.ID)
+	assert.Nil(event.Value)
+
+	// Undecodable values are reported
+	require.NoError(t, kv.Set(ctx, "watched.3", []byte("not json")))
+	event = next()
+	assert.Equal(EventPut, event.Op)
+	assert.Equal("3", event.ID)
+	assert.Nil(event.Value)
+	assert.ErrorContains(event.Err, "failed to decode watched.3")
+
+	cancel()
+	assert.Eventually(func() bool {
+		_, open := <-events
+		return !open
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,186 @@
+package keyvalue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+// watchedKeyValuer is a store listing, watching and keeping the history of its keys
+type watchedKeyValuer interface {
+	KeyValuer
+	Lister
+	Watcher
+	Historian
+}
+
+func TestWatcher_Memory(t *testing.T) {
+	testWatcher(t, NewMemoryKV().WithHistory(3))
+}
+
+// setupWatcherKV creates an empty bucket in memory keeping 3 revisions per key, like the MemoryKV of TestWatcher_Memory
+func setupWatcherKV(t *testing.T) *JetStreamKV {
+	embedded, err := natstools.StartEmbedded()
+	require.NoError(t, err, "Failed to start embedded NATS")
+	t.Cleanup(func() { embedded.Shutdown() })
+
+	kv, err := NewJetStreamKVWithOptions(context.TODO(), embedded.JetStream(), &jetstream.KeyValueConfig{
+		Bucket:  "watcher",
+		History: 3,
+		Storage: jetstream.MemoryStorage,
+	}, nil)
+	require.NoError(t, err)
+	return kv
+}
+
+func TestWatcher_JetStream(t *testing.T) {
+	testWatcher(t, setupWatcherKV(t))
+}
+
+// nextEntry returns the next entry of watcher, nil for the end of the initial values
+func nextEntry(t *testing.T, watcher jetstream.KeyWatcher) jetstream.KeyValueEntry {
+	t.Helper()
+	select {
+	case entry, ok := <-watcher.Updates():
+		require.True(t, ok, "updates closed")
+		return entry
+	case <-time.After(2 * time.Second):
+		t.Fatal("no entry")
+		return nil
+	}
+}
+
+func testWatcher(t *testing.T, kv watchedKeyValuer) {
+	ctx := context.Background()
+	for _, key := range []string{"orders.eu.1", "orders.eu.2", "orders.us.1", "users.1"} {
+		require.NoError(t, kv.Set(ctx, key, []byte(key)))
+	}
+
+	t.Run("ListKeys", func(t *testing.T) {
+		assert := assert.New(t)
+
+		keys, err := kv.ListKeys(ctx, "orders.>")
+		require.NoError(t, err)
+		assert.ElementsMatch([]string{"orders.eu.1", "orders.eu.2", "orders.us.1"}, keys)
+		keys, err = kv.ListKeys(ctx, "orders.*.1")
+		require.NoError(t, err)
+		assert.ElementsMatch([]string{"orders.eu.1", "orders.us.1"}, keys)
+		keys, err = kv.ListKeys(ctx, "orders.eu")
+		require.NoError(t, err)
+		assert.Empty(keys)
+		keys, err = kv.Keys(ctx)
+		require.NoError(t, err)
+		assert.Len(keys, 4)
+
+		_, err = kv.ListKeys(ctx, "orders.>.1")
+		assert.ErrorIs(err, jetstream.ErrInvalidKey)
+		_, err = kv.ListKeys(ctx, "")
+		assert.ErrorIs(err, jetstream.ErrInvalidKey)
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		assert := assert.New(t)
+
+		watcher, err := kv.Watch(ctx, "orders.eu.*")
+		require.NoError(t, err)
+
+		// Current values in the order they were written, then nil
+		entry := nextEntry(t, watcher)
+		assert.Equal("orders.eu.1", entry.Key())
+		assert.Equal([]byte("orders.eu.1"), entry.Value())
+		assert.Equal(jetstream.KeyValuePut, entry.Operation())
+		assert.Equal("orders.eu.2", nextEntry(t, watcher).Key())
+		assert.Nil(nextEntry(t, watcher))
+
+		require.NoError(t, kv.Set(ctx, "orders.us.1", []byte("ignored")))
+		require.NoError(t, kv.Set(ctx, "orders.eu.1", []byte("updated")))
+		entry = nextEntry(t, watcher)
+		assert.Equal("orders.eu.1", entry.Key())
+		assert.Equal([]byte("updated"), entry.Value())
+
+		require.NoError(t, kv.Delete(ctx, "orders.eu.2"))
+		entry = nextEntry(t, watcher)
+		assert.Equal("orders.eu.2", entry.Key())
+		assert.Equal(jetstream.KeyValuePurge, entry.Operation())
+
+		require.NoError(t, watcher.Stop())
+		assert.Eventually(func() bool {
+			_, open := <-watcher.Updates()
+			return !open
+		}, time.Second, 10*time.Millisecond)
+
+		_, err = kv.Watch(ctx, "orders.>.eu")
+		assert.ErrorIs(err, jetstream.ErrInvalidKey)
+	})
+
+	t.Run("WatchAll", func(t *testing.T) {
+		assert := assert.New(t)
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		watcher, err := kv.WatchAll(watchCtx)
+		require.NoError(t, err)
+
+		// The deleted orders.eu.2 is not part of the current values
+		var keys []string
+		for entry := nextEntry(t, watcher); entry != nil; entry = nextEntry(t, watcher) {
+			keys = append(keys, entry.Key())
+		}
+		assert.ElementsMatch([]string{"orders.eu.1", "orders.us.1", "users.1"}, keys)
+
+		require.NoError(t, kv.Delete(ctx, "users.1"))
+		require.NoError(t, kv.Set(ctx, "users.2", []byte("users.2")))
+		assert.Equal("users.2", nextEntry(t, watcher).Key())
+
+		cancel()
+		assert.Eventually(func() bool {
+			_, open := <-watcher.Updates()
+			return !open
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		assert := assert.New(t)
+
+		var revisions []uint64
+		for _, value := range []string{"1", "2", "3", "4"} {
+			require.NoError(t, kv.Set(ctx, "counter", []byte(value)))
+			history, err := kv.History(ctx, "counter")
+			require.NoError(t, err)
+			revisions = append(revisions, history[len(history)-1].Revision())
+		}
+
+		// Only the last 3 entries are kept
+		history, err := kv.History(ctx, "counter")
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		for i, entry := range history {
+			assert.Equal(revisions[i+1], entry.Revision())
+			assert.Equal(jetstream.KeyValuePut, entry.Operation())
+		}
+		value, err := kv.GetRevision(ctx, "counter", revisions[1])
+		require.NoError(t, err)
+		assert.Equal([]byte("2"), value)
+		_, err = kv.GetRevision(ctx, "counter", revisions[0])
+		assert.ErrorIs(err, ErrKeyNotFound)
+
+		// A deletion leaves only its purge marker
+		require.NoError(t, kv.Delete(ctx, "counter"))
+		history, err = kv.History(ctx, "counter")
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(jetstream.KeyValuePurge, history[0].Operation())
+		_, err = kv.GetRevision(ctx, "counter", history[0].Revision())
+		assert.ErrorIs(err, ErrKeyNotFound)
+
+		_, err = kv.History(ctx, "never-set")
+		assert.ErrorIs(err, ErrKeyNotFound)
+		_, err = kv.History(ctx, "")
+		assert.ErrorIs(err, ErrEmptyKey)
+	})
+}
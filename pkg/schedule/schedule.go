@@ -0,0 +1,251 @@
+// Package schedule computes the activation times of periodic jobs from cron expressions or fixed intervals.
+//
+// Supported specifications :
+//   - standard cron expressions with 5 fields (minute hour day-of-month month day-of-week),
+//     or 6 fields with a leading seconds field : "*/5 * * * *", "0 30 9 * * MON-FRI"
+//   - descriptors : @yearly (@annually), @monthly, @weekly, @daily (@midnight), @hourly
+//   - fixed intervals : "@every 30s"
+//
+// Fields accept *, values, ranges (1-5), steps (*/10, 0-30/5), lists (1,15,30),
+// month names (JAN-DEC) and day names (SUN-SAT, 7 is also Sunday).
+// When both day-of-month and day-of-week are restricted, a day matching either field is selected.
+// A field starting with * (e.g. */2) is not restricted, days must match both fields, as in cron.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSpec is returned when a schedule specification cannot be parsed
+var ErrInvalidSpec = errors.New("invalid schedule")
+
+// Schedule computes the activation times of a job
+type Schedule interface {
+	// Next returns the first activation time strictly after t
+	Next(t time.Time) time.Time
+}
+
+// Every returns a schedule activated every interval, aligned on multiples of interval since the zero time,
+// so that all the instances of a service compute the same activation times.
+// Intervals are rounded up to the second.
+func Every(d time.Duration) Schedule {
+	if d < time.Second {
+		d = time.Second
+	}
+	if r := d % time.Second; r != 0 {
+		d += time.Second - r
+	}
+	return interval(d)
+}
+
+// interval is a fixed interval schedule
+type interval time.Duration
+
+// Next returns the next multiple of the interval after t
+func (i interval) Next(t time.Time) time.Time {
+	d := time.Duration(i)
+	return t.Truncate(d).Add(d)
+}
+
+// Parse parses a cron expression, a descriptor or a fixed interval
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: %q: invalid interval", ErrInvalidSpec, spec)
+		}
+		return Every(d), nil
+	}
+	if descriptor, ok := descriptors[spec]; ok {
+		spec = descriptor
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("%w: %q: expected 5 or 6 fields", ErrInvalidSpec, spec)
+	}
+
+	c := &cron{}
+	var err error
+	for i, b := range []*bounds{&seconds, &minutes, &hours, &daysOfMonth, &months, &daysOfWeek} {
+		var field uint64
+		if field, err = parseField(fields[i], b); err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrInvalidSpec, spec, err)
+		}
+		*c.field(i) = field
+	}
+	c.domStar = strings.HasPrefix(fields[3], "*") || fields[3] == "?"
+	c.dowStar = strings.HasPrefix(fields[5], "*") || fields[5] == "?"
+	if c.dow&(1<<7) != 0 { // 7 is Sunday
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// MustParse is like Parse but panics if the specification is invalid
+func MustParse(spec string) Schedule {
+	s, err := Parse(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// bounds describes the range and names of a cron field
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	seconds     = bounds{name: "second", min: 0, max: 59}
+	minutes     = bounds{name: "minute", min: 0, max: 59}
+	hours       = bounds{name: "hour", min: 0, max: 23}
+	daysOfMonth = bounds{name: "day of month", min: 1, max: 31}
+	months      = bounds{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	daysOfWeek = bounds{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// parseField parses a comma separated list of ranges into a bit set
+func parseField(field string, b *bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		r, err := parseRange(part, b)
+		if err != nil {
+			return 0, err
+		}
+		bits |= r
+	}
+	return bits, nil
+}
+
+// parseRange parses "*", "n", "a-b", optionally followed by "/step"
+func parseRange(expr string, b *bounds) (uint64, error) {
+	rangeExpr, stepExpr, hasStep := strings.Cut(expr, "/")
+	low, high := b.min, b.max
+	step := 1
+
+	switch {
+	case rangeExpr == "*" || rangeExpr == "?":
+	default:
+		lowExpr, highExpr, isRange := strings.Cut(rangeExpr, "-")
+		var err error
+		if low, err = parseValue(lowExpr, b); err != nil {
+			return 0, err
+		}
+		high = low
+		if isRange {
+			if high, err = parseValue(highExpr, b); err != nil {
+				return 0, err
+			}
+		} else if hasStep {
+			high = b.max // "n/step" starts at n
+		}
+	}
+	if hasStep {
+		var err error
+		step, err = strconv.Atoi(stepExpr)
+		if err != nil || step <= 0 {
+			return 0, fmt.Errorf("invalid %s step %q", b.name, stepExpr)
+		}
+	}
+	if low > high {
+		return 0, fmt.Errorf("invalid %s range %q", b.name, expr)
+	}
+
+	var bits uint64
+	for v := low; v <= high; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+// parseValue parses a field value or name
+func parseValue(expr string, b *bounds) (int, error) {
+	if v, ok := b.names[strings.ToUpper(expr)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil || v < b.min || v > b.max {
+		return 0, fmt.Errorf("invalid %s %q", b.name, expr)
+	}
+	return v, nil
+}
+
+// cron is a schedule defined by a cron expression, each field is a bit set of the allowed values
+type cron struct {
+	second, minute, hour, dom, month, dow uint64
+	domStar, dowStar                      bool
+}
+
+func (c *cron) field(i int) *uint64 {
+	return [...]*uint64{&c.second, &c.minute, &c.hour, &c.dom, &c.month, &c.dow}[i]
+}
+
+// Next returns the first time matching the expression after t, in the location of t.
+// It returns the zero time if no time matches within 5 years (e.g. "0 0 30 2 *").
+func (c *cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if c.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the day-of-month / day-of-week rules
+func (c *cron) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,82 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_Next(t *testing.T) {
+	// Wednesday
+	from := time.Date(2025, time.January, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2025, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 1, 15, 10, 15, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2025, 1, 16, 9, 30, 0, 0, time.UTC)},
+		{"0 30 9 * * MON-FRI", time.Date(2025, 1, 16, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * SAT,SUN", time.Date(2025, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 * *", time.Date(2025, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 1,7 *", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 FEB *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * FRI", time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},  // 13th or friday
+		{"0 0 */2 * MON", time.Date(2025, 1, 27, 0, 0, 0, 0, time.UTC)}, // odd day and monday
+		{"0 0 13 * */2", time.Date(2025, 2, 13, 0, 0, 0, 0, time.UTC)},  // 13th and sunday, tuesday, thursday or saturday
+		{"10-20/5 * * * * *", time.Date(2025, 1, 15, 10, 8, 10, 0, time.UTC)},
+		{"45/5 * * * * *", time.Date(2025, 1, 15, 10, 7, 45, 0, time.UTC)},
+		{"@hourly", time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 1m", time.Date(2025, 1, 15, 10, 8, 0, 0, time.UTC)},
+		{"@every 45s", time.Date(2025, 1, 15, 10, 8, 15, 0, time.UTC)}, // aligned on multiples of 45s
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, s.Next(from))
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * FOO *",
+		"@every",
+		"@every -1s",
+		"@every soon",
+	} {
+		_, err := Parse(spec)
+		assert.ErrorIs(t, err, ErrInvalidSpec, spec)
+	}
+}
+
+func TestCron_NoMatch(t *testing.T) {
+	s := MustParse("0 0 30 2 *")
+	assert.True(t, s.Next(time.Now()).IsZero())
+}
+
+func TestEvery(t *testing.T) {
+	from := time.Date(2025, 1, 15, 10, 7, 30, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 1, 15, 10, 10, 0, 0, time.UTC), Every(5*time.Minute).Next(from))
+	// sub-second intervals are rounded up
+	assert.Equal(t, from.Add(time.Second), Every(100*time.Millisecond).Next(from))
+	assert.Equal(t, from.Add(2*time.Second), Every(1500*time.Millisecond).Next(from))
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// MemoryExporter keeps exported spans in memory, it is meant for tests
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+var _ Exporter = (*MemoryExporter)(nil)
+
+// NewMemoryExporter creates an empty in-memory exporter
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// ExportSpan stores the span
+func (e *MemoryExporter) ExportSpan(ctx context.Context, span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+// Spans returns a copy of the exported spans, in export order
+func (e *MemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset removes all exported spans
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// JSONLinesExporter publishes each span as a newline terminated JSON object on a NATS subject
+type JSONLinesExporter struct {
+	nc      *nats.Conn
+	subject string
+}
+
+var _ Exporter = (*JSONLinesExporter)(nil)
+
+// NewJSONLinesExporter creates an exporter publishing spans to subject
+func NewJSONLinesExporter(nc *nats.Conn, subject string) (*JSONLinesExporter, error) {
+	if nc == nil {
+		return nil, errors.New("nats connection required")
+	}
+	if subject == "" {
+		return nil, errors.New("subject required")
+	}
+	return &JSONLinesExporter{
+		nc:      nc,
+		subject: subject,
+	}, nil
+}
+
+// ExportSpan publishes the span
+func (e *JSONLinesExporter) ExportSpan(ctx context.Context, span SpanData) error {
+	data, err := json.Marshal(span)
+	if err != nil {
+		return fmt.Errorf("failed to marshal span: %w", err)
+	}
+	data = append(data, '\n')
+	if err := e.nc.Publish(e.subject, data); err != nil {
+		return fmt.Errorf("failed to publish span: %w", err)
+	}
+	return nil
+}
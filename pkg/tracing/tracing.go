@@ -0,0 +1,321 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// W3C trace context headers
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// String returns the hex encoding of the trace ID
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid reports whether the trace ID is not all zeros
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the hex encoding of the span ID
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid reports whether the span ID is not all zeros
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is the part of a span propagated across services
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string // Opaque vendor specific state, propagated unchanged
+}
+
+// IsValid reports whether the span context has valid trace and span IDs
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, ErrInvalidTraceparent
+	}
+	// version 00 has exactly 4 fields, future versions may append fields
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, ErrInvalidTraceparent
+	}
+	if err := decodeHex(parts[1], sc.TraceID[:]); err != nil {
+		return sc, err
+	}
+	if err := decodeHex(parts[2], sc.SpanID[:]); err != nil {
+		return sc, err
+	}
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return sc, err
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+func decodeHex(s string, dst []byte) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return ErrInvalidTraceparent
+	}
+	if _, err := hex.Decode(dst, []byte(s)); err != nil {
+		return ErrInvalidTraceparent
+	}
+	return nil
+}
+
+// SpanKind describes the role of a span
+type SpanKind string
+
+const (
+	SpanKindInternal SpanKind = "internal"
+	SpanKindServer   SpanKind = "server"
+	SpanKindClient   SpanKind = "client"
+	SpanKindProducer SpanKind = "producer"
+	SpanKindConsumer SpanKind = "consumer"
+)
+
+// SpanData is the immutable snapshot of an ended span, handed to exporters
+type SpanData struct {
+	Name          string         `json:"name"`
+	Service       string         `json:"service,omitempty"`
+	Kind          SpanKind       `json:"kind"`
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	Duration      time.Duration  `json:"duration"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+	Error         bool           `json:"error,omitempty"`
+	StatusMessage string         `json:"status_message,omitempty"`
+}
+
+// Span is an operation being traced
+type Span struct {
+	mu            sync.Mutex
+	tracer        *Tracer
+	name          string
+	kind          SpanKind
+	context       SpanContext
+	parent        SpanID
+	start         time.Time
+	attributes    map[string]any
+	err           bool
+	statusMessage string
+	ended         bool
+}
+
+// Context returns the span context
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// SetAttribute sets an attribute on the span
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]any)
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed with the given message
+func (s *Span) SetError(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = true
+	s.statusMessage = message
+}
+
+// End ends the span and exports it if it is sampled. Only the first call has an effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	end := time.Now()
+	data := SpanData{
+		Name:          s.name,
+		Service:       s.tracer.service,
+		Kind:          s.kind,
+		TraceID:       s.context.TraceID.String(),
+		SpanID:        s.context.SpanID.String(),
+		Start:         s.start,
+		End:           end,
+		Duration:      end.Sub(s.start),
+		Attributes:    s.attributes,
+		Error:         s.err,
+		StatusMessage: s.statusMessage,
+	}
+	if s.parent.IsValid() {
+		data.ParentSpanID = s.parent.String()
+	}
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.export(data)
+	}
+}
+
+// Exporter sends ended spans to a tracing backend.
+// Implementations must be safe for concurrent use.
+type Exporter interface {
+	ExportSpan(ctx context.Context, span SpanData) error
+}
+
+// Tracer creates spans and hands them to an exporter when they end
+type Tracer struct {
+	service  string
+	exporter Exporter
+	onError  func(error)
+}
+
+// NewTracer creates a tracer for the named service exporting spans to exporter
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{
+		service:  service,
+		exporter: exporter,
+	}
+}
+
+// OnExportError sets a function called when a span cannot be exported
+func (t *Tracer) OnExportError(onError func(error)) *Tracer {
+	t.onError = onError
+	return t
+}
+
+// Start starts a span named name, child of the span (local or remote) found in ctx.
+// A new trace is started if ctx carries no span context.
+// The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+	parent := SpanContextFromContext(ctx)
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.context.TraceState = parent.TraceState
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+	rand.Read(span.context.SpanID[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) export(data SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	if err := t.exporter.ExportSpan(context.Background(), data); err != nil && t.onError != nil {
+		t.onError(err)
+	}
+}
+
+type spanKey struct{}
+type remoteSpanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx carrying a span context received from another service
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context of the span carried by ctx,
+// or the remote span context if ctx carries no span
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context()
+	}
+	sc, _ := ctx.Value(remoteSpanContextKey{}).(SpanContext)
+	return sc
+}
+
+// Inject sets the traceparent and tracestate headers from the span context carried by ctx.
+// The header is left unchanged if ctx carries no span context.
+func Inject(ctx context.Context, header nats.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() || header == nil {
+		return
+	}
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	}
+}
+
+// Extract returns a copy of ctx carrying the remote span context read from the traceparent
+// and tracestate headers. ctx is returned unchanged if the headers are missing or invalid.
+func Extract(ctx context.Context, header nats.Header) context.Context {
+	if header == nil {
+		return ctx
+	}
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	sc.TraceState = header.Get(TracestateHeader)
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
@@ -0,0 +1,121 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	assert := assert.New(t)
+
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal("00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(sc.Sampled)
+	assert.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	}
+	for _, value := range invalid {
+		_, err := ParseTraceparent(value)
+		assert.ErrorIs(err, ErrInvalidTraceparent, value)
+	}
+}
+
+func TestTracer_ParentChild(t *testing.T) {
+	assert := assert.New(t)
+
+	exporter := NewMemoryExporter()
+	tracer := NewTracer("test", exporter)
+
+	ctx, parent := tracer.Start(context.Background(), "parent", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindInternal)
+	child.SetAttribute("key", "value")
+	child.SetError("failed")
+	child.End()
+	child.End() // ending twice exports once
+	parent.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal("child", spans[0].Name)
+	assert.Equal("test", spans[0].Service)
+	assert.Equal(spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal("value", spans[0].Attributes["key"])
+	assert.True(spans[0].Error)
+	assert.Equal("failed", spans[0].StatusMessage)
+	assert.Empty(spans[1].ParentSpanID)
+
+	exporter.Reset()
+	assert.Empty(exporter.Spans())
+}
+
+func TestInjectExtract(t *testing.T) {
+	assert := assert.New(t)
+
+	tracer := NewTracer("test", NewMemoryExporter())
+	ctx, span := tracer.Start(context.Background(), "span", SpanKindClient)
+	span.context.TraceState = "vendor=value"
+
+	header := nats.Header{}
+	Inject(ctx, header)
+	assert.Equal(span.Context().Traceparent(), header.Get(TraceparentHeader))
+	assert.Equal("vendor=value", header.Get(TracestateHeader))
+
+	remote := SpanContextFromContext(Extract(context.Background(), header))
+	assert.Equal(span.Context(), remote)
+
+	// No span context, nothing injected or extracted
+	header = nats.Header{}
+	Inject(context.Background(), header)
+	assert.Empty(header)
+	ctx = context.Background()
+	assert.Equal(ctx, Extract(ctx, header))
+}
+
+func TestJSONLinesExporter(t *testing.T) {
+	assert := assert.New(t)
+
+	srv, cleanup := natstools.TestServer(t)
+	defer cleanup()
+	nc := srv.Connection()
+
+	sub, err := nc.SubscribeSync("traces")
+	require.NoError(t, err)
+
+	exporter, err := NewJSONLinesExporter(nc, "traces")
+	require.NoError(t, err)
+	tracer := NewTracer("test", exporter)
+	_, span := tracer.Start(context.Background(), "span", SpanKindProducer)
+	span.End()
+
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(byte('\n'), msg.Data[len(msg.Data)-1])
+	var data SpanData
+	require.NoError(t, json.Unmarshal(msg.Data, &data))
+	assert.Equal("span", data.Name)
+	assert.Equal(SpanKindProducer, data.Kind)
+	assert.Equal(span.Context().SpanID.String(), data.SpanID)
+
+	_, err = NewJSONLinesExporter(nil, "traces")
+	assert.Error(err)
+	_, err = NewJSONLinesExporter(nc, "")
+	assert.Error(err)
+}
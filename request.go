@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/nats-io/nats.go"
+	"github.com/telemac/natsservice/pkg/tracing"
 	"github.com/telemac/natsservice/pkg/typeregistry"
 )
 
@@ -14,15 +15,17 @@ import (
 // nc: NATS connection
 // subject: the subject to send the request to
 // request: the request payload (any type that can be marshaled to JSON)
+// opts: optional request options, e.g. WithNkey to attach credentials
 //
 // Returns:
 //   response: the response unmarshaled into the provided type
-//   error: any error that occurred
+//   error: any error that occurred, a *ServiceError if the endpoint replied with an error
 func Request[TRequest, TResponse any](
 	ctx context.Context,
 	nc *nats.Conn,
 	subject string,
 	request TRequest,
+	opts ...RequestOption,
 ) (*TResponse, error) {
 	// Validate connection
 	if nc == nil {
@@ -38,12 +41,27 @@ func Request[TRequest, TResponse any](
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Send request and wait for response
-	msg, err := nc.RequestWithContext(ctx, subject, reqData)
+	// Send request and wait for response, propagating the context deadline
+	reqMsg := &nats.Msg{
+		Subject: subject,
+		Data:    reqData,
+		Header:  nats.Header{},
+	}
+	setDeadlineHeader(ctx, reqMsg)
+	propagateContext(ctx, reqMsg.Header)
+	if err := applyRequestOptions(reqMsg, opts); err != nil {
+		return nil, err
+	}
+	msg, err := requestMsg(ctx, nc, reqMsg)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 
+	// Decode service error responses
+	if err := ResponseError(msg); err != nil {
+		return nil, err
+	}
+
 	// Unmarshal response
 	var response TResponse
 	if err := json.Unmarshal(msg.Data, &response); err != nil {
@@ -53,55 +71,6 @@ func Request[TRequest, TResponse any](
 	return &response, nil
 }
 
-// RequestAsync makes an asynchronous request to a NATS microservice endpoint
-// nc: NATS connection
-// subject: the subject to send the request to
-// request: the request payload (any type that can be marshaled to JSON)
-// handler: function to handle the response
-//
-// Returns:
-//   error: any error that occurred while sending the request
-func RequestAsync[TRequest any](
-	nc *nats.Conn,
-	subject string,
-	request TRequest,
-	handler func(*nats.Msg),
-) error {
-	// Validate connection
-	if nc == nil {
-		return fmt.Errorf("NATS connection is nil")
-	}
-	if !nc.IsConnected() {
-		return fmt.Errorf("NATS connection is not active")
-	}
-
-	// Marshal the request
-	reqData, err := json.Marshal(request)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	// Create inbox for response
-	inbox := nats.NewInbox()
-
-	// Subscribe to inbox for the response
-	sub, err := nc.Subscribe(inbox, handler)
-	if err != nil {
-		return fmt.Errorf("failed to subscribe for response: %w", err)
-	}
-
-	// Auto-unsubscribe after one message
-	sub.AutoUnsubscribe(1)
-
-	// Publish request with reply subject
-	err = nc.PublishRequest(subject, inbox, reqData)
-	if err != nil {
-		return fmt.Errorf("failed to publish request: %w", err)
-	}
-
-	return nil
-}
-
 // TypedRequest makes a typed request to a NATS microservice endpoint
 // The request type is looked up in the registry and included as a header.
 // The response type is determined from the response header and unmarshaled accordingly.
@@ -110,11 +79,12 @@ func RequestAsync[TRequest any](
 // tr: type registry for looking up types
 // subject: the subject to send the request to
 // request: the request payload (must be registered in the type registry)
+// opts: optional request options, e.g. WithNkey to attach credentials
 //
 // Returns:
 //   response: the response unmarshaled to the type specified in the response header
-//   error: any error that occurred
-func TypedRequest(ctx context.Context, nc *nats.Conn, tr *typeregistry.Registry, subject string, request any) (any, error) {
+//   error: any error that occurred, a *ServiceError if the endpoint replied with an error
+func TypedRequest(ctx context.Context, nc *nats.Conn, tr *typeregistry.Registry, subject string, request any, opts ...RequestOption) (any, error) {
 	if nc == nil {
 		return nil, fmt.Errorf("NATS connection is nil")
 	}
@@ -145,13 +115,23 @@ func TypedRequest(ctx context.Context, nc *nats.Conn, tr *typeregistry.Registry,
 		Header:  nats.Header{},
 	}
 	msg.Header.Set("X-Type", requestTypeName)
+	setDeadlineHeader(ctx, msg)
+	propagateContext(ctx, msg.Header)
+	if err := applyRequestOptions(msg, opts); err != nil {
+		return nil, err
+	}
 
 	// Send request and wait for response (with a default timeout)
-	respMsg, err := nc.RequestMsgWithContext(ctx, msg)
+	respMsg, err := requestMsg(ctx, nc, msg)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
 
+	// Decode service error responses
+	if err := ResponseError(respMsg); err != nil {
+		return nil, err
+	}
+
 	// Get the type header from the response
 	responseTypeName := respMsg.Header.Get("X-Type")
 	if responseTypeName == "" {
@@ -178,6 +158,24 @@ func Publish[TRequest any](
 	nc *nats.Conn,
 	subject string,
 	request TRequest,
+) error {
+	return PublishWithContext(context.Background(), nc, subject, request)
+}
+
+// PublishWithContext publishes a message to a NATS subject without expecting a response,
+// propagating the trace context carried by ctx
+// ctx: context carrying the trace context
+// nc: NATS connection
+// subject: the subject to publish to
+// request: the request payload (any type that can be marshaled to JSON)
+//
+// Returns:
+//   error: any error that occurred while publishing
+func PublishWithContext[TRequest any](
+	ctx context.Context,
+	nc *nats.Conn,
+	subject string,
+	request TRequest,
 ) error {
 	// Validate connection
 	if nc == nil {
@@ -194,10 +192,46 @@ func Publish[TRequest any](
 	}
 
 	// Publish message
-	err = nc.Publish(subject, reqData)
+	msg := &nats.Msg{
+		Subject: subject,
+		Data:    reqData,
+		Header:  nats.Header{},
+	}
+	propagateContext(ctx, msg.Header)
+	err = nc.PublishMsg(msg)
 	if err != nil {
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
 	return nil
 }
+
+// requestMsg sends msg and waits for its response. Requests whose reply subject was set before sending,
+// e.g. because it is covered by their signature, wait on their own inbox,
+// the other ones on the shared response subscription of the connection.
+func requestMsg(ctx context.Context, nc *nats.Conn, msg *nats.Msg) (*nats.Msg, error) {
+	if msg.Reply == "" {
+		return nc.RequestMsgWithContext(ctx, msg)
+	}
+	sub, err := nc.SubscribeSync(msg.Reply)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+	if err := sub.AutoUnsubscribe(1); err != nil {
+		return nil, err
+	}
+	if err := nc.PublishMsg(msg); err != nil {
+		return nil, err
+	}
+	return sub.NextMsgWithContext(ctx)
+}
+
+// propagateContext sets the headers propagating the caller context :
+// the trace context and the request ID of the request being handled, if any
+func propagateContext(ctx context.Context, header nats.Header) {
+	tracing.Inject(ctx, header)
+	if id := RequestIDFromContext(ctx); id != "" {
+		header.Set(RequestIDHeader, id)
+	}
+}
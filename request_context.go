@@ -0,0 +1,223 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// serviceRequest wraps the micro.Request handed to endpoints by the service.
+// It carries the request context and the headers added to every response.
+type serviceRequest struct {
+	micro.Request
+	ctx     context.Context
+	state   *requestState
+	timeout bool // set on the copy sending the timeout error of an expired request
+}
+
+// requestState is shared by all copies of a serviceRequest
+type requestState struct {
+	mu               sync.Mutex
+	headers          micro.Headers
+	responded        bool
+	expired          bool   // the deadline expired before a response was sent, later responses are dropped
+	errorCode        string // code of the error response, if any
+	errorDescription string
+}
+
+var _ micro.Request = (*serviceRequest)(nil)
+
+// errRequestExpired is returned by the responses sent after the deadline of the request, replaced by a timeout error
+var errRequestExpired = errors.New("request deadline expired, response dropped")
+
+func newServiceRequest(ctx context.Context, request micro.Request) *serviceRequest {
+	return &serviceRequest{
+		Request: request,
+		ctx:     ctx,
+		state:   &requestState{headers: micro.Headers{}},
+	}
+}
+
+// Context returns the request context
+func (r *serviceRequest) Context() context.Context {
+	return r.ctx
+}
+
+// Respond sends the response, adding the response headers set on the request
+func (r *serviceRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	opts, err := r.respondOpts(opts)
+	if err != nil {
+		return err
+	}
+	return r.Request.Respond(data, opts...)
+}
+
+// RespondJSON marshals the response and sends it, adding the response headers set on the request
+func (r *serviceRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	opts, err := r.respondOpts(opts)
+	if err != nil {
+		return err
+	}
+	return r.Request.RespondJSON(v, opts...)
+}
+
+// Error sends an error response, adding the response headers set on the request
+func (r *serviceRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	opts, err := r.respondOpts(opts)
+	if err != nil {
+		return err
+	}
+	r.state.mu.Lock()
+	r.state.errorCode = code
+	r.state.errorDescription = description
+	r.state.mu.Unlock()
+	return r.Request.Error(code, description, data, opts...)
+}
+
+// respondOpts marks the request as responded and prepends the response headers to opts.
+// It returns errRequestExpired if the deadline of the request expired before a response was sent.
+func (r *serviceRequest) respondOpts(opts []micro.RespondOpt) ([]micro.RespondOpt, error) {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	if r.state.expired && !r.timeout {
+		return nil, errRequestExpired
+	}
+	r.state.responded = true
+	if len(r.state.headers) == 0 {
+		return opts, nil
+	}
+	headers := make(micro.Headers, len(r.state.headers))
+	for k, v := range r.state.headers {
+		headers[k] = append([]string(nil), v...)
+	}
+	return append([]micro.RespondOpt{micro.WithHeaders(headers)}, opts...), nil
+}
+
+// expire sends a timeout error if no response was sent for the request, the later responses are dropped
+func (r *serviceRequest) expire() {
+	r.state.mu.Lock()
+	if r.state.responded {
+		r.state.mu.Unlock()
+		return
+	}
+	r.state.expired = true
+	r.state.mu.Unlock()
+
+	timeout := *r
+	timeout.timeout = true
+	RespondError(&timeout, RequestTimeoutError())
+}
+
+// responded reports whether a response has been sent for the request
+func (r *serviceRequest) responded() bool {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	return r.state.responded
+}
+
+// responseError returns the code and description of the error response sent for request, if any
+func responseError(request micro.Request) (code, description string) {
+	r, ok := request.(*serviceRequest)
+	if !ok {
+		return "", ""
+	}
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	return r.state.errorCode, r.state.errorDescription
+}
+
+// RequestContext returns the context of a request.
+// Requests dispatched by the service carry a context with the values of ServiceConfig.Ctx. It is cancelled
+// by the request deadline or when the service stops, not by the cancellation of ServiceConfig.Ctx,
+// which starts a graceful shutdown waiting for the request.
+// Other requests return their own Context() if they implement it, or context.Background().
+func RequestContext(request micro.Request) context.Context {
+	if r, ok := request.(interface{ Context() context.Context }); ok {
+		if ctx := r.Context(); ctx != nil {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+// WithRequestContext returns a copy of request using ctx as its context.
+// It is meant to be used by middlewares enriching the request context.
+// Requests not dispatched by the service are returned unchanged.
+func WithRequestContext(request micro.Request, ctx context.Context) micro.Request {
+	r, ok := request.(*serviceRequest)
+	if !ok {
+		return request
+	}
+	clone := *r
+	clone.ctx = ctx
+	return &clone
+}
+
+// SetResponseHeader sets a header added to every response sent for request.
+// Requests not dispatched by the service are ignored.
+func SetResponseHeader(request micro.Request, key, value string) {
+	r, ok := request.(*serviceRequest)
+	if !ok {
+		return
+	}
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+	r.state.headers[key] = []string{value}
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying log
+func ContextWithLogger(ctx context.Context, log *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// LoggerFromContext returns the logger stored in ctx, or slog.Default()
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && log != nil {
+		return log
+	}
+	return slog.Default()
+}
+
+// RequestLogger returns the logger of a request, with service and endpoint attributes
+func RequestLogger(request micro.Request) *slog.Logger {
+	return LoggerFromContext(RequestContext(request))
+}
+
+// endpointInfo describes the endpoint a request is dispatched to
+type endpointInfo struct {
+	service  *Service
+	endpoint Endpointer
+	config   *EndpointConfig
+}
+
+type endpointInfoKey struct{}
+
+func contextWithEndpointInfo(ctx context.Context, info *endpointInfo) context.Context {
+	return context.WithValue(ctx, endpointInfoKey{}, info)
+}
+
+func endpointInfoFromContext(ctx context.Context) *endpointInfo {
+	info, _ := ctx.Value(endpointInfoKey{}).(*endpointInfo)
+	return info
+}
+
+// RequestEndpoint returns the endpoint a request is dispatched to, or nil
+func RequestEndpoint(request micro.Request) Endpointer {
+	if info := endpointInfoFromContext(RequestContext(request)); info != nil {
+		return info.endpoint
+	}
+	return nil
+}
+
+// RequestEndpointName returns the name of the endpoint a request is dispatched to, or an empty string
+func RequestEndpointName(request micro.Request) string {
+	if info := endpointInfoFromContext(RequestContext(request)); info != nil {
+		return info.config.Name
+	}
+	return ""
+}
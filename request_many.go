@@ -0,0 +1,142 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// InstanceIDHeader is the response header carrying the ID of the service instance that replied
+const InstanceIDHeader = "Nats-Service-Instance"
+
+// RequestManyConfig bounds the collection of the replies of RequestMany.
+// The collection stops at the first limit reached.
+type RequestManyConfig struct {
+	Timeout      time.Duration // Collection time, bounded by the context deadline (DefaultRequestTimeout if neither is set)
+	MaxReplies   int           // Number of replies to collect, 0 for no limit
+	StallTimeout time.Duration // Time to wait for another reply once one is received, 0 to wait until the timeout
+}
+
+// Reply is the reply of one responder to RequestMany
+type Reply[Resp any] struct {
+	InstanceID string      // ID of the replying service instance, empty if not sent by a natsservice service
+	Response   *Resp       // Decoded response, nil if Err is set
+	Err        error       // *ServiceError if the responder replied with an error, or the decoding error
+	Header     nats.Header // Headers of the reply
+}
+
+// RequestMany sends request to subject and collects the replies of all the responders,
+// e.g. all the instances of a service whose endpoints do not use a queue group.
+// ctx: context for the request
+// nc: NATS connection
+// subject: the subject to send the request to
+// request: the request payload (any type that can be marshaled to JSON)
+// config: limits of the collection (timeout, number of replies, stall timeout)
+// opts: optional request options, e.g. WithNkey to attach credentials
+//
+// Returns:
+//   replies: the replies in their order of arrival, each with its response or error
+//   error: nats.ErrNoResponders if nobody listens on subject, the context error if ctx is cancelled,
+//   or any error that occurred while sending the request
+func RequestMany[TRequest, TResponse any](
+	ctx context.Context,
+	nc *nats.Conn,
+	subject string,
+	request TRequest,
+	config RequestManyConfig,
+	opts ...RequestOption,
+) ([]Reply[TResponse], error) {
+	// Validate connection
+	if nc == nil {
+		return nil, fmt.Errorf("NATS connection is nil")
+	}
+	if !nc.IsConnected() {
+		return nil, fmt.Errorf("NATS connection is not active")
+	}
+
+	// Marshal the request
+	reqData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Bound the collection
+	timeout := config.Timeout
+	if _, ok := ctx.Deadline(); !ok && timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Subscribe to the replies before sending the request
+	inbox := nats.NewInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe for replies: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	msg := &nats.Msg{
+		Subject: subject,
+		Reply:   inbox,
+		Data:    reqData,
+		Header:  nats.Header{},
+	}
+	setDeadlineHeader(ctx, msg)
+	propagateContext(ctx, msg.Header)
+	if err := applyRequestOptions(msg, opts); err != nil {
+		return nil, err
+	}
+	if err := nc.PublishMsg(msg); err != nil {
+		return nil, fmt.Errorf("failed to publish request: %w", err)
+	}
+
+	var replies []Reply[TResponse]
+	for config.MaxReplies <= 0 || len(replies) < config.MaxReplies {
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if config.StallTimeout > 0 && len(replies) > 0 {
+			waitCtx, cancel = context.WithTimeout(ctx, config.StallTimeout)
+		}
+		replyMsg, err := sub.NextMsgWithContext(waitCtx)
+		cancel()
+		if errors.Is(err, nats.ErrNoResponders) {
+			// The server reports the absence of responders before any reply
+			return nil, err
+		}
+		if err != nil {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return replies, ctx.Err()
+			}
+			break // timeout or stall
+		}
+		replies = append(replies, decodeReply[TResponse](replyMsg))
+	}
+
+	return replies, nil
+}
+
+// decodeReply decodes the response or the service error of a reply message
+func decodeReply[TResponse any](msg *nats.Msg) Reply[TResponse] {
+	reply := Reply[TResponse]{
+		InstanceID: msg.Header.Get(InstanceIDHeader),
+		Header:     msg.Header,
+	}
+	if err := ResponseError(msg); err != nil {
+		reply.Err = err
+		return reply
+	}
+	var response TResponse
+	if err := json.Unmarshal(msg.Data, &response); err != nil {
+		reply.Err = fmt.Errorf("failed to unmarshal response: %w", err)
+		return reply
+	}
+	reply.Response = &response
+	return reply
+}
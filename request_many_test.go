@@ -0,0 +1,108 @@
+package natsservice
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+// startTestFleet starts instances of the "fleet" service answering "fleet.ping" after their delay,
+// the instance with a negative delay replies with an error
+func startTestFleet(t *testing.T, delays ...time.Duration) (*nats.Conn, []*Service) {
+	t.Helper()
+	srv, cleanup := natstools.TestServer(t)
+	t.Cleanup(cleanup)
+
+	var instances []*Service
+	for i, delay := range delays {
+		svc, err := StartService(&ServiceConfig{
+			Ctx:     context.Background(),
+			Nc:      srv.Connection(),
+			Logger:  slog.Default(),
+			Name:    "fleet",
+			Group:   "fleet",
+			Version: "0.0.1",
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { svc.Stop() })
+
+		require.NoError(t, svc.AddEndpoint(&testEndpoint{
+			config: &EndpointConfig{Name: "ping"},
+			handle: func(request micro.Request) {
+				if delay < 0 {
+					RespondError(request, NewServiceError(CodeInternalError, "broken"))
+					return
+				}
+				time.Sleep(delay)
+				request.Respond([]byte{byte('0' + i)})
+			},
+		}))
+		instances = append(instances, svc)
+	}
+	return srv.Connection(), instances
+}
+
+func TestRequestMany(t *testing.T) {
+	assert := assert.New(t)
+	nc, instances := startTestFleet(t, 0, 0, -1)
+
+	replies, err := RequestMany[struct{}, int](context.Background(), nc, "fleet.ping", struct{}{},
+		RequestManyConfig{Timeout: 200 * time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, replies, 3)
+
+	responses := map[string]int{}
+	for _, reply := range replies {
+		if reply.Err != nil {
+			assert.ErrorIs(reply.Err, NewServiceError(CodeInternalError, ""))
+			assert.Equal(instances[2].ID(), reply.InstanceID)
+			continue
+		}
+		responses[reply.InstanceID] = *reply.Response
+	}
+	assert.Equal(map[string]int{instances[0].ID(): 0, instances[1].ID(): 1}, responses)
+
+	// Single requests are also answered with the instance ID
+	msg, err := nc.Request("fleet.ping", nil, time.Second)
+	require.NoError(t, err)
+	assert.Contains([]string{instances[0].ID(), instances[1].ID(), instances[2].ID()}, msg.Header.Get(InstanceIDHeader))
+}
+
+func TestRequestMany_Limits(t *testing.T) {
+	assert := assert.New(t)
+	nc, _ := startTestFleet(t, 0, 0, 500*time.Millisecond)
+	ctx := context.Background()
+
+	replies, err := RequestMany[struct{}, int](ctx, nc, "fleet.ping", struct{}{}, RequestManyConfig{MaxReplies: 1})
+	require.NoError(t, err)
+	assert.Len(replies, 1)
+
+	// The slow instance is not waited for
+	start := time.Now()
+	replies, err = RequestMany[struct{}, int](ctx, nc, "fleet.ping", struct{}{}, RequestManyConfig{StallTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Len(replies, 2)
+	assert.Less(time.Since(start), 400*time.Millisecond)
+
+	// The context deadline bounds the collection
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	replies, err = RequestMany[struct{}, int](timeoutCtx, nc, "fleet.ping", struct{}{}, RequestManyConfig{Timeout: time.Second})
+	require.NoError(t, err)
+	assert.Len(replies, 2)
+
+	_, err = RequestMany[struct{}, int](ctx, nc, "fleet.missing", struct{}{}, RequestManyConfig{})
+	assert.ErrorIs(err, nats.ErrNoResponders)
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = RequestMany[struct{}, int](cancelledCtx, nc, "fleet.ping", struct{}{}, RequestManyConfig{})
+	assert.ErrorIs(err, context.Canceled)
+}
@@ -5,21 +5,34 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/nats.go/micro"
+	"github.com/telemac/natsservice/pkg/tracing"
 )
 
 // Servicer defines a service interface for managing endpoints and configuration.
 // Stop stops the service and performs cleanup operations.
+// Shutdown gracefully stops the service, waiting for in-flight requests.
 // Config retrieves the service's current configuration.
 // AddEndpoint registers a new endpoint with the service.
+// AddSubscriber subscribes a fire-and-forget message handler.
+// AddConsumer consumes a durable JetStream consumer.
+// AddJob runs a function periodically.
 type Servicer interface {
 	Stop() error
+	Shutdown(ctx context.Context) error
+	OnShutdown(hook ShutdownHook)
 	Config() *ServiceConfig
 	AddEndpoint(endpointer Endpointer) error
 	AddEndpoints(endpointer ...Endpointer) error
+	AddSubscriber(subscriber Subscriber) error
+	AddConsumer(config ConsumerConfig, handler ConsumerHandler) error
+	AddJob(name string, spec string, fn JobFunc, opts ...JobOption) error
+	Use(middlewares ...Middleware)
 	Ctx() context.Context
 	Nc() *nats.Conn
 	Jetstream() jetstream.JetStream
@@ -29,8 +42,27 @@ type Servicer interface {
 var _ Servicer = (*Service)(nil)
 
 type Service struct {
-	config   *ServiceConfig
-	microSvc micro.Service
+	config      *ServiceConfig
+	microSvc    micro.Service
+	middlewares []Middleware
+
+	mu            sync.RWMutex
+	closing       bool           // set during shutdown, new requests are rejected
+	inflight      sync.WaitGroup // in-flight handler invocations
+	shutdownHooks []ShutdownHook
+	pools         map[string]*workerPool   // worker pools by endpoint name
+	subscriptions map[string]*subscription // subscriber stats by subscriber name
+	consumers     []jetstream.ConsumeContext
+	jobs          map[string]*job   // scheduled jobs by name
+	handlerKinds  map[string]string // kind of the endpoints, subscribers and jobs by name
+	shutdownOnce  sync.Once
+	shutdownErr   error
+	done          chan struct{} // closed when the service is stopped
+	doneOnce      sync.Once
+	// handlerCtx is the parent context of the handler invocations: it carries the values of ServiceConfig.Ctx
+	// but is only cancelled once the service is stopped, so that Shutdown drains the in-flight handlers
+	handlerCtx     context.Context
+	cancelHandlers context.CancelFunc
 }
 
 type ServiceConfig struct {
@@ -43,6 +75,17 @@ type ServiceConfig struct {
 	Version     string            `json:"version"`            // Service version (must be SerVer)
 	Description string            `json:"description"`        // Service description
 	Metadata    map[string]string `json:"metadata,omitempty"` // Additional metadata
+	Middlewares []Middleware      `json:"-"`                  // Middlewares applied to all endpoints
+	Tracer      *tracing.Tracer   `json:"-"`                  // Starts a span per request if not nil
+	// DeadLetters stores the messages terminated by the service consumers if not nil
+	DeadLetters *DeadLetterQueue `json:"-"`
+	// Authenticator verifies the caller credentials of every request if not nil, see EndpointConfig.RequiredRoles
+	Authenticator Authenticator `json:"-"`
+	// Leader reports the leadership of the instance among the service replicas, required by EndpointConfig.LeaderOnly
+	Leader LeaderElector `json:"-"`
+
+	ShutdownTimeout time.Duration `json:"-"` // Bounds the shutdown triggered by Ctx cancellation (default DefaultShutdownTimeout)
+	DrainConnection bool          `json:"-"` // Drain the NATS connection on Shutdown
 }
 
 // Validate checks that all required fields are present
@@ -67,13 +110,21 @@ func (sc *ServiceConfig) Validate() error {
 
 // StartService initializes and starts the NATS microservice
 func StartService(config *ServiceConfig) (*Service, error) {
-	svc := &Service{}
+	svc := &Service{
+		done:          make(chan struct{}),
+		pools:         make(map[string]*workerPool),
+		subscriptions: make(map[string]*subscription),
+		jobs:          make(map[string]*job),
+		handlerKinds:  make(map[string]string),
+	}
 	// Validate configuration
 	err := config.Validate()
 	if err != nil {
 		return svc, fmt.Errorf("invalid service config: %w", err)
 	}
 	svc.config = config
+	svc.middlewares = append(svc.middlewares, config.Middlewares...)
+	svc.handlerCtx, svc.cancelHandlers = context.WithCancel(context.WithoutCancel(config.Ctx))
 
 	if !svc.config.Nc.IsConnected() {
 		return svc, errors.New("nats not connected")
@@ -94,6 +145,7 @@ func StartService(config *ServiceConfig) (*Service, error) {
 		Description:        svc.config.Description,
 		Metadata:           svc.config.Metadata,
 		QueueGroupDisabled: true,
+		StatsHandler:       svc.statsHandler,
 	}
 
 	// Create micro service
@@ -102,14 +154,22 @@ func StartService(config *ServiceConfig) (*Service, error) {
 		return svc, err
 	}
 
+	// Shutdown when the service context is cancelled
+	go svc.watchContext()
+
 	return svc, err
 }
 
-// Stop gracefully stops the NATS microservice
+// Stop stops the NATS microservice immediately, without waiting for in-flight requests.
+// Use Shutdown for a graceful stop.
 func (svc *Service) Stop() error {
 	if svc.microSvc == nil {
 		return nil // Nothing to stop
 	}
+	svc.markDone()
+	svc.stopConsumers()
+	svc.stopHandlers()
+	defer svc.stopPools()
 	return svc.microSvc.Stop()
 }
 
@@ -142,6 +202,14 @@ func (svc *Service) Logger() *slog.Logger {
 	return svc.config.Logger
 }
 
+// ID returns the unique identifier of the service instance, sent in the InstanceIDHeader of the responses
+func (svc *Service) ID() string {
+	if svc.microSvc == nil {
+		return ""
+	}
+	return svc.microSvc.Info().ID
+}
+
 func (svc *Service) AddEndpoint(endpointer Endpointer) error {
 	if endpointer == nil {
 		return errors.New("nil endpointer")
@@ -158,6 +226,9 @@ func (svc *Service) AddEndpoint(endpointer Endpointer) error {
 	if config.Name == "" {
 		return errors.New("missing endpoint name")
 	}
+	if config.LeaderOnly && svc.config.Leader == nil {
+		return fmt.Errorf("leader-only endpoint %s requires a service leader elector", config.Name)
+	}
 
 	// Build endpoint options
 	var opts []micro.EndpointOpt
@@ -179,10 +250,187 @@ func (svc *Service) AddEndpoint(endpointer Endpointer) error {
 		opts = append(opts, micro.WithEndpointQueueGroupDisabled())
 	}
 
+	// Configure worker pool
+	var pool *workerPool
+	if config.MaxConcurrency > 0 {
+		pool = newWorkerPool(config.MaxConcurrency, config.QueueSize)
+	}
+
+	handler := svc.handler(endpointer, config, pool)
+
+	svc.mu.Lock()
+	reserved, err := svc.reserveName(config.Name, kindEndpoint)
+	svc.mu.Unlock()
+	if err != nil {
+		if pool != nil {
+			pool.stop()
+		}
+		return err
+	}
+
 	if svc.config.Group != "" {
-		return svc.microSvc.AddGroup(svc.config.Group).AddEndpoint(config.Name, endpointer, opts...)
+		err = svc.microSvc.AddGroup(svc.config.Group).AddEndpoint(config.Name, handler, opts...)
 	} else {
-		return svc.microSvc.AddEndpoint(config.Name, endpointer, opts...)
+		err = svc.microSvc.AddEndpoint(config.Name, handler, opts...)
+	}
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if err != nil {
+		if reserved {
+			delete(svc.handlerKinds, config.Name)
+		}
+		if pool != nil {
+			pool.stop()
+		}
+		return err
+	}
+	if pool != nil {
+		svc.pools[config.Name] = pool
+	}
+	return nil
+}
+
+// kindEndpoint is the kind of the request/reply endpoints in Service.handlerKinds
+const kindEndpoint = "endpoint"
+
+// reserveName records that name is used by a handler of kind (kindEndpoint, KindSubscriber or KindJob).
+// It returns an error if a handler of another kind uses name, as the custom stats of the handlers are
+// looked up by name, and whether name was not used yet. svc.mu must be locked.
+func (svc *Service) reserveName(name, kind string) (bool, error) {
+	used, exists := svc.handlerKinds[name]
+	if exists && used != kind {
+		return false, fmt.Errorf("%s %s already exists", used, name)
+	}
+	svc.handlerKinds[name] = kind
+	return !exists, nil
+}
+
+// Use appends service-wide middlewares, applied to endpoints added afterward
+func (svc *Service) Use(middlewares ...Middleware) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.middlewares = append(svc.middlewares, middlewares...)
+}
+
+// handler builds the micro.Handler dispatching requests to endpointer through the middleware chain.
+// Each request is wrapped with a context derived from the handler context of the service, carrying
+// the endpoint information, the request ID and a logger with service, endpoint and request ID attributes.
+// The responses carry the instance ID of the service in the InstanceIDHeader.
+// The request context is bounded by the endpoint timeout and the client deadline header.
+// If pool is not nil, requests are handled by the pool workers and rejected with
+// a retryable "503 overloaded" error when its queue is full; the pool reports their processing time and errors.
+// Rejections go through the tracing and request ID middlewares, echoing the request ID and trace context.
+// Panics are recovered with Recover inside these middlewares, on both the pooled and the direct paths.
+func (svc *Service) handler(endpointer Endpointer, config *EndpointConfig, pool *workerPool) micro.Handler {
+	var outer []Middleware
+	if svc.config.Tracer != nil {
+		outer = append(outer, tracingMiddleware(svc.config.Tracer))
+	}
+	outer = append(outer, RequestID())
+	svc.mu.RLock()
+	serviceMiddlewares := svc.middlewares
+	svc.mu.RUnlock()
+	middlewares := make([]Middleware, 0, len(outer)+len(serviceMiddlewares)+len(config.Middlewares)+3)
+	middlewares = append(middlewares, outer...)
+	middlewares = append(middlewares, Recover())
+	if svc.config.Authenticator != nil {
+		middlewares = append(middlewares, authMiddleware(svc.config.Authenticator, config))
+	}
+	if config.LeaderOnly {
+		middlewares = append(middlewares, leaderMiddleware(svc.config.Leader))
+	}
+	middlewares = append(middlewares, serviceMiddlewares...)
+	middlewares = append(middlewares, config.Middlewares...)
+	chain := Chain(endpointer, middlewares...)
+
+	info := &endpointInfo{
+		service:  svc,
+		endpoint: endpointer,
+		config:   config,
+	}
+	log := svc.Logger().With(
+		"service", svc.config.Name,
+		"endpoint", config.Name,
+	)
+	instanceID := svc.ID()
+
+	// unavailable answers a request with a retryable "503 <description>" error
+	unavailable := func(request micro.Request, description string) {
+		Chain(micro.HandlerFunc(func(request micro.Request) {
+			RespondError(request, NewServiceError(CodeUnavailable, description).WithRetryable(true))
+		}), outer...).Handle(request)
+	}
+
+	return micro.HandlerFunc(func(request micro.Request) {
+		ctx := contextWithEndpointInfo(svc.handlerCtx, info)
+		ctx = ContextWithLogger(ctx, log)
+		newRequest := func(request micro.Request) *serviceRequest {
+			req := newServiceRequest(ctx, request)
+			SetResponseHeader(req, InstanceIDHeader, instanceID)
+			return req
+		}
+
+		if !svc.beginRequest() {
+			unavailable(newRequest(request), "service shutting down")
+			return
+		}
+
+		// The deadline is counted from the reception, the time spent in the pool queue included
+		deadline, _ := requestDeadline(request.Headers(), config.Timeout)
+		if pool == nil {
+			defer svc.endRequest()
+			handleWithDeadline(chain, newRequest(request), deadline)
+			return
+		}
+
+		submitted := pool.submit(newRequest(detachRequest(svc.Nc(), request)), func(req *serviceRequest) {
+			defer svc.endRequest()
+			handleWithDeadline(chain, req, deadline)
+		}, func(req *serviceRequest) {
+			defer svc.endRequest()
+			unavailable(req, "service shutting down")
+		})
+		if !submitted {
+			svc.endRequest()
+			unavailable(newRequest(request), "overloaded")
+		}
+	})
+}
+
+// EndpointStatsData is the custom data reported for each endpoint in the service stats ($SRV.STATS)
+type EndpointStatsData struct {
+	Workers    *WorkerStats     `json:"workers,omitempty"`    // Worker pool state, for endpoints with MaxConcurrency set
+	Subscriber *SubscriberStats `json:"subscriber,omitempty"` // Handler failures, for subscribers
+	Job        *JobStats        `json:"job,omitempty"`        // Run results, for scheduled jobs
+}
+
+// statsHandler returns the custom stats data of an endpoint, subscriber or job
+func (svc *Service) statsHandler(endpoint *micro.Endpoint) any {
+	data := EndpointStatsData{}
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	if pool, ok := svc.pools[endpoint.Name]; ok {
+		data.Workers = pool.stats()
+	}
+	data.Subscriber = svc.subscriberStats(endpoint.Name)
+	data.Job = svc.jobStats(endpoint.Name)
+	return data
+}
+
+// Stats returns the service statistics, including the custom endpoint data (see EndpointStatsData)
+func (svc *Service) Stats() micro.Stats {
+	if svc.microSvc == nil {
+		return micro.Stats{}
+	}
+	return svc.microSvc.Stats()
+}
+
+// stopPools stops the endpoint worker pools
+func (svc *Service) stopPools() {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	for _, pool := range svc.pools {
+		pool.stop()
 	}
 }
 
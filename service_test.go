@@ -0,0 +1,80 @@
+package natsservice
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/natstools"
+)
+
+// testEndpoint is a configurable endpoint for testing
+type testEndpoint struct {
+	Endpoint
+	config *EndpointConfig
+	handle func(request micro.Request)
+}
+
+func (e *testEndpoint) Config() *EndpointConfig {
+	return e.config
+}
+
+func (e *testEndpoint) Handle(request micro.Request) {
+	e.handle(request)
+}
+
+// startTestService starts an embedded NATS server and a service named "test" in group "test".
+// It returns the service and the connection to use for requests.
+func startTestService(t *testing.T, configure func(*ServiceConfig)) (*Service, *nats.Conn) {
+	t.Helper()
+	srv, cleanup := natstools.TestServer(t)
+	t.Cleanup(cleanup)
+
+	config := &ServiceConfig{
+		Ctx:     context.Background(),
+		Nc:      srv.Connection(),
+		Logger:  slog.Default(),
+		Name:    "test",
+		Group:   "test",
+		Version: "0.0.1",
+	}
+	if configure != nil {
+		configure(config)
+	}
+	svc, err := StartService(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { svc.Stop() })
+
+	// requests share the service connection, so endpoint subscriptions are always registered first
+	return svc, srv.Connection()
+}
+
+func TestService_HandlerNamesUnique(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, _ := startTestService(t, nil)
+	noop := func(ctx context.Context) error { return nil }
+	require.NoError(t, svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "work"},
+		handle: func(request micro.Request) {},
+	}))
+	require.NoError(t, svc.AddJob("cleanup", "@every 1h", noop))
+
+	// The stats of the handlers are looked up by name : a name is used by a single kind of handler
+	assert.ErrorContains(svc.AddJob("work", "@every 1h", noop), "endpoint work already exists")
+	err := svc.AddSubscriber(NewSubscriber("work", "events.>", func(ctx context.Context, msg *nats.Msg) error { return nil }))
+	assert.ErrorContains(err, "endpoint work already exists")
+	err = svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "cleanup"},
+		handle: func(request micro.Request) {},
+	})
+	assert.ErrorContains(err, "job cleanup already exists")
+
+	data := svc.statsHandler(&micro.Endpoint{Name: "work"}).(EndpointStatsData)
+	assert.Nil(data.Job)
+	assert.Nil(data.Subscriber)
+}
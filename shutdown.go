@@ -0,0 +1,153 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultShutdownTimeout bounds the automatic shutdown triggered by the cancellation of ServiceConfig.Ctx
+const DefaultShutdownTimeout = 10 * time.Second
+
+// ShutdownHook is called during Service.Shutdown, after in-flight requests have completed
+type ShutdownHook func(ctx context.Context) error
+
+// OnShutdown registers a hook called by Shutdown. Hooks run in reverse registration order.
+func (svc *Service) OnShutdown(hook ShutdownHook) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	svc.shutdownHooks = append(svc.shutdownHooks, hook)
+}
+
+// Shutdown gracefully stops the service :
+// - stops accepting new requests, requests still delivered are answered with a retryable "503 service shutting down"
+// - stops consuming JetStream messages, messages still delivered are negatively acknowledged
+// - waits for in-flight handler invocations to finish, across all endpoints, then cancels the context of those still running
+// - runs the shutdown hooks in reverse registration order
+// - drains the NATS connection if ServiceConfig.DrainConnection is set
+//
+// ctx bounds the whole shutdown, Shutdown returns ctx.Err() if in-flight requests did not complete in time.
+// Shutdown is called automatically when ServiceConfig.Ctx is cancelled; only the first call performs the shutdown.
+func (svc *Service) Shutdown(ctx context.Context) error {
+	svc.shutdownOnce.Do(func() {
+		svc.shutdownErr = svc.shutdown(ctx)
+		svc.markDone()
+	})
+	return svc.shutdownErr
+}
+
+func (svc *Service) shutdown(ctx context.Context) error {
+	var errs []error
+
+	// Stop accepting new requests
+	if svc.microSvc != nil {
+		if err := svc.microSvc.Stop(); err != nil {
+			errs = append(errs, fmt.Errorf("stop micro service: %w", err))
+		}
+	}
+	svc.stopConsumers()
+	svc.mu.Lock()
+	svc.closing = true
+	hooks := svc.shutdownHooks
+	svc.mu.Unlock()
+
+	// Wait for in-flight requests
+	inflightDone := make(chan struct{})
+	go func() {
+		svc.inflight.Wait()
+		close(inflightDone)
+	}()
+	select {
+	case <-inflightDone:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("waiting for in-flight requests: %w", ctx.Err()))
+	}
+	svc.stopHandlers() // aborts the requests still running
+	svc.stopPools()
+
+	// Run hooks in reverse order
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook: %w", err))
+		}
+	}
+
+	if svc.config.DrainConnection {
+		if err := svc.drainConnection(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// drainConnection drains the NATS connection and waits for it to be closed
+func (svc *Service) drainConnection(ctx context.Context) error {
+	nc := svc.config.Nc
+	if nc.IsClosed() {
+		return nil
+	}
+	if err := nc.Drain(); err != nil {
+		return fmt.Errorf("drain nats connection: %w", err)
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !nc.IsClosed() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("drain nats connection: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// beginRequest registers an in-flight request, it returns false if the service is shutting down
+func (svc *Service) beginRequest() bool {
+	svc.mu.RLock()
+	defer svc.mu.RUnlock()
+	if svc.closing {
+		return false
+	}
+	svc.inflight.Add(1)
+	return true
+}
+
+// endRequest unregisters an in-flight request
+func (svc *Service) endRequest() {
+	svc.inflight.Done()
+}
+
+// stopHandlers cancels the context of the running handler invocations
+func (svc *Service) stopHandlers() {
+	if svc.cancelHandlers != nil {
+		svc.cancelHandlers()
+	}
+}
+
+// markDone signals the service is stopped
+func (svc *Service) markDone() {
+	svc.doneOnce.Do(func() {
+		close(svc.done)
+	})
+}
+
+// watchContext shuts the service down when the service context is cancelled
+func (svc *Service) watchContext() {
+	select {
+	case <-svc.done:
+		return
+	case <-svc.config.Ctx.Done():
+	}
+
+	timeout := svc.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := svc.Shutdown(ctx); err != nil {
+		svc.Logger().Error("service shutdown failed", "error", err)
+	}
+}
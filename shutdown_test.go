@@ -0,0 +1,166 @@
+package natsservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_ShutdownWaitsForInflight(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	started := make(chan struct{})
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "slow"},
+		handle: func(request micro.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			request.Respond([]byte("done"))
+		},
+	})
+	require.NoError(t, err)
+
+	var hooks []string
+	svc.OnShutdown(func(ctx context.Context) error {
+		hooks = append(hooks, "first")
+		return nil
+	})
+	svc.OnShutdown(func(ctx context.Context) error {
+		hooks = append(hooks, "second")
+		return nil
+	})
+
+	replies := make(chan string, 1)
+	go func() {
+		msg, err := nc.Request("test.slow", nil, time.Second)
+		if err != nil {
+			replies <- err.Error()
+			return
+		}
+		replies <- string(msg.Data)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(svc.Shutdown(ctx))
+	assert.Equal([]string{"second", "first"}, hooks)
+	assert.Equal("done", <-replies)
+
+	// Shutdown is performed once
+	assert.NoError(svc.Shutdown(ctx))
+	assert.Len(hooks, 2)
+}
+
+func TestService_ShutdownTimeout(t *testing.T) {
+	svc, nc := startTestService(t, nil)
+	started := make(chan struct{})
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "stuck"},
+		handle: func(request micro.Request) {
+			close(started)
+			time.Sleep(500 * time.Millisecond)
+			request.Respond(nil)
+		},
+	})
+	require.NoError(t, err)
+
+	go nc.Request("test.stuck", nil, time.Second)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, svc.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func TestService_ShutdownOnContextCancel(t *testing.T) {
+	serviceCtx, cancelService := context.WithCancel(context.Background())
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Ctx = serviceCtx
+		config.DrainConnection = true
+	})
+	hookCalled := make(chan struct{})
+	svc.OnShutdown(func(ctx context.Context) error {
+		close(hookCalled)
+		return nil
+	})
+
+	cancelService()
+	select {
+	case <-hookCalled:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown not triggered by context cancellation")
+	}
+	assert.Eventually(t, nc.IsClosed, time.Second, 10*time.Millisecond)
+}
+
+type testContextKey struct{}
+
+func TestService_ShutdownOnContextCancelDrainsRequests(t *testing.T) {
+	assert := assert.New(t)
+	serviceCtx, cancelService := context.WithCancel(context.WithValue(context.Background(), testContextKey{}, "value"))
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Ctx = serviceCtx
+	})
+	started := make(chan struct{})
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "slow"},
+		handle: func(request micro.Request) {
+			ctx := RequestContext(request)
+			close(started)
+			time.Sleep(100 * time.Millisecond)
+			// The request context keeps the service context values, and is not cancelled with it
+			if ctx.Err() != nil || ctx.Value(testContextKey{}) != "value" {
+				request.Respond([]byte("cancelled"))
+				return
+			}
+			request.Respond([]byte("done"))
+		},
+	})
+	require.NoError(t, err)
+
+	replies := make(chan string, 1)
+	go func() {
+		msg, err := nc.Request("test.slow", nil, time.Second)
+		if err != nil {
+			replies <- err.Error()
+			return
+		}
+		replies <- string(msg.Data)
+	}()
+	<-started
+	cancelService()
+	assert.Equal("done", <-replies)
+}
+
+func TestService_ShutdownTimeoutCancelsRequests(t *testing.T) {
+	svc, nc := startTestService(t, nil)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "stuck"},
+		handle: func(request micro.Request) {
+			close(started)
+			<-RequestContext(request).Done()
+			close(cancelled)
+		},
+	})
+	require.NoError(t, err)
+
+	go nc.Request("test.stuck", nil, time.Second)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, svc.Shutdown(ctx), context.DeadlineExceeded)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("request not cancelled after the shutdown timeout")
+	}
+}
@@ -0,0 +1,284 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/telemac/natsservice/pkg/tracing"
+)
+
+// Metadata describing the kind of a service handler in $SRV.INFO
+const (
+	KindMetadataKey = "kind"       // Endpoint metadata key holding the handler kind
+	KindSubscriber  = "subscriber" // Value of KindMetadataKey for subscribers
+)
+
+// SubscriberConfig holds configuration for event subscribers
+type SubscriberConfig struct {
+	Name       string            `json:"name"`                  // Subscriber name, unique within the service
+	Subject    string            `json:"subject"`               // Subscribed subject, wildcards allowed
+	QueueGroup string            `json:"queue_group,omitempty"` // Queue group, every instance receives all messages if empty
+	Metadata   map[string]string `json:"metadata,omitempty"`    // Subscriber metadata
+}
+
+// Subscriber handles fire-and-forget messages, e.g. events sent with Publish.
+// A returned error is logged and counted in the subscriber stats, no response is sent.
+type Subscriber interface {
+	Config() *SubscriberConfig
+	HandleMsg(ctx context.Context, msg *nats.Msg) error
+}
+
+// MsgHandler handles a message received by a subscriber
+type MsgHandler func(ctx context.Context, msg *nats.Msg) error
+
+// SubscriberStats reports the message handling failures of a subscriber.
+// Received messages and processing time are reported by the endpoint stats.
+type SubscriberStats struct {
+	Errors    uint64 `json:"errors"`               // Messages whose handler failed or panicked
+	LastError string `json:"last_error,omitempty"` // Last handler error
+}
+
+// subscription tracks the stats of a subscriber added to the service
+type subscription struct {
+	mu    sync.Mutex
+	stats SubscriberStats
+}
+
+func (s *subscription) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Errors++
+	s.stats.LastError = err.Error()
+}
+
+func (s *subscription) snapshot() *SubscriberStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	return &stats
+}
+
+// AddSubscriber subscribes a subscriber to its subject until the service is stopped.
+//
+// Subscribers are registered as micro endpoints with the "kind: subscriber" metadata, so their subject
+// and queue group appear in $SRV.INFO and their message count and processing time in $SRV.STATS.
+// Messages are handled with a context carrying the values of the service context, the request ID
+// and trace context propagated by the publisher. Panics are recovered and counted as errors.
+func (svc *Service) AddSubscriber(subscriber Subscriber) error {
+	if subscriber == nil {
+		return errors.New("nil subscriber")
+	}
+	if svc.microSvc == nil {
+		return errors.New("micro service not initialized")
+	}
+	config := subscriber.Config()
+	if config == nil {
+		return errors.New("missing subscriber config")
+	}
+	if config.Name == "" {
+		return errors.New("missing subscriber name")
+	}
+	if config.Subject == "" {
+		return errors.New("missing subscriber subject")
+	}
+
+	svc.mu.Lock()
+	if _, exists := svc.subscriptions[config.Name]; exists {
+		svc.mu.Unlock()
+		return fmt.Errorf("subscriber %s already exists", config.Name)
+	}
+	if _, err := svc.reserveName(config.Name, KindSubscriber); err != nil {
+		svc.mu.Unlock()
+		return err
+	}
+	sub := &subscription{}
+	svc.subscriptions[config.Name] = sub
+	svc.mu.Unlock()
+
+	metadata := maps.Clone(config.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[KindMetadataKey] = KindSubscriber
+
+	opts := []micro.EndpointOpt{
+		micro.WithEndpointSubject(config.Subject),
+		micro.WithEndpointMetadata(metadata),
+	}
+	if config.QueueGroup != "" {
+		opts = append(opts, micro.WithEndpointQueueGroup(config.QueueGroup))
+	} else {
+		opts = append(opts, micro.WithEndpointQueueGroupDisabled())
+	}
+
+	err := svc.microSvc.AddEndpoint(config.Name, svc.subscriberHandler(subscriber, config, sub), opts...)
+	if err != nil {
+		svc.mu.Lock()
+		delete(svc.subscriptions, config.Name)
+		delete(svc.handlerKinds, config.Name)
+		svc.mu.Unlock()
+	}
+	return err
+}
+
+// subscriberHandler builds the micro.Handler dispatching messages to subscriber
+func (svc *Service) subscriberHandler(subscriber Subscriber, config *SubscriberConfig, sub *subscription) micro.Handler {
+	log := svc.Logger().With(
+		"service", svc.config.Name,
+		"subscriber", config.Name,
+	)
+
+	return micro.HandlerFunc(func(request micro.Request) {
+		if !svc.beginRequest() {
+			return
+		}
+		defer svc.endRequest()
+
+		msg := &nats.Msg{
+			Subject: request.Subject(),
+			Reply:   request.Reply(),
+			Data:    request.Data(),
+			Header:  nats.Header(request.Headers()),
+		}
+
+		ctx, span := svc.messageContext(log, config.Name, msg)
+		if span != nil {
+			defer span.End()
+		}
+
+		err := handleMsg(ctx, subscriber, msg)
+		if err != nil {
+			LoggerFromContext(ctx).Error("subscriber failed", "error", err)
+			sub.failed(err)
+			if span != nil {
+				span.SetError(err.Error())
+			}
+		}
+	})
+}
+
+// messageContext returns the context used to handle a message received by a subscriber or consumer.
+// It is derived from the handler context of the service and carries the request ID and trace context propagated
+// by the publisher, and a logger with the message subject. If the service has a Tracer, a consumer span
+// named name is started and returned.
+func (svc *Service) messageContext(log *slog.Logger, name string, msg *nats.Msg) (context.Context, *tracing.Span) {
+	ctx := svc.handlerCtx
+	log = log.With("subject", msg.Subject)
+	if id := msg.Header.Get(RequestIDHeader); id != "" {
+		ctx = ContextWithRequestID(ctx, id)
+		log = log.With("request_id", id)
+	}
+	var span *tracing.Span
+	if svc.config.Tracer != nil {
+		ctx, span = svc.config.Tracer.Start(tracing.Extract(ctx, msg.Header), name, tracing.SpanKindConsumer)
+		span.SetAttribute("messaging.destination", msg.Subject)
+		spanContext := span.Context()
+		log = log.With("trace_id", spanContext.TraceID.String(), "span_id", spanContext.SpanID.String())
+	}
+	return ContextWithLogger(ctx, log), span
+}
+
+// handleMsg calls the subscriber, converting panics to errors
+func handleMsg(ctx context.Context, subscriber Subscriber, msg *nats.Msg) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+	}()
+	return subscriber.HandleMsg(ctx, msg)
+}
+
+// subscriberStats returns the stats of the subscriber named name, or nil
+func (svc *Service) subscriberStats(name string) *SubscriberStats {
+	if sub, ok := svc.subscriptions[name]; ok {
+		return sub.snapshot()
+	}
+	return nil
+}
+
+var _ Subscriber = (*FuncSubscriber)(nil)
+
+// FuncSubscriber is a subscriber calling a MsgHandler
+type FuncSubscriber struct {
+	config  SubscriberConfig
+	handler MsgHandler
+}
+
+// NewSubscriber creates a subscriber named name, calling handler with the messages published on subject
+func NewSubscriber(name, subject string, handler MsgHandler) *FuncSubscriber {
+	return &FuncSubscriber{
+		config:  SubscriberConfig{Name: name, Subject: subject},
+		handler: handler,
+	}
+}
+
+// WithQueueGroup sets the subscriber queue group, messages are delivered to one member of the group
+func (s *FuncSubscriber) WithQueueGroup(queueGroup string) *FuncSubscriber {
+	s.config.QueueGroup = queueGroup
+	return s
+}
+
+// Config returns the subscriber configuration
+func (s *FuncSubscriber) Config() *SubscriberConfig {
+	return &s.config
+}
+
+// HandleMsg calls the subscriber handler
+func (s *FuncSubscriber) HandleMsg(ctx context.Context, msg *nats.Msg) error {
+	return s.handler(ctx, msg)
+}
+
+var _ Subscriber = (*TypedSubscription[struct{}])(nil)
+
+// TypedSubscription is a subscriber decoding JSON messages into T
+type TypedSubscription[T any] struct {
+	config  SubscriberConfig
+	handler func(ctx context.Context, event *T) error
+}
+
+// TypedSubscriber creates a subscriber named name, calling handler with the decoded messages published on subject.
+// Messages that cannot be decoded, or fail validation if *T implements Validator, are counted as errors.
+//
+// Usage:
+//
+//	subscriber := natsservice.TypedSubscriber("user-created", "users.created", func(ctx context.Context, event *UserCreated) error {
+//		return mailer.SendWelcome(ctx, event.Email)
+//	}).WithQueueGroup("mailer")
+func TypedSubscriber[T any](name, subject string, handler func(ctx context.Context, event *T) error) *TypedSubscription[T] {
+	return &TypedSubscription[T]{
+		config:  SubscriberConfig{Name: name, Subject: subject},
+		handler: handler,
+	}
+}
+
+// WithQueueGroup sets the subscriber queue group, messages are delivered to one member of the group
+func (s *TypedSubscription[T]) WithQueueGroup(queueGroup string) *TypedSubscription[T] {
+	s.config.QueueGroup = queueGroup
+	return s
+}
+
+// Config returns the subscriber configuration
+func (s *TypedSubscription[T]) Config() *SubscriberConfig {
+	return &s.config
+}
+
+// HandleMsg decodes the message and calls the typed handler
+func (s *TypedSubscription[T]) HandleMsg(ctx context.Context, msg *nats.Msg) error {
+	var event T
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return fmt.Errorf("invalid message format: %w", err)
+	}
+	if validator, ok := any(&event).(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return fmt.Errorf("invalid message: %w", err)
+		}
+	}
+	return s.handler(ctx, &event)
+}
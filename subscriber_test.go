@@ -0,0 +1,107 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type userCreated struct {
+	Name string `json:"name"`
+}
+
+func (e *userCreated) Validate() error {
+	if e.Name == "" {
+		return errors.New("missing name")
+	}
+	return nil
+}
+
+func TestSubscriber_Typed(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	received := make(chan string, 1)
+	ids := make(chan string, 1)
+	err := svc.AddSubscriber(TypedSubscriber("user-created", "events.user.created", func(ctx context.Context, event *userCreated) error {
+		if event.Name == "panic" {
+			panic("boom")
+		}
+		ids <- RequestIDFromContext(ctx)
+		received <- event.Name
+		return nil
+	}).WithQueueGroup("workers"))
+	require.NoError(t, err)
+
+	// Duplicate names are rejected
+	err = svc.AddSubscriber(NewSubscriber("user-created", "events.other", func(ctx context.Context, msg *nats.Msg) error { return nil }))
+	assert.Error(err)
+
+	ctx := ContextWithRequestID(context.Background(), "event-id")
+	require.NoError(t, PublishWithContext(ctx, nc, "events.user.created", userCreated{Name: "alice"}))
+	select {
+	case name := <-received:
+		assert.Equal("alice", name)
+		assert.Equal("event-id", <-ids)
+	case <-time.After(time.Second):
+		t.Fatal("event not received")
+	}
+
+	// Invalid, undecodable and panicking messages are counted as errors
+	require.NoError(t, Publish(nc, "events.user.created", userCreated{}))
+	require.NoError(t, nc.Publish("events.user.created", []byte("not json")))
+	require.NoError(t, Publish(nc, "events.user.created", userCreated{Name: "panic"}))
+
+	assert.Eventually(func() bool {
+		stats := svc.subscriberStats("user-created")
+		return stats != nil && stats.Errors == 3 && svc.Stats().Endpoints[0].NumRequests == 4
+	}, time.Second, 10*time.Millisecond)
+
+	// Subscriber and its stats are exposed in $SRV.INFO and $SRV.STATS
+	info, err := nc.Request("$SRV.INFO.test", nil, time.Second)
+	require.NoError(t, err)
+	assert.Contains(string(info.Data), `"subject":"events.user.created"`)
+	assert.Contains(string(info.Data), `"queue_group":"workers"`)
+	assert.Contains(string(info.Data), `"kind":"subscriber"`)
+
+	stats := svc.Stats()
+	require.Len(t, stats.Endpoints, 1)
+	var data EndpointStatsData
+	require.NoError(t, json.Unmarshal(stats.Endpoints[0].Data, &data))
+	require.NotNil(t, data.Subscriber)
+	assert.Equal(uint64(3), data.Subscriber.Errors)
+	assert.Equal("subscriber panicked: boom", data.Subscriber.LastError)
+}
+
+func TestSubscriber_Stop(t *testing.T) {
+	svc, nc := startTestService(t, nil)
+	received := make(chan *nats.Msg, 2)
+	err := svc.AddSubscriber(NewSubscriber("raw", "events.>", func(ctx context.Context, msg *nats.Msg) error {
+		received <- msg
+		return nil
+	}))
+	require.NoError(t, err)
+
+	require.NoError(t, nc.Publish("events.a", []byte("a")))
+	select {
+	case msg := <-received:
+		assert.Equal(t, "a", string(msg.Data))
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	require.NoError(t, svc.Stop())
+	require.NoError(t, nc.Publish("events.b", []byte("b")))
+	require.NoError(t, nc.Flush())
+	select {
+	case <-received:
+		t.Fatal("message received after Stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
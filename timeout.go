@@ -0,0 +1,89 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// TimeoutHeader carries the time left to the client to get the response of a request, in milliseconds.
+// It is set by the client helpers from the request context deadline. A relative timeout does not depend
+// on the client and service clocks agreeing, the service computes the deadline from the reception time.
+const TimeoutHeader = "X-Request-Timeout"
+
+// RequestTimeoutError returns the error sent when a request deadline expires before the handler responds.
+// It returns a new error on each call, match it with errors.Is(err, RequestTimeoutError()).
+func RequestTimeoutError() *ServiceError {
+	return NewServiceError(CodeTimeout, "request timeout")
+}
+
+// setDeadlineHeader sets the timeout header of msg from the deadline of ctx, if any
+func setDeadlineHeader(ctx context.Context, msg *nats.Msg) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+	// Rounded up, so that a deadline not expired yet is not sent as expired
+	milliseconds := (remaining + time.Millisecond - 1) / time.Millisecond
+	msg.Header.Set(TimeoutHeader, strconv.FormatInt(int64(milliseconds), 10))
+}
+
+// requestDeadline returns the earliest of the client timeout header and the endpoint timeout,
+// counted from now, the reception of the request
+func requestDeadline(headers micro.Headers, timeout time.Duration) (time.Time, bool) {
+	now := time.Now()
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = now.Add(timeout)
+	}
+	if value := headers.Get(TimeoutHeader); value != "" {
+		milliseconds, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && milliseconds >= 0 {
+			clientDeadline := now.Add(time.Duration(milliseconds) * time.Millisecond)
+			if deadline.IsZero() || clientDeadline.Before(deadline) {
+				deadline = clientDeadline
+			}
+		}
+	}
+	return deadline, !deadline.IsZero()
+}
+
+// handleWithDeadline runs handler bounded by deadline (see requestDeadline), a zero deadline meaning none.
+// When the deadline expires, the request context is cancelled and a timeout error is sent if the handler
+// did not respond yet, or if the deadline expired before the handler ran (e.g. while queued).
+// The responses sent by the handler afterward are dropped. handleWithDeadline returns when the handler returns.
+func handleWithDeadline(handler micro.Handler, request *serviceRequest, deadline time.Time) {
+	if deadline.IsZero() {
+		handler.Handle(request)
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(request.ctx, deadline)
+	defer cancel()
+	request.ctx = ctx
+
+	expired := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(expired)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			request.expire()
+		}
+	})
+	if ctx.Err() == nil {
+		handler.Handle(request)
+	}
+	if !stop() {
+		<-expired // the timeout error is sent before the request is recorded as handled
+	}
+}
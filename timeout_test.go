@@ -0,0 +1,172 @@
+package natsservice
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout_EndpointTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "slow", Timeout: 50 * time.Millisecond},
+		handle: func(request micro.Request) {
+			<-RequestContext(request).Done()
+		},
+	})
+	require.NoError(t, err)
+
+	msg, err := nc.Request("test.slow", nil, time.Second)
+	require.NoError(t, err)
+	assert.Equal(CodeTimeout, msg.Header.Get(micro.ErrorCodeHeader))
+	assert.Equal("request timeout", msg.Header.Get(micro.ErrorHeader))
+}
+
+func TestTimeout_HandlerIgnoringContext(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	release := make(chan struct{})
+	late := make(chan error, 1)
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "busy", Timeout: 50 * time.Millisecond},
+		handle: func(request micro.Request) {
+			<-release
+			late <- request.Respond([]byte("late"))
+		},
+	})
+	require.NoError(t, err)
+
+	// The timeout error is sent when the deadline expires, not when the handler returns
+	start := time.Now()
+	msg, err := nc.Request("test.busy", nil, time.Second)
+	require.NoError(t, err)
+	assert.Less(time.Since(start), 500*time.Millisecond)
+	assert.Equal(CodeTimeout, msg.Header.Get(micro.ErrorCodeHeader))
+
+	// The late response is dropped
+	close(release)
+	select {
+	case err := <-late:
+		assert.ErrorIs(err, errRequestExpired)
+	case <-time.After(time.Second):
+		t.Fatal("handler not released")
+	}
+}
+
+func TestTimeout_ClientDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	cancelled := make(chan error, 1)
+	err := svc.AddEndpoint(TypedEndpoint("wait", func(ctx context.Context, req *struct{}) (*struct{}, error) {
+		_, hasDeadline := ctx.Deadline()
+		assert.True(hasDeadline)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	}))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = Request[struct{}, struct{}](ctx, nc, "test.wait", struct{}{})
+	assert.Error(err)
+
+	select {
+	case err := <-cancelled:
+		assert.True(errors.Is(err, context.DeadlineExceeded))
+	case <-time.After(time.Second):
+		t.Fatal("handler not cancelled")
+	}
+}
+
+func TestTimeout_QueuedRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	var handled atomic.Int32
+	release := make(chan struct{})
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "pooled", MaxConcurrency: 1, QueueSize: 1, Timeout: 100 * time.Millisecond},
+		handle: func(request micro.Request) {
+			if handled.Add(1) == 1 {
+				<-release
+			}
+			request.Respond([]byte("ok"))
+		},
+	})
+	require.NoError(t, err)
+
+	// The first request occupies the worker past the timeout of the queued one
+	go nc.Request("test.pooled", nil, time.Second)
+	assert.Eventually(func() bool { return handled.Load() == 1 }, time.Second, 5*time.Millisecond)
+	queued := make(chan *nats.Msg, 1)
+	go func() {
+		msg, err := nc.Request("test.pooled", nil, time.Second)
+		if err == nil {
+			queued <- msg
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+	close(release)
+
+	// The time spent in the queue counts : the handler does not run
+	select {
+	case msg := <-queued:
+		assert.Equal(CodeTimeout, msg.Header.Get(micro.ErrorCodeHeader))
+	case <-time.After(time.Second):
+		t.Fatal("queued request not answered")
+	}
+	assert.Equal(int32(1), handled.Load())
+}
+
+func TestTimeout_HandlerError(t *testing.T) {
+	svc, nc := startTestService(t, nil)
+	err := svc.AddEndpoint(TypedEndpoint("expired", func(ctx context.Context, req *struct{}) (*struct{}, error) {
+		return nil, context.DeadlineExceeded
+	}))
+	require.NoError(t, err)
+
+	_, err = Request[struct{}, struct{}](context.Background(), nc, "test.expired", struct{}{})
+	assert.ErrorIs(t, err, RequestTimeoutError())
+}
+
+func TestTimeout_RelativeHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	// The header carries the time left, rounded up to the millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Microsecond)
+	defer cancel()
+	msg := &nats.Msg{}
+	setDeadlineHeader(ctx, msg)
+	assert.Equal("2", msg.Header.Get(TimeoutHeader))
+	msg = &nats.Msg{}
+	setDeadlineHeader(context.Background(), msg)
+	assert.Empty(msg.Header.Get(TimeoutHeader))
+
+	// The deadline is counted from the reception, whatever the client clock
+	start := time.Now()
+	deadline, ok := requestDeadline(micro.Headers{TimeoutHeader: []string{"100"}}, time.Second)
+	require.True(t, ok)
+	assert.WithinDuration(start.Add(100*time.Millisecond), deadline, 50*time.Millisecond)
+	deadline, ok = requestDeadline(micro.Headers{TimeoutHeader: []string{"5000"}}, 200*time.Millisecond)
+	require.True(t, ok)
+	assert.WithinDuration(start.Add(200*time.Millisecond), deadline, 50*time.Millisecond)
+	_, ok = requestDeadline(micro.Headers{TimeoutHeader: []string{"invalid"}}, 0)
+	assert.False(ok)
+
+	// Each timeout error is a new value
+	err := RequestTimeoutError().WithRetryable(true)
+	assert.True(err.Retryable)
+	assert.False(RequestTimeoutError().Retryable)
+}
@@ -0,0 +1,36 @@
+package natsservice
+
+import (
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/telemac/natsservice/pkg/tracing"
+)
+
+// tracingMiddleware starts a server span for each endpoint invocation.
+// The parent span is extracted from the traceparent request header, the span is stored in the
+// request context (so outgoing requests made by the handler propagate it), and the trace and span IDs
+// are added to the request logger. Error responses mark the span as failed.
+func tracingMiddleware(tracer *tracing.Tracer) Middleware {
+	return func(next micro.Handler) micro.Handler {
+		return micro.HandlerFunc(func(request micro.Request) {
+			ctx := tracing.Extract(RequestContext(request), nats.Header(request.Headers()))
+			ctx, span := tracer.Start(ctx, RequestEndpointName(request), tracing.SpanKindServer)
+			defer span.End()
+			span.SetAttribute("messaging.destination", request.Subject())
+
+			spanContext := span.Context()
+			log := LoggerFromContext(ctx).With(
+				"trace_id", spanContext.TraceID.String(),
+				"span_id", spanContext.SpanID.String(),
+			)
+			ctx = ContextWithLogger(ctx, log)
+
+			next.Handle(WithRequestContext(request, ctx))
+
+			if code, description := responseError(request); code != "" {
+				span.SetAttribute("error.code", code)
+				span.SetError(description)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package natsservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telemac/natsservice/pkg/tracing"
+)
+
+func TestTracing_Propagation(t *testing.T) {
+	assert := assert.New(t)
+
+	exporter := tracing.NewMemoryExporter()
+	svc, nc := startTestService(t, func(config *ServiceConfig) {
+		config.Tracer = tracing.NewTracer("test", exporter)
+	})
+
+	// "front" calls "back", propagating the trace through the request context
+	err := svc.AddEndpoint(TypedEndpoint("front", func(ctx context.Context, req *struct{}) (*struct{}, error) {
+		return Request[struct{}, struct{}](ctx, nc, "test.back", struct{}{})
+	}))
+	require.NoError(t, err)
+	err = svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "back", MaxConcurrency: 1},
+		handle: func(request micro.Request) {
+			request.Error("409", "conflict", nil)
+		},
+	})
+	require.NoError(t, err)
+
+	// Client span is the root of the trace
+	client := tracing.NewTracer("client", exporter)
+	ctx, span := client.Start(context.Background(), "call", tracing.SpanKindClient)
+	_, err = Request[struct{}, struct{}](ctx, nc, "test.front", struct{}{})
+	assert.ErrorIs(err, NewServiceError("409", ""))
+	span.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 3)
+	back, front, call := spans[0], spans[1], spans[2]
+	assert.Equal("back", back.Name)
+	assert.Equal("front", front.Name)
+	assert.Equal(tracing.SpanKindServer, front.Kind)
+	assert.Equal(call.TraceID, front.TraceID)
+	assert.Equal(call.TraceID, back.TraceID)
+	assert.Equal(call.SpanID, front.ParentSpanID)
+	assert.Equal(front.SpanID, back.ParentSpanID)
+	assert.True(back.Error)
+	assert.Equal("409", back.Attributes["error.code"])
+	assert.Equal("test.back", back.Attributes["messaging.destination"])
+	assert.True(front.Error)
+	assert.Equal("409", front.Attributes["error.code"]) // service errors are forwarded
+}
@@ -0,0 +1,96 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/nats.go/micro"
+)
+
+// Validator is implemented by request types validating themselves after decoding
+type Validator interface {
+	Validate() error
+}
+
+// TypedHandler handles a decoded request and returns the response to encode.
+// Returning a *ServiceError sends its code and message to the client,
+// any other error is sent as "500 internal error".
+type TypedHandler[Req, Resp any] func(ctx context.Context, request *Req) (*Resp, error)
+
+var _ Endpointer = (*TypedEndpointer[struct{}, struct{}])(nil)
+
+// TypedEndpointer is an endpoint decoding JSON requests into Req and encoding Resp responses
+type TypedEndpointer[Req, Resp any] struct {
+	Endpoint
+	name      string
+	handler   TypedHandler[Req, Resp]
+	configure func(svc *Service, config *EndpointConfig)
+}
+
+// TypedEndpoint creates an endpoint named name calling handler with the decoded request.
+//
+// The endpoint :
+// - decodes the JSON request, replying "400 invalid request format" on failure
+// - calls Validate() if *Req implements Validator, replying 400 on failure
+// - calls handler with the request context
+// - encodes the response as JSON, or maps the returned error to an error response
+//
+// Usage:
+//
+//	endpoint := natsservice.TypedEndpoint("add", func(ctx context.Context, req *AddRequest) (*AddResponse, error) {
+//		return &AddResponse{Result: req.A + req.B}, nil
+//	})
+func TypedEndpoint[Req, Resp any](name string, handler TypedHandler[Req, Resp]) *TypedEndpointer[Req, Resp] {
+	return &TypedEndpointer[Req, Resp]{
+		name:    name,
+		handler: handler,
+	}
+}
+
+// WithConfig sets a function completing the endpoint configuration (metadata, queue group, ...).
+// It is called with the service the endpoint is added to.
+func (e *TypedEndpointer[Req, Resp]) WithConfig(configure func(svc *Service, config *EndpointConfig)) *TypedEndpointer[Req, Resp] {
+	e.configure = configure
+	return e
+}
+
+// Config returns the endpoint configuration
+func (e *TypedEndpointer[Req, Resp]) Config() *EndpointConfig {
+	config := &EndpointConfig{
+		Name: e.name,
+	}
+	if e.configure != nil {
+		e.configure(e.Service(), config)
+	}
+	return config
+}
+
+// Handle decodes the request, calls the typed handler and sends the response
+func (e *TypedEndpointer[Req, Resp]) Handle(request micro.Request) {
+	log := RequestLogger(request)
+
+	var req Req
+	if err := json.Unmarshal(request.Data(), &req); err != nil {
+		log.Warn("failed to unmarshal request", "error", err)
+		RespondError(request, NewServiceError(CodeBadRequest, "invalid request format"))
+		return
+	}
+
+	if validator, ok := any(&req).(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			log.Warn("invalid request", "error", err)
+			RespondError(request, Errorf(CodeBadRequest, "invalid request: %s", err))
+			return
+		}
+	}
+
+	resp, err := e.handler(RequestContext(request), &req)
+	if err != nil {
+		RespondError(request, err)
+		return
+	}
+
+	if err := request.RespondJSON(resp); err != nil {
+		log.Error("failed to send response", "error", err)
+	}
+}
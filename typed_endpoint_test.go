@@ -0,0 +1,83 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type divideRequest struct {
+	A float64 `json:"a"`
+	B float64 `json:"b"`
+}
+
+func (r *divideRequest) Validate() error {
+	if r.B == 0 {
+		return errors.New("division by zero")
+	}
+	return nil
+}
+
+type divideResponse struct {
+	Result float64 `json:"result"`
+}
+
+func TestTypedEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	endpoint := TypedEndpoint("divide", func(ctx context.Context, req *divideRequest) (*divideResponse, error) {
+		switch req.A {
+		case -1:
+			return nil, NewServiceError(CodeNotFound, "not found")
+		case -2:
+			return nil, errors.New("unexpected")
+		case -3:
+			panic("boom")
+		}
+		return &divideResponse{Result: req.A / req.B}, nil
+	}).WithConfig(func(svc *Service, config *EndpointConfig) {
+		config.Metadata = map[string]string{"service": svc.Config().Name}
+	})
+	require.NoError(t, svc.AddEndpoint(endpoint))
+	assert.Equal("test", endpoint.Config().Metadata["service"])
+
+	request := func(data string) (string, string, []byte) {
+		msg, err := nc.Request("test.divide", []byte(data), time.Second)
+		require.NoError(t, err)
+		return msg.Header.Get(micro.ErrorCodeHeader), msg.Header.Get(micro.ErrorHeader), msg.Data
+	}
+
+	code, _, data := request(`{"a":6,"b":3}`)
+	assert.Empty(code)
+	var resp divideResponse
+	assert.NoError(json.Unmarshal(data, &resp))
+	assert.Equal(2.0, resp.Result)
+
+	code, description, _ := request(`not json`)
+	assert.Equal(CodeBadRequest, code)
+	assert.Equal("invalid request format", description)
+
+	code, description, _ = request(`{"a":6,"b":0}`)
+	assert.Equal(CodeBadRequest, code)
+	assert.Equal("invalid request: division by zero", description)
+
+	code, description, _ = request(`{"a":-1,"b":1}`)
+	assert.Equal(CodeNotFound, code)
+	assert.Equal("not found", description)
+
+	code, description, _ = request(`{"a":-2,"b":1}`)
+	assert.Equal(CodeInternalError, code)
+	assert.Equal("internal error", description)
+
+	// Panics are recovered by the service
+	code, description, _ = request(`{"a":-3,"b":1}`)
+	assert.Equal(CodeInternalError, code)
+	assert.Equal("internal error", description)
+}
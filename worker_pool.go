@@ -0,0 +1,259 @@
+package natsservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// WorkerStats reports the state of an endpoint worker pool.
+// The micro stats of the endpoint only measure the dispatch of the requests to the pool:
+// the processing time and the errors of the requests handled by the workers are reported here.
+type WorkerStats struct {
+	MaxConcurrency        int           `json:"max_concurrency"`         // Number of workers
+	QueueSize             int           `json:"queue_size"`              // Capacity of the request queue
+	Active                int64         `json:"active"`                  // Workers currently handling a request
+	Queued                int           `json:"queued"`                  // Requests waiting for a worker
+	Rejected              uint64        `json:"rejected"`                // Requests rejected because the queue was full
+	NumRequests           int           `json:"num_requests"`            // Requests handled by the workers
+	NumErrors             int           `json:"num_errors"`              // Requests answered with an error, panics included
+	LastError             string        `json:"last_error,omitempty"`    // Last error response, as "code:description"
+	ProcessingTime        time.Duration `json:"processing_time"`         // Total time spent handling the requests
+	AverageProcessingTime time.Duration `json:"average_processing_time"` // Average time spent handling a request
+}
+
+// poolTask is a request waiting for a worker
+type poolTask struct {
+	request *serviceRequest
+	handle  func(request *serviceRequest)
+	reject  func(request *serviceRequest) // answers the request left in the queue when the pool stops
+}
+
+// workerPool runs endpoint handler invocations on a fixed number of goroutines
+type workerPool struct {
+	maxConcurrency int
+	queue          chan poolTask
+	quit           chan struct{}
+	stopMu         sync.Mutex // serializes the submissions and stop
+	stopped        bool
+	active         atomic.Int64
+	rejected       atomic.Uint64
+
+	mu             sync.Mutex // protects the fields below
+	numRequests    int
+	numErrors      int
+	lastError      string
+	processingTime time.Duration
+}
+
+func newWorkerPool(maxConcurrency, queueSize int) *workerPool {
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &workerPool{
+		maxConcurrency: maxConcurrency,
+		queue:          make(chan poolTask, queueSize),
+		quit:           make(chan struct{}),
+	}
+	for i := 0; i < maxConcurrency; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case <-p.quit:
+			return
+		case task := <-p.queue:
+			p.run(task)
+		}
+	}
+}
+
+// run handles the request of task, recording its processing time and error.
+// A panicking handler is answered with a "500 internal error" instead of crashing the service.
+func (p *workerPool) run(task poolTask) {
+	p.active.Add(1)
+	defer p.active.Add(-1)
+
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			RequestLogger(task.request).Error("service endpoint panicked", "panic", r)
+			if !task.request.responded() {
+				RespondError(task.request, NewServiceError(CodeInternalError, "internal error"))
+			}
+		}
+		p.record(task.request, time.Since(start))
+	}()
+	task.handle(task.request)
+}
+
+// record counts a request handled in elapsed
+func (p *workerPool) record(request *serviceRequest, elapsed time.Duration) {
+	code, description := responseError(request)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.numRequests++
+	p.processingTime += elapsed
+	if code != "" {
+		p.numErrors++
+		p.lastError = fmt.Sprintf("%s:%s", code, description)
+	}
+}
+
+// submit queues the handling of request, it returns false if all workers are busy and the queue is full.
+// A request submitted to a stopped pool is passed to reject.
+func (p *workerPool) submit(request *serviceRequest, handle, reject func(request *serviceRequest)) bool {
+	p.stopMu.Lock()
+	if p.stopped {
+		p.stopMu.Unlock()
+		reject(request)
+		return true
+	}
+	defer p.stopMu.Unlock()
+	select {
+	case p.queue <- poolTask{request: request, handle: handle, reject: reject}:
+		return true
+	default:
+		p.rejected.Add(1)
+		return false
+	}
+}
+
+// stop stops the workers, the requests still queued are passed to the reject func of their task
+func (p *workerPool) stop() {
+	p.stopMu.Lock()
+	if p.stopped {
+		p.stopMu.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.quit)
+	p.stopMu.Unlock()
+
+	for {
+		select {
+		case task := <-p.queue:
+			task.reject(task.request)
+		default:
+			return
+		}
+	}
+}
+
+func (p *workerPool) stats() *WorkerStats {
+	stats := &WorkerStats{
+		MaxConcurrency: p.maxConcurrency,
+		QueueSize:      cap(p.queue),
+		Active:         p.active.Load(),
+		Queued:         len(p.queue),
+		Rejected:       p.rejected.Load(),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats.NumRequests = p.numRequests
+	stats.NumErrors = p.numErrors
+	stats.LastError = p.lastError
+	stats.ProcessingTime = p.processingTime
+	if p.numRequests > 0 {
+		stats.AverageProcessingTime = p.processingTime / time.Duration(p.numRequests)
+	}
+	return stats
+}
+
+// detachedRequest is a micro.Request answering directly on the NATS connection.
+// Requests handled by a worker pool are detached from the micro request,
+// which must not be used once the micro handler has returned.
+type detachedRequest struct {
+	nc      *nats.Conn
+	subject string
+	reply   string
+	data    []byte
+	headers micro.Headers
+}
+
+var _ micro.Request = (*detachedRequest)(nil)
+
+func detachRequest(nc *nats.Conn, request micro.Request) *detachedRequest {
+	return &detachedRequest{
+		nc:      nc,
+		subject: request.Subject(),
+		reply:   request.Reply(),
+		data:    request.Data(),
+		headers: request.Headers(),
+	}
+}
+
+func (r *detachedRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	msg := &nats.Msg{
+		Data: data,
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	return r.publish(msg)
+}
+
+func (r *detachedRequest) RespondJSON(v any, opts ...micro.RespondOpt) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return micro.ErrMarshalResponse
+	}
+	return r.Respond(data, opts...)
+}
+
+func (r *detachedRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	if code == "" {
+		return fmt.Errorf("%w: error code", micro.ErrArgRequired)
+	}
+	if description == "" {
+		return fmt.Errorf("%w: description", micro.ErrArgRequired)
+	}
+	msg := &nats.Msg{
+		Header: nats.Header{
+			micro.ErrorHeader:     []string{description},
+			micro.ErrorCodeHeader: []string{code},
+		},
+	}
+	for _, opt := range opts {
+		opt(msg)
+	}
+	msg.Data = data
+	return r.publish(msg)
+}
+
+func (r *detachedRequest) publish(msg *nats.Msg) error {
+	if r.reply == "" {
+		return errors.New("request has no reply subject")
+	}
+	msg.Subject = r.reply
+	if err := r.nc.PublishMsg(msg); err != nil {
+		return fmt.Errorf("%w: %s", micro.ErrRespond, err)
+	}
+	return nil
+}
+
+func (r *detachedRequest) Data() []byte {
+	return r.data
+}
+
+func (r *detachedRequest) Headers() micro.Headers {
+	return r.headers
+}
+
+func (r *detachedRequest) Subject() string {
+	return r.subject
+}
+
+func (r *detachedRequest) Reply() string {
+	return r.reply
+}
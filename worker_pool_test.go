@@ -0,0 +1,180 @@
+package natsservice
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool_Overload(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "pooled", MaxConcurrency: 1, QueueSize: 1},
+		handle: func(request micro.Request) {
+			started <- struct{}{}
+			<-release
+			request.Respond([]byte("ok"))
+		},
+	})
+	require.NoError(t, err)
+
+	replies := make(chan *nats.Msg, 3)
+	send := func() {
+		go func() {
+			msg, err := nc.Request("test.pooled", nil, 2*time.Second)
+			if err == nil {
+				replies <- msg
+			}
+		}()
+	}
+
+	// First request occupies the worker, second one is queued
+	send()
+	<-started
+	send()
+	assert.Eventually(func() bool {
+		return svc.statsHandler(&micro.Endpoint{Name: "pooled"}).(EndpointStatsData).Workers.Queued == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Third request is rejected
+	send()
+	msg := <-replies
+	assert.Equal(CodeUnavailable, msg.Header.Get(micro.ErrorCodeHeader))
+	assert.Equal("overloaded", msg.Header.Get(micro.ErrorHeader))
+	assert.Equal("true", msg.Header.Get(ErrorRetryableHeader))
+	assert.NotEmpty(msg.Header.Get(RequestIDHeader), "rejections go through the request ID middleware")
+
+	// Worker stats are exposed in the service stats
+	stats := svc.Stats()
+	require.Len(t, stats.Endpoints, 1)
+	var data EndpointStatsData
+	require.NoError(t, json.Unmarshal(stats.Endpoints[0].Data, &data))
+	require.NotNil(t, data.Workers)
+	assert.Equal(1, data.Workers.MaxConcurrency)
+	assert.Equal(1, data.Workers.QueueSize)
+	assert.Equal(int64(1), data.Workers.Active)
+	assert.Equal(1, data.Workers.Queued)
+	assert.Equal(uint64(1), data.Workers.Rejected)
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-replies:
+			assert.Equal("ok", string(msg.Data))
+		case <-time.After(time.Second):
+			t.Fatal("queued request not handled")
+		}
+	}
+}
+
+func TestWorkerPool_StopRejectsQueued(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "pooled", MaxConcurrency: 1, QueueSize: 1},
+		handle: func(request micro.Request) {
+			started <- struct{}{}
+			<-release
+			request.Respond([]byte("ok"))
+		},
+	})
+	require.NoError(t, err)
+
+	go nc.Request("test.pooled", nil, 2*time.Second)
+	<-started
+	queued := make(chan *nats.Msg, 1)
+	go func() {
+		msg, err := nc.Request("test.pooled", nil, 2*time.Second)
+		if err == nil {
+			queued <- msg
+		}
+	}()
+	require.Eventually(t, func() bool {
+		return svc.statsHandler(&micro.Endpoint{Name: "pooled"}).(EndpointStatsData).Workers.Queued == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The shutdown times out on the running request, the queued one is rejected when the pool stops
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(svc.Shutdown(ctx), context.DeadlineExceeded)
+	select {
+	case msg := <-queued:
+		assert.Equal(CodeUnavailable, msg.Header.Get(micro.ErrorCodeHeader))
+		assert.Equal("service shutting down", msg.Header.Get(micro.ErrorHeader))
+		assert.NotEmpty(msg.Header.Get(RequestIDHeader))
+	case <-time.After(time.Second):
+		t.Fatal("queued request not answered")
+	}
+
+	// Every in-flight request is accounted for once the running one returns
+	close(release)
+	done := make(chan struct{})
+	go func() {
+		svc.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("in-flight requests not balanced")
+	}
+}
+
+func TestWorkerPool_StatsAndPanics(t *testing.T) {
+	assert := assert.New(t)
+
+	svc, nc := startTestService(t, nil)
+	err := svc.AddEndpoint(&testEndpoint{
+		config: &EndpointConfig{Name: "pooled", MaxConcurrency: 1},
+		handle: func(request micro.Request) {
+			switch string(request.Data()) {
+			case "fail":
+				RespondError(request, NewServiceError(CodeBadRequest, "invalid"))
+			case "panic":
+				panic("boom")
+			default:
+				time.Sleep(20 * time.Millisecond)
+				request.Respond([]byte("ok"))
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	msg, err := nc.Request("test.pooled", []byte("sleep"), time.Second)
+	require.NoError(t, err)
+	assert.Equal("ok", string(msg.Data))
+	msg, err = nc.Request("test.pooled", []byte("fail"), time.Second)
+	require.NoError(t, err)
+	assert.Equal(CodeBadRequest, msg.Header.Get(micro.ErrorCodeHeader))
+
+	// A panic is answered with an error, the worker keeps handling requests
+	msg, err = nc.Request("test.pooled", []byte("panic"), time.Second)
+	require.NoError(t, err)
+	assert.Equal(CodeInternalError, msg.Header.Get(micro.ErrorCodeHeader))
+	msg, err = nc.Request("test.pooled", []byte("sleep"), time.Second)
+	require.NoError(t, err)
+	assert.Equal("ok", string(msg.Data))
+
+	var workers *WorkerStats
+	require.Eventually(t, func() bool {
+		workers = svc.statsHandler(&micro.Endpoint{Name: "pooled"}).(EndpointStatsData).Workers
+		return workers.NumRequests == 4
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(2, workers.NumErrors)
+	assert.Equal("500:internal error", workers.LastError)
+	assert.GreaterOrEqual(workers.ProcessingTime, 40*time.Millisecond)
+	assert.GreaterOrEqual(workers.AverageProcessingTime, 10*time.Millisecond)
+}